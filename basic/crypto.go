@@ -0,0 +1,212 @@
+package basic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 密文格式: enc1:<密钥指纹>:<base64(nonce + 密文)>, 密钥指纹用于轮换后选择解密密钥
+const cipherPrefix = "enc1:"
+
+var (
+	ErrCiphertextTampered = errors.New("ciphertext tampered or wrong key")
+	ErrUnknownKey         = errors.New("ciphertext encrypted by unknown key")
+	ErrNotEncrypted       = errors.New("value is not encrypted")
+)
+
+// IKeyProvider 提供 AES-256 密钥
+type IKeyProvider interface {
+	Key() ([]byte, error)
+}
+
+type staticKeyProvider struct {
+	key []byte
+}
+
+// StaticKey 使用固定密钥, 32 字节时直接使用, 其他长度视为口令派生密钥
+func StaticKey(key []byte) IKeyProvider {
+	return &staticKeyProvider{key: key}
+}
+
+func (p *staticKeyProvider) Key() ([]byte, error) {
+	if len(p.key) == 0 {
+		return nil, fmt.Errorf("static key is empty")
+	}
+	if len(p.key) == 32 {
+		return p.key, nil
+	}
+	return derive_key(p.key, "static")
+}
+
+type fileKeyProvider struct {
+	path string
+}
+
+// KeyFile 从文件读取密钥, 文件内容为 64 位十六进制或 base64 编码的 32 字节密钥; 文件不存在时生成随机密钥并以 0600 权限写入
+func KeyFile(path string) IKeyProvider {
+	return &fileKeyProvider{path: path}
+}
+
+func (p *fileKeyProvider) Key() ([]byte, error) {
+	data, err := os.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := TouchDir(filepath.Dir(p.path)); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(p.path, []byte(hex.EncodeToString(key)), 0600); err != nil {
+			return nil, fmt.Errorf("write key file %s failed: %w", p.path, err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read key file %s failed: %w", p.path, err)
+	}
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("key file %s must contain 32 bytes in hex or base64", p.path)
+}
+
+type deviceKeyProvider struct {
+	salt string
+}
+
+// DeviceKey 使用 GetDeviceID 派生密钥, 数据只能在本机解密; salt 用于区分不同用途的密钥
+func DeviceKey(salt string) IKeyProvider {
+	return &deviceKeyProvider{salt: salt}
+}
+
+func (p *deviceKeyProvider) Key() ([]byte, error) {
+	id, err := GetDeviceID()
+	if err != nil {
+		return nil, fmt.Errorf("get device id failed: %w", err)
+	}
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return nil, fmt.Errorf("device id is empty")
+	}
+	return derive_key([]byte(id), "device:"+p.salt)
+}
+
+func derive_key(secret []byte, info string) ([]byte, error) {
+	return hkdf.Key(sha256.New, secret, nil, "go-lts-core:"+info, 32)
+}
+
+// Cipher AES-GCM 加解密, 使用当前密钥加密, 当前密钥和旧密钥都可以解密
+type Cipher struct {
+	current string                 // 当前密钥指纹
+	aeads   map[string]cipher.AEAD // key: 密钥指纹
+}
+
+// NewCipher 创建加解密器, previous 为轮换前使用过的密钥
+func NewCipher(current IKeyProvider, previous ...IKeyProvider) (*Cipher, error) {
+	c := &Cipher{aeads: make(map[string]cipher.AEAD)}
+	for i, provider := range append([]IKeyProvider{current}, previous...) {
+		if provider == nil {
+			return nil, fmt.Errorf("key provider is nil")
+		}
+		key, err := provider.Key()
+		if err != nil {
+			return nil, err
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		id := key_fingerprint(key)
+		if i == 0 {
+			c.current = id
+		}
+		c.aeads[id] = aead
+	}
+	return c, nil
+}
+
+// key_fingerprint 密钥指纹, 不泄露密钥
+func key_fingerprint(key []byte) string {
+	hash := sha256.Sum256(append([]byte("go-lts-core:fingerprint:"), key...))
+	return hex.EncodeToString(hash[:4])
+}
+
+// Encrypt 加密, aad 为附加认证数据, 解密时必须一致, 例如存储的 key, 防止密文被挪用
+func (c *Cipher) Encrypt(plaintext []byte, aad []byte) (string, error) {
+	aead := c.aeads[c.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, aad)
+	return cipherPrefix + c.current + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 解密, 密文被篡改或密钥不匹配时返回 ErrCiphertextTampered
+func (c *Cipher) Decrypt(text string, aad []byte) ([]byte, error) {
+	if !IsEncrypted(text) {
+		return nil, ErrNotEncrypted
+	}
+	id, payload, ok := strings.Cut(strings.TrimPrefix(text, cipherPrefix), ":")
+	if !ok {
+		return nil, ErrCiphertextTampered
+	}
+	aead, ok := c.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(payload)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, ErrCiphertextTampered
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, ErrCiphertextTampered
+	}
+	return plaintext, nil
+}
+
+// NeedsRotation 判断值是否需要使用当前密钥重新加密, 明文和旧密钥的密文都需要
+func (c *Cipher) NeedsRotation(text string) bool {
+	if !IsEncrypted(text) {
+		return true
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(text, cipherPrefix), ":")
+	return id != c.current
+}
+
+// Rotate 使用当前密钥重新加密, 明文直接加密
+func (c *Cipher) Rotate(text string, aad []byte) (string, error) {
+	if !IsEncrypted(text) {
+		return c.Encrypt([]byte(text), aad)
+	}
+	plaintext, err := c.Decrypt(text, aad)
+	if err != nil {
+		return "", err
+	}
+	return c.Encrypt(plaintext, aad)
+}
+
+// IsEncrypted 判断值是否为 Cipher 生成的密文
+func IsEncrypted(text string) bool {
+	return strings.HasPrefix(text, cipherPrefix)
+}
@@ -9,22 +9,8 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
-	"syscall"
-	"unsafe"
 )
 
-type memoryStatusEx struct {
-	Length               uint32
-	MemoryLoad           uint32
-	TotalPhys            uint64
-	AvailPhys            uint64
-	TotalPageFile        uint64
-	AvailPageFile        uint64
-	TotalVirtual         uint64
-	AvailVirtual         uint64
-	AvailExtendedVirtual uint64
-}
-
 func GetAvailableMemory() uint64 {
 	switch runtime.GOOS {
 	case "linux", "darwin":
@@ -49,17 +35,7 @@ func GetAvailableMemory() uint64 {
 		}
 		return 0
 	case "windows":
-		kernel32 := syscall.NewLazyDLL("kernel32.dll")
-		globalMemoryStatusEx := kernel32.NewProc("GlobalMemoryStatusEx")
-
-		var memStatus memoryStatusEx
-		memStatus.Length = uint32(unsafe.Sizeof(memStatus))
-
-		ret, _, _ := globalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&memStatus)))
-		if ret == 0 {
-			return 0
-		}
-		return memStatus.AvailPhys
+		return get_windows_available_memory()
 	default:
 		return 0
 	}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package basic
+
+// 非 Windows 平台不会调用
+func get_windows_available_memory() uint64 {
+	return 0
+}
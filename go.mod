@@ -5,6 +5,7 @@ go 1.24.1
 require (
 	github.com/jmoiron/sqlx v1.4.0
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1
+	gopkg.in/yaml.v3 v3.0.1
 	modernc.org/sqlite v1.37.0
 )
 
@@ -1,6 +1,7 @@
 package lts
 
 import (
+	"github.com/sssxyd/go-lts-core/basic"
 	"github.com/sssxyd/go-lts-core/rdbms"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
@@ -17,6 +18,9 @@ type DBConfig struct {
 	DBUrl      string
 	Statements []string
 	Tables     []rdbms.ITable
+	Attach     map[string]string // 附加的数据库文件, key: schema, 表模型使用 schema.table 作为表名
+	Replica    string            // 只读副本文件, 读连接池使用副本, 写连接使用主库
+	Fixtures   []string          // 启动时加载的种子数据文件或目录, 每行数据只写入一次, 见 rdbms.LoadFixtures
 }
 
 type LogConfig struct {
@@ -28,7 +32,9 @@ type LogConfig struct {
 }
 
 type StorageConfig struct {
-	FilePath string `default:"./data/storage.db"` // 本地存储文件路径
+	FilePath    string               `default:"./data/storage.db"` // 本地存储文件路径
+	EncryptKey  basic.IKeyProvider   // 存储值的加密密钥, 为空时明文存储; 例如 basic.DeviceKey("storage")
+	DecryptKeys []basic.IKeyProvider // 轮换前使用过的密钥, 启动时使用 EncryptKey 重新加密旧数据
 }
 
 type Options struct {
@@ -49,15 +55,21 @@ func Initialize(options *Options) {
 
 	// 初始化本地存储
 	if options.StorageConfig.FilePath != "" {
-		localStorage = initialize_sqlite_local_storage(options.StorageConfig.FilePath)
+		localStorage = initialize_sqlite_local_storage(options.StorageConfig.FilePath, options.StorageConfig.EncryptKey, options.StorageConfig.DecryptKeys...)
 	}
 
 	// 初始化数据库
 	for _, dbConfig := range options.DBConfigs {
-		_, err := rdbms.NewDataSource(dbConfig.Id, dbConfig.DBUrl, dbConfig.Statements, dbConfig.Tables)
+		dbUrl := rdbms.AttachURL(dbConfig.DBUrl, dbConfig.Attach, dbConfig.Replica)
+		ds, err := rdbms.NewDataSource(dbConfig.Id, dbUrl, dbConfig.Statements, dbConfig.Tables)
 		if err != nil {
 			panic(err)
 		}
+		if len(dbConfig.Fixtures) > 0 {
+			if _, err := rdbms.LoadFixtures(ds, dbConfig.Fixtures, rdbms.WithFixtureOnce()); err != nil {
+				panic(err)
+			}
+		}
 	}
 }
 
@@ -80,10 +92,6 @@ func GetDao() rdbms.IDao {
 	return NewDao("")
 }
 
-func NewDao(dataSourceId string) rdbms.IDao {
-	ds := rdbms.GetDataSource(dataSourceId)
-	if ds == nil {
-		return nil
-	}
-	return ds.NewDao()
+func NewDao(dataSourceId string, opts ...rdbms.DaoOption) rdbms.IDao {
+	return rdbms.NewDao(dataSourceId, opts...)
 }
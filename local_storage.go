@@ -6,6 +6,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jmoiron/sqlx"
+	"github.com/sssxyd/go-lts-core/basic"
 	"github.com/sssxyd/go-lts-core/rdbms"
 )
 
@@ -13,7 +15,8 @@ const local_storage_datasource_id = "_local_storage"
 const permanent_unix_time = 4891334400 // 2125-01-01 00:00:00
 
 type StorageModel struct {
-	ID         string `db:"id"`
+	rdbms.Table
+	ID         int64  `db:"id,pk"`
 	StoreKey   string `db:"store_key"`
 	StoreValue string `db:"store_value"`
 	ExpiredAt  int64  `db:"expired_at"`
@@ -23,23 +26,12 @@ func (s *StorageModel) TableName() string {
 	return "storage"
 }
 
-func (s *StorageModel) PrimaryInt64Key() string {
-	return "id"
-}
-
-func (s *StorageModel) DeleteInt64Key() string {
-	return ""
-}
-
-func (s *StorageModel) AutoUpdateKeys() []string {
-	return []string{}
-}
-
 type LocalStorage struct {
-	dao rdbms.IDao
+	dao    rdbms.IDao
+	cipher *basic.Cipher // 为空时明文存储
 }
 
-func initialize_sqlite_local_storage(storageFilePath string) *LocalStorage {
+func initialize_sqlite_local_storage(storageFilePath string, encryptKey basic.IKeyProvider, decryptKeys ...basic.IKeyProvider) *LocalStorage {
 	storageFilePath = strings.ReplaceAll(storageFilePath, "\\", "/")
 	statements := []string{
 		`CREATE TABLE IF NOT EXISTS "storage" (
@@ -57,55 +49,109 @@ func initialize_sqlite_local_storage(storageFilePath string) *LocalStorage {
 		);`,
 	}
 	tables := []rdbms.ITable{&StorageModel{}}
-	ds, err := rdbms.NewDataSource(local_storage_datasource_id, fmt.Sprintf("sqlite:%s", storageFilePath), statements, tables)
+	_, err := rdbms.NewDataSource(local_storage_datasource_id, fmt.Sprintf("sqlite:%s", storageFilePath), statements, tables)
 	if err != nil {
 		log.Printf("failed to create local storage data source: %v\n", err)
 		panic(err)
 	}
-	dao := ds.NewDao()
+	dao := rdbms.NewDao(local_storage_datasource_id)
 	instance := &LocalStorage{dao: dao}
+	if encryptKey != nil {
+		cipher, err := basic.NewCipher(encryptKey, decryptKeys...)
+		if err != nil {
+			log.Printf("failed to create local storage cipher: %v\n", err)
+			panic(err)
+		}
+		instance.cipher = cipher
+	}
 	instance.clear_expired_items()
+	if err := instance.encrypt_items(); err != nil {
+		log.Printf("failed to encrypt local storage: %v\n", err)
+		panic(err)
+	}
 
 	return instance
 }
 
 func (l *LocalStorage) clear_expired_items() {
-	sql := "SELECT id FROM storage WHERE expired_at < ?"
-	rows, err := l.dao.Conn().Query(sql, time.Now().Unix())
-	if err != nil {
+	ids, err := rdbms.QueryAll[int64](l.dao, "SELECT id FROM storage WHERE expired_at < ?", time.Now().Unix())
+	if err != nil || len(ids) == 0 {
 		return
 	}
-	defer rows.Close()
-	ids := []int64{}
-	for rows.Next() {
-		var id int64
-		err = rows.Scan(&id)
-		if err != nil {
-			continue
+	l.dao.TableDelete("storage", rdbms.SqlToParams(ids)...)
+}
+
+// encrypt_items 加密明文存储的值, 并使用当前密钥重新加密旧密钥的密文;
+// 有更新时执行 VACUUM 并截断 WAL, 清除空闲页和 WAL 中残留的旧值
+func (l *LocalStorage) encrypt_items() error {
+	if l.cipher == nil {
+		return nil
+	}
+	count := 0
+	err := l.dao.Write(func(conn sqlx.Ext) error {
+		models := []StorageModel{}
+		if err := sqlx.Select(conn, &models, "SELECT * FROM storage"); err != nil {
+			return err
 		}
-		ids = append(ids, id)
+		for _, model := range models {
+			if !l.cipher.NeedsRotation(model.StoreValue) {
+				continue
+			}
+			value, err := l.cipher.Rotate(model.StoreValue, []byte(model.StoreKey))
+			if err != nil {
+				return fmt.Errorf("re-encrypt storage key %s failed: %w", model.StoreKey, err)
+			}
+			if _, err := conn.Exec("UPDATE storage SET store_value = ? WHERE id = ?", value, model.ID); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil || count == 0 {
+		return err
+	}
+	log.Printf("LocalStorage encrypted %d items\n", count)
+	if _, err := l.dao.Exec("VACUUM"); err != nil {
+		return err
+	}
+	_, err = rdbms.GetDataSource(local_storage_datasource_id).RunMaintenance(rdbms.MaintenanceCheckpoint)
+	return err
+}
+
+// encrypt_value 加密存储值, 使用存储的 key 作为附加认证数据, 防止密文被挪到其他 key 下
+func (l *LocalStorage) encrypt_value(key string, value string) (string, error) {
+	if l.cipher == nil {
+		return value, nil
 	}
-	l.dao.TableDelete("storage", ids...)
+	return l.cipher.Encrypt([]byte(value), []byte(key))
+}
+
+// decrypt_value 解密存储值, 密文被篡改时返回 basic.ErrCiphertextTampered
+func (l *LocalStorage) decrypt_value(key string, value string) (string, error) {
+	if l.cipher == nil {
+		return value, nil
+	}
+	plaintext, err := l.cipher.Decrypt(value, []byte(key))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
 }
 
 func (l *LocalStorage) Remove(keys ...string) int {
-	sql := "SELECT id FROM storage WHERE store_key IN " + rdbms.SqlInValues(len(keys))
-	rows, err := l.dao.Conn().Query(sql, rdbms.SqlToParams(keys)...)
+	if len(keys) == 0 {
+		return 0
+	}
+	ids, err := rdbms.QueryAll[int64](l.dao, "SELECT id FROM storage WHERE store_key IN (?)", keys)
 	if err != nil {
+		log.Printf("LocalStorage Remove error: %v\n", err)
 		return 0
 	}
-	defer rows.Close()
-	ids := []int64{}
-	for rows.Next() {
-		var id int64
-		err = rows.Scan(&id)
-		if err != nil {
-			log.Printf("LocalStorage Remove error: %v\n", err)
-			continue
-		}
-		ids = append(ids, id)
+	if len(ids) == 0 {
+		return 0
 	}
-	count, err := l.dao.TableDelete("storage", ids...)
+	count, err := l.dao.TableDelete("storage", rdbms.SqlToParams(ids)...)
 	if err != nil {
 		log.Printf("LocalStorage Remove error: %v\n", err)
 	}
@@ -113,29 +159,36 @@ func (l *LocalStorage) Remove(keys ...string) int {
 }
 
 func (l *LocalStorage) Get(key string) string {
-	model := &StorageModel{}
-
 	sql := "SELECT * FROM storage WHERE store_key = ? AND expired_at >= ? ORDER BY id DESC LIMIT 1"
-	err := l.dao.Conn().Get(model, sql, key, time.Now().Unix())
+	model, err := rdbms.QueryOne[StorageModel](l.dao, sql, key, time.Now().Unix())
+	if err != nil {
+		return ""
+	}
+	value, err := l.decrypt_value(key, model.StoreValue)
 	if err != nil {
+		log.Printf("LocalStorage Get %s error: %v\n", key, err)
 		return ""
 	}
-	return model.StoreValue
+	return value
 }
 
 func (l *LocalStorage) MGet(keys ...string) map[string]string {
 	if len(keys) == 0 {
 		return map[string]string{}
 	}
-	models := []StorageModel{}
-	sql := "SELECT * FROM storage WHERE store_key IN " + rdbms.SqlInValues(len(keys)) + " AND expired_at >= ?"
-	err := l.dao.Conn().Select(&models, sql, rdbms.SqlToParams(keys), time.Now().Unix())
+	sql := "SELECT * FROM storage WHERE store_key IN (?) AND expired_at >= ?"
+	models, err := rdbms.QueryAll[StorageModel](l.dao, sql, keys, time.Now().Unix())
 	if err != nil {
 		return map[string]string{}
 	}
 	result := map[string]string{}
 	for _, model := range models {
-		result[model.StoreKey] = model.StoreValue
+		value, err := l.decrypt_value(model.StoreKey, model.StoreValue)
+		if err != nil {
+			log.Printf("LocalStorage MGet %s error: %v\n", model.StoreKey, err)
+			continue
+		}
+		result[model.StoreKey] = value
 	}
 	return result
 }
@@ -145,6 +198,11 @@ func (l *LocalStorage) Set(key string, value string) {
 }
 
 func (l *LocalStorage) SetEx(key string, value string, expiredAt int64) {
+	value, err := l.encrypt_value(key, value)
+	if err != nil {
+		log.Printf("LocalStorage SetEx %s error: %v\n", key, err)
+		return
+	}
 	l.Remove(key)
 
 	if expiredAt <= 0 {
@@ -173,13 +231,14 @@ func (l *LocalStorage) MSetEx(data map[string]string, expiredAt int64) {
 	}
 
 	keys := []string{}
-	for key := range data {
-		keys = append(keys, key)
-	}
-	l.Remove(keys...)
-
 	models := []StorageModel{}
 	for key, value := range data {
+		value, err := l.encrypt_value(key, value)
+		if err != nil {
+			log.Printf("LocalStorage MSetEx %s error: %v\n", key, err)
+			return
+		}
+		keys = append(keys, key)
 		store := &StorageModel{
 			StoreKey:   key,
 			StoreValue: value,
@@ -187,5 +246,6 @@ func (l *LocalStorage) MSetEx(data map[string]string, expiredAt int64) {
 		}
 		models = append(models, *store)
 	}
+	l.Remove(keys...)
 	l.dao.TableInsert(rdbms.ModelToTables(models)...)
 }
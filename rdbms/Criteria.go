@@ -0,0 +1,112 @@
+package rdbms
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Criteria 表查询条件, 配合 IDao.TableFind 使用
+//
+//	criteria := NewCriteria().Where("status = ?", 1).OrderBy("id DESC").Limit(10).Preload("Items")
+type Criteria struct {
+	conditions []string
+	args       []interface{}
+	orderBy    string
+	limit      int
+	offset     int
+	preloads   []string
+	from, to   time.Time // 分区表的时间范围, 见 Between
+	err        error     // 构造条件时的错误, 例如非法的 JSON 字段名, 查询时返回
+}
+
+func NewCriteria() *Criteria {
+	return &Criteria{}
+}
+
+// Where 追加查询条件, 多个条件之间为 AND
+func (c *Criteria) Where(condition string, args ...interface{}) *Criteria {
+	c.conditions = append(c.conditions, condition)
+	c.args = append(c.args, args...)
+	return c
+}
+
+func (c *Criteria) OrderBy(orderBy string) *Criteria {
+	c.orderBy = orderBy
+	return c
+}
+
+func (c *Criteria) Limit(limit int) *Criteria {
+	c.limit = limit
+	return c
+}
+
+func (c *Criteria) Offset(offset int) *Criteria {
+	c.offset = offset
+	return c
+}
+
+// Preload 查询后加载关联, 参数为关联字段名, 嵌套关联使用 . 分隔, 例如 Items.Product
+func (c *Criteria) Preload(relations ...string) *Criteria {
+	c.preloads = append(c.preloads, relations...)
+	return c
+}
+
+// Between 限定分区表的时间范围 [from, to), 只查询范围内的分区; 零值表示不限制, 只能用于分区表
+func (c *Criteria) Between(from, to time.Time) *Criteria {
+	c.from, c.to = from, to
+	return c
+}
+
+func (c *Criteria) ranged() bool {
+	return !c.from.IsZero() || !c.to.IsZero()
+}
+
+func (c *Criteria) Args() []interface{} {
+	return c.args
+}
+
+// Err 构造条件时的第一个错误
+func (c *Criteria) Err() error {
+	return c.err
+}
+
+// fail 记录构造条件时的第一个错误
+func (c *Criteria) fail(err error) *Criteria {
+	if c.err == nil {
+		c.err = err
+	}
+	return c
+}
+
+// toSql 生成查询语句, 逻辑删除的表自动过滤已删除的数据; 构造条件时出错则返回该错误
+func (c *Criteria) toSql(ts *TableSpec) (string, error) {
+	if c.err != nil {
+		return "", c.err
+	}
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("SELECT %s FROM %s", strings.Join(ts.dbTags, ","), ts.tableName))
+
+	conditions := make([]string, 0, len(c.conditions)+1)
+	for _, condition := range c.conditions {
+		conditions = append(conditions, "("+condition+")")
+	}
+	if ts.IsLogicDelete() {
+		conditions = append(conditions, fmt.Sprintf("%s = 0", ts.deleteInt64Key))
+	}
+	if len(conditions) > 0 {
+		builder.WriteString(" WHERE ")
+		builder.WriteString(strings.Join(conditions, " AND "))
+	}
+	if c.orderBy != "" {
+		builder.WriteString(" ORDER BY ")
+		builder.WriteString(c.orderBy)
+	}
+	if c.limit > 0 {
+		builder.WriteString(fmt.Sprintf(" LIMIT %d", c.limit))
+		if c.offset > 0 {
+			builder.WriteString(fmt.Sprintf(" OFFSET %d", c.offset))
+		}
+	}
+	return builder.String(), nil
+}
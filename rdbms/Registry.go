@@ -0,0 +1,406 @@
+package rdbms
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Registry 数据源注册表, 并发安全; 同一进程可以创建多个互相独立的注册表, 例如并行测试;
+// 包级函数 NewDataSource、GetDataSource、Close 使用默认注册表
+//
+// 使用已存在的 ID 创建数据源时热替换: 新的调用立即使用新数据源, 旧数据源在进行中的调用完成后关闭;
+// Registry.NewDao 创建的 DAO 每次调用时解析当前数据源, 替换后自动切换
+type Registry struct {
+	lock    sync.RWMutex
+	mainId  string                    // 主数据源 ID, 第一个不以 _ 开头的数据源, 移除后按 ID 顺序重新选择
+	sources map[string]*registryEntry // key: 数据源 ID
+}
+
+type registryEntry struct {
+	ds      IDataSource
+	lock    sync.Mutex
+	refs    int  // 进行中的调用数量
+	retired bool // 已被替换或移除, 调用全部完成后关闭
+	once    sync.Once
+	closed  chan struct{}
+}
+
+var defaultRegistry = NewRegistry()
+
+// DefaultRegistry 返回包级函数使用的默认注册表
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{sources: make(map[string]*registryEntry)}
+}
+
+// NewDataSource 打开数据源并注册, ID 已存在时替换旧数据源
+func (r *Registry) NewDataSource(id string, db_url string, statements []string, tables []ITable) (IDataSource, error) {
+	if id == "" || db_url == "" {
+		return nil, fmt.Errorf("id or url is empty")
+	}
+	dbUrl, err := parse_db_url(db_url)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := open_data_source(id, dbUrl, statements, tables)
+	if err != nil {
+		return nil, err
+	}
+	r.Register(id, ds)
+	return ds, nil
+}
+
+// Register 注册已打开的数据源, ID 已存在时替换旧数据源;
+// 新数据源在旧数据源退役前已经打开, 替换为同一个 SQLite 文件时, 旧数据源的进行中调用完成前两个写协程同时存在,
+// 写入由 SQLite 的文件锁串行化, 需要设置 busy_timeout, 否则切换期间的写入可能返回 SQLITE_BUSY
+func (r *Registry) Register(id string, ds IDataSource) {
+	r.lock.Lock()
+	old := r.sources[id]
+	r.sources[id] = &registryEntry{ds: ds, closed: make(chan struct{})}
+	if r.mainId == "" && !strings.HasPrefix(id, "_") {
+		r.mainId = id
+	}
+	r.lock.Unlock()
+	if old != nil {
+		old.retire()
+	}
+}
+
+// Get 返回数据源, id 为空时返回主数据源; 返回的数据源不受引用计数保护, 被替换或移除时立即关闭,
+// 使用它创建的 DAO 不会随替换切换, 进行中的调用可能因数据源关闭而失败; 需要热替换保护时使用 NewDao
+func (r *Registry) Get(id string) IDataSource {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if id == "" {
+		id = r.mainId
+	}
+	if entry := r.sources[id]; entry != nil {
+		return entry.ds
+	}
+	return nil
+}
+
+// Ids 返回已注册的数据源 ID
+func (r *Registry) Ids() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return sorted_keys(r.sources)
+}
+
+// NewDao 创建绑定数据源 ID 的 DAO, id 为空时使用主数据源, 数据源不存在时返回 nil
+func (r *Registry) NewDao(id string, opts ...DaoOption) IDao {
+	r.lock.RLock()
+	if id == "" {
+		id = r.mainId
+	}
+	_, ok := r.sources[id]
+	r.lock.RUnlock()
+	if !ok {
+		return nil
+	}
+	return &registryDao{registry: r, id: id, options: opts}
+}
+
+// Remove 注销数据源, 等待进行中的调用完成后关闭
+func (r *Registry) Remove(id string) {
+	r.lock.Lock()
+	entry := r.sources[id]
+	delete(r.sources, id)
+	if r.mainId == id {
+		r.mainId = r.next_main_id()
+	}
+	r.lock.Unlock()
+	if entry != nil {
+		entry.retire()
+		<-entry.closed
+	}
+}
+
+// next_main_id 主数据源被移除后, 按 ID 顺序选择第一个不以 _ 开头的数据源作为主数据源, 调用方持有锁
+func (r *Registry) next_main_id() string {
+	for _, id := range sorted_keys(r.sources) {
+		if !strings.HasPrefix(id, "_") {
+			return id
+		}
+	}
+	return ""
+}
+
+// Close 注销并关闭所有数据源
+func (r *Registry) Close() {
+	r.lock.Lock()
+	entries := make([]*registryEntry, 0, len(r.sources))
+	for _, entry := range r.sources {
+		entries = append(entries, entry)
+	}
+	r.sources = make(map[string]*registryEntry)
+	r.mainId = ""
+	r.lock.Unlock()
+	for _, entry := range entries {
+		entry.retire()
+	}
+	for _, entry := range entries {
+		<-entry.closed
+	}
+}
+
+// collectors 返回实现了 Collector 的数据源, 按 ID 排序
+func (r *Registry) collectors() []Collector {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	collectors := make([]Collector, 0, len(r.sources))
+	for _, id := range sorted_keys(r.sources) {
+		if collector, ok := r.sources[id].ds.(Collector); ok {
+			collectors = append(collectors, collector)
+		}
+	}
+	return collectors
+}
+
+// acquire 返回当前数据源并增加进行中的调用数量, 调用完成后 release
+func (r *Registry) acquire(id string) (*registryEntry, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	entry := r.sources[id]
+	if entry == nil {
+		return nil, fmt.Errorf("data source[%s] not found", id)
+	}
+	entry.lock.Lock()
+	entry.refs++
+	entry.lock.Unlock()
+	return entry, nil
+}
+
+func (e *registryEntry) release() {
+	e.lock.Lock()
+	e.refs--
+	idle := e.retired && e.refs == 0
+	e.lock.Unlock()
+	if idle {
+		e.close()
+	}
+}
+
+func (e *registryEntry) retire() {
+	e.lock.Lock()
+	e.retired = true
+	idle := e.refs == 0
+	e.lock.Unlock()
+	if idle {
+		e.close()
+	}
+}
+
+func (e *registryEntry) close() {
+	e.once.Do(func() {
+		e.ds.Close()
+		close(e.closed)
+	})
+}
+
+// registryDao 每次调用时解析注册表中的当前数据源, 调用期间数据源不会被关闭
+type registryDao struct {
+	registry *Registry
+	id       string
+	options  []DaoOption
+	parent   *registryDao // Consistent 派生的 DAO 与原 DAO 共享状态
+	derive   func(dao IDao) IDao
+	lock     sync.Mutex
+	entry    *registryEntry
+	dao      IDao // 当前数据源创建的 DAO, 数据源替换后重新创建
+}
+
+func (d *registryDao) acquire() (*registryEntry, IDao, error) {
+	if d.parent != nil {
+		entry, dao, err := d.parent.acquire()
+		if err != nil {
+			return nil, nil, err
+		}
+		return entry, d.derive(dao), nil
+	}
+	entry, err := d.registry.acquire(d.id)
+	if err != nil {
+		return nil, nil, err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.entry != entry {
+		d.entry = entry
+		d.dao = entry.ds.NewDao(d.options...)
+	}
+	return entry, d.dao, nil
+}
+
+// use 在当前数据源的 DAO 上执行 fn
+func (d *registryDao) use(fn func(dao IDao) error) error {
+	entry, dao, err := d.acquire()
+	if err != nil {
+		return err
+	}
+	defer entry.release()
+	return fn(dao)
+}
+
+func (d *registryDao) getModelSpec(model interface{}) (*TableSpec, error) {
+	var ts *TableSpec
+	err := d.use(func(dao IDao) error {
+		finder, ok := dao.(interface {
+			getModelSpec(model interface{}) (*TableSpec, error)
+		})
+		if !ok {
+			return fmt.Errorf("data source[%s] does not support table spec", d.id)
+		}
+		var err error
+		ts, err = finder.getModelSpec(model)
+		return err
+	})
+	return ts, err
+}
+
+func (d *registryDao) DataSourceId() string {
+	return d.id
+}
+
+func (d *registryDao) Rollback() error {
+	return d.use(func(dao IDao) error { return dao.Rollback() })
+}
+
+func (d *registryDao) Commit() error {
+	return d.use(func(dao IDao) error { return dao.Commit() })
+}
+
+func (d *registryDao) Close() error {
+	return nil
+}
+
+func (d *registryDao) Create(statement string) error {
+	return d.use(func(dao IDao) error { return dao.Create(statement) })
+}
+
+func (d *registryDao) TableInsert(models ...ITable) ([]int64, error) {
+	var ids []int64
+	err := d.use(func(dao IDao) (err error) {
+		ids, err = dao.TableInsert(models...)
+		return err
+	})
+	return ids, err
+}
+
+func (d *registryDao) TableUpdate(models ...ITable) (int64, error) {
+	var count int64
+	err := d.use(func(dao IDao) (err error) {
+		count, err = dao.TableUpdate(models...)
+		return err
+	})
+	return count, err
+}
+
+func (d *registryDao) TableUpdateColumns(model ITable, columns ...string) (int64, error) {
+	var count int64
+	err := d.use(func(dao IDao) (err error) {
+		count, err = dao.TableUpdateColumns(model, columns...)
+		return err
+	})
+	return count, err
+}
+
+func (d *registryDao) TableDelete(tableName string, keys ...interface{}) (int64, error) {
+	var count int64
+	err := d.use(func(dao IDao) (err error) {
+		count, err = dao.TableDelete(tableName, keys...)
+		return err
+	})
+	return count, err
+}
+
+func (d *registryDao) TableGet(emptyTableModel interface{}, key interface{}) error {
+	return d.use(func(dao IDao) error { return dao.TableGet(emptyTableModel, key) })
+}
+
+func (d *registryDao) TableSelect(emptyTableSlice interface{}, keys ...interface{}) error {
+	return d.use(func(dao IDao) error { return dao.TableSelect(emptyTableSlice, keys...) })
+}
+
+func (d *registryDao) TableFind(emptyTableSlice interface{}, criteria *Criteria) error {
+	return d.use(func(dao IDao) error { return dao.TableFind(emptyTableSlice, criteria) })
+}
+
+func (d *registryDao) TablePreload(models interface{}, relations ...string) error {
+	return d.use(func(dao IDao) error { return dao.TablePreload(models, relations...) })
+}
+
+func (d *registryDao) Search(emptyTableSlice interface{}, query string, opts *SearchOptions) ([]SearchHit, error) {
+	var hits []SearchHit
+	err := d.use(func(dao IDao) (err error) {
+		hits, err = dao.Search(emptyTableSlice, query, opts)
+		return err
+	})
+	return hits, err
+}
+
+func (d *registryDao) AuditHistory(emptyTableModel interface{}, key interface{}) ([]AuditRecord, error) {
+	var records []AuditRecord
+	err := d.use(func(dao IDao) (err error) {
+		records, err = dao.AuditHistory(emptyTableModel, key)
+		return err
+	})
+	return records, err
+}
+
+func (d *registryDao) AuditAsOf(emptyTableModel interface{}, key interface{}, at time.Time) error {
+	return d.use(func(dao IDao) error { return dao.AuditAsOf(emptyTableModel, key, at) })
+}
+
+func (d *registryDao) Read(fn func(conn sqlx.Queryer) error) error {
+	return d.use(func(dao IDao) error { return dao.Read(fn) })
+}
+
+func (d *registryDao) Write(fn func(conn sqlx.Ext) error) error {
+	return d.use(func(dao IDao) error { return dao.Write(fn) })
+}
+
+func (d *registryDao) Exec(statement string, args ...interface{}) (SqlResult, error) {
+	var result SqlResult
+	err := d.use(func(dao IDao) (err error) {
+		result, err = dao.Exec(statement, args...)
+		return err
+	})
+	return result, err
+}
+
+func (d *registryDao) QueryMap(statement string, args ...interface{}) ([]map[string]interface{}, error) {
+	var result []map[string]interface{}
+	err := d.use(func(dao IDao) (err error) {
+		result, err = dao.QueryMap(statement, args...)
+		return err
+	})
+	return result, err
+}
+
+func (d *registryDao) Consistent(consistency Consistency) IDao {
+	return &registryDao{
+		registry: d.registry,
+		id:       d.id,
+		parent:   d,
+		derive: func(dao IDao) IDao {
+			return dao.Consistent(consistency)
+		},
+	}
+}
+
+// Conn 返回当前数据源的读连接池, 不受替换保护
+func (d *registryDao) Conn() *sqlx.DB {
+	var conn *sqlx.DB
+	d.use(func(dao IDao) error {
+		conn = dao.Conn()
+		return nil
+	})
+	return conn
+}
@@ -0,0 +1,277 @@
+package rdbms
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sssxyd/go-lts-core/basic"
+)
+
+// SqliteConfig sqlite 数据源配置, 通过 DB URL 的参数设置, 例如:
+//
+//	sqlite:./data/app.db?journal_mode=WAL&synchronous=NORMAL&busy_timeout=5000&reader_pool_size=16
+type SqliteConfig struct {
+	JournalMode     string        // journal_mode, 默认 WAL
+	Synchronous     string        // synchronous, 写连接使用, 为空时使用 sqlite 默认值
+	BusyTimeout     int           // busy_timeout, 单位毫秒, 0 表示不设置
+	ForeignKeys     bool          // foreign_keys, 写连接是否启用外键约束
+	MmapSize        int64         // mmap_size, 读连接的内存映射大小, -1 表示根据文件大小和可用内存计算
+	MmapMax         uint64        // mmap_max, 自动计算 mmap_size 时的上限, 默认 64MB
+	CacheSize       int64         // cache_size, 读连接的缓存大小, 与 PRAGMA cache_size 含义相同, 0 表示自动计算
+	CacheMax        uint64        // cache_max, 自动计算 cache_size 时的上限, 单位字节, 0 表示不限制
+	SharedCache     bool          // shared_cache, 读连接是否使用共享缓存, 默认 true
+	ReaderPoolSize  int           // reader_pool_size, 读连接池最大连接数, 默认 CPU 数 * 10
+	ReaderIdleSize  int           // reader_idle_size, 读连接池最大空闲连接数, 默认 CPU 数
+	ConnMaxLifetime time.Duration // conn_max_lifetime, 读连接最大存活时间, 默认 6h
+	ConnMaxIdleTime time.Duration // conn_max_idle_time, 读连接最大空闲时间, 默认 10m
+	ReaderRefresh   time.Duration // reader_refresh_interval, 按文件大小重新计算 mmap_size/cache_size 的间隔, 默认 1m, 0 表示不检查
+	SlowQuery       time.Duration // slow_query_threshold, 超过该耗时的语句记录日志和执行计划, 0 表示不记录
+	AutoVacuum      string        // auto_vacuum, 只对新建的数据库生效, INCREMENTAL 时定期执行增量清理
+
+	// 附加数据库和只读副本
+	Attach  map[string]string // attach.<schema>, 每个连接附加的数据库文件, key: schema, 表名使用 schema.table 访问
+	Replica string            // replica, 读连接池使用的只读副本文件, 写连接使用主库, 副本由外部同步
+
+	// 定期维护, 0 表示不执行
+	CheckpointInterval     time.Duration // checkpoint_interval, wal_checkpoint(TRUNCATE) 间隔, 默认 5m
+	VacuumInterval         time.Duration // vacuum_interval, incremental_vacuum 间隔, 默认 1h
+	OptimizeInterval       time.Duration // optimize_interval, PRAGMA optimize 间隔, 默认 6h
+	IntegrityCheckInterval time.Duration // integrity_check_interval, integrity_check 间隔, 默认 0 不定期执行
+	PartitionRetention     time.Duration // partition_retention_interval, 删除过期分区的间隔, 默认 1h
+}
+
+var (
+	sqlite_journal_modes = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	sqlite_synchronous   = []string{"OFF", "NORMAL", "FULL", "EXTRA", "0", "1", "2", "3"}
+	sqlite_auto_vacuum   = []string{"NONE", "FULL", "INCREMENTAL", "0", "1", "2"}
+)
+
+func defaultSqliteConfig() *SqliteConfig {
+	cpuCount := basic.GetCpuCount()
+	if cpuCount < 1 {
+		cpuCount = 1
+	}
+	return &SqliteConfig{
+		JournalMode:     "WAL",
+		MmapSize:        -1,
+		MmapMax:         64 * 1024 * 1024,
+		SharedCache:     true,
+		ReaderPoolSize:  cpuCount * 10,
+		ReaderIdleSize:  cpuCount,
+		ConnMaxLifetime: 6 * time.Hour,
+		ConnMaxIdleTime: 10 * time.Minute,
+		ReaderRefresh:   time.Minute,
+
+		CheckpointInterval: 5 * time.Minute,
+		VacuumInterval:     time.Hour,
+		OptimizeInterval:   6 * time.Hour,
+		PartitionRetention: time.Hour,
+	}
+}
+
+// parse_sqlite_config 解析 DB URL 参数, 未知参数和非法取值返回错误
+func parse_sqlite_config(params map[string]string) (*SqliteConfig, error) {
+	config := defaultSqliteConfig()
+	var err error
+	for key, value := range params {
+		value = strings.TrimSpace(value)
+		switch key {
+		case "journal_mode":
+			config.JournalMode, err = parse_enum(key, value, sqlite_journal_modes)
+		case "synchronous":
+			config.Synchronous, err = parse_enum(key, value, sqlite_synchronous)
+		case "busy_timeout":
+			config.BusyTimeout, err = parse_int(key, value, 0)
+		case "foreign_keys":
+			config.ForeignKeys, err = parse_bool(key, value)
+		case "mmap_size":
+			config.MmapSize, err = strconv.ParseInt(value, 10, 64)
+			if err == nil && config.MmapSize < -1 {
+				err = fmt.Errorf("mmap_size must be >= -1")
+			}
+		case "mmap_max":
+			config.MmapMax, err = strconv.ParseUint(value, 10, 64)
+		case "cache_size":
+			config.CacheSize, err = strconv.ParseInt(value, 10, 64)
+		case "cache_max":
+			config.CacheMax, err = strconv.ParseUint(value, 10, 64)
+		case "shared_cache":
+			config.SharedCache, err = parse_bool(key, value)
+		case "reader_pool_size":
+			config.ReaderPoolSize, err = parse_int(key, value, 1)
+		case "reader_idle_size":
+			config.ReaderIdleSize, err = parse_int(key, value, 0)
+		case "conn_max_lifetime":
+			config.ConnMaxLifetime, err = parse_duration(key, value)
+		case "conn_max_idle_time":
+			config.ConnMaxIdleTime, err = parse_duration(key, value)
+		case "slow_query_threshold":
+			config.SlowQuery, err = parse_duration(key, value)
+		case "reader_refresh_interval":
+			config.ReaderRefresh, err = parse_duration(key, value)
+		case "auto_vacuum":
+			config.AutoVacuum, err = parse_enum(key, value, sqlite_auto_vacuum)
+		case "checkpoint_interval":
+			config.CheckpointInterval, err = parse_duration(key, value)
+		case "vacuum_interval":
+			config.VacuumInterval, err = parse_duration(key, value)
+		case "optimize_interval":
+			config.OptimizeInterval, err = parse_duration(key, value)
+		case "integrity_check_interval":
+			config.IntegrityCheckInterval, err = parse_duration(key, value)
+		case "partition_retention_interval":
+			config.PartitionRetention, err = parse_duration(key, value)
+		case "replica":
+			config.Replica = value
+		default:
+			if schema, ok := strings.CutPrefix(key, "attach."); ok {
+				err = config.add_attach(schema, value)
+			} else {
+				err = fmt.Errorf("unknown sqlite param: %s", key)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid sqlite param %s=%s: %w", key, value, err)
+		}
+	}
+	if config.ReaderIdleSize > config.ReaderPoolSize {
+		config.ReaderIdleSize = config.ReaderPoolSize
+	}
+	return config, nil
+}
+
+// add_attach 校验 schema 名称, schema 会拼接到 ATTACH 语句中
+func (config *SqliteConfig) add_attach(schema string, path string) error {
+	if !is_identifier(schema) {
+		return fmt.Errorf("invalid attach schema: %s", schema)
+	}
+	switch strings.ToLower(schema) {
+	case "main", "temp":
+		return fmt.Errorf("attach schema %s is reserved", schema)
+	}
+	if path == "" {
+		return fmt.Errorf("attach path is empty")
+	}
+	if config.Attach == nil {
+		config.Attach = make(map[string]string)
+	}
+	config.Attach[schema] = path
+	return nil
+}
+
+// readerPath 读连接池使用的文件, 配置了副本时使用副本
+func (config *SqliteConfig) readerPath(path string) string {
+	if config.Replica != "" {
+		return config.Replica
+	}
+	return path
+}
+
+// add_attach_params 附加数据库通过 _lts_attach 参数传给连接钩子, 见 attach.go
+func (config *SqliteConfig) add_attach_params(query url.Values) {
+	for _, schema := range sorted_keys(config.Attach) {
+		query.Add(attachParam, schema+"="+config.Attach[schema])
+	}
+}
+
+func parse_enum(key string, value string, options []string) (string, error) {
+	upper := strings.ToUpper(value)
+	for _, option := range options {
+		if upper == option {
+			return upper, nil
+		}
+	}
+	return "", fmt.Errorf("%s must be one of %v", key, options)
+}
+
+func parse_int(key string, value string, minimum int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n < minimum {
+		return 0, fmt.Errorf("%s must be >= %d", key, minimum)
+	}
+	return n, nil
+}
+
+func parse_bool(key string, value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "1", "true", "on", "yes":
+		return true, nil
+	case "0", "false", "off", "no":
+		return false, nil
+	default:
+		return false, fmt.Errorf("%s must be a boolean", key)
+	}
+}
+
+func parse_duration(key string, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s must be >= 0", key)
+	}
+	return d, nil
+}
+
+// writerDSN 写连接的 DSN, pragma 通过 _pragma 参数在连接建立时执行
+func (config *SqliteConfig) writerDSN(path string) string {
+	query := url.Values{}
+	if config.AutoVacuum != "" {
+		query.Add("_pragma", fmt.Sprintf("auto_vacuum(%s)", config.AutoVacuum))
+	}
+	query.Add("_pragma", fmt.Sprintf("journal_mode(%s)", config.JournalMode))
+	if config.Synchronous != "" {
+		query.Add("_pragma", fmt.Sprintf("synchronous(%s)", config.Synchronous))
+	}
+	if config.BusyTimeout > 0 {
+		query.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", config.BusyTimeout))
+	}
+	if config.ForeignKeys {
+		query.Add("_pragma", "foreign_keys(1)")
+	}
+	config.add_attach_params(query)
+	return "file:" + sqlite_uri_path(path) + "?" + query.Encode()
+}
+
+// readerDSN 读连接的 DSN, 每个连接池中的连接都会执行 pragma
+func (config *SqliteConfig) readerDSN(path string, mmapSize uint64, cacheSize int64) string {
+	query := url.Values{}
+	query.Set("mode", "ro")
+	if config.SharedCache {
+		query.Set("cache", "shared")
+	}
+	query.Add("_pragma", fmt.Sprintf("cache_size(%d)", cacheSize))
+	query.Add("_pragma", fmt.Sprintf("mmap_size(%d)", mmapSize))
+	query.Add("_pragma", "temp_store(MEMORY)")
+	query.Add("_pragma", "synchronous(OFF)")
+	query.Add("_pragma", "query_only(1)")
+	query.Add("_pragma", "foreign_keys(0)")
+	if config.BusyTimeout > 0 {
+		query.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", config.BusyTimeout))
+	}
+	config.add_attach_params(query)
+	return "file:" + sqlite_uri_path(path) + "?" + query.Encode()
+}
+
+// snapshotDSN 备份和恢复使用的只读连接, 不设置 query_only 以便执行 VACUUM INTO
+func (config *SqliteConfig) snapshotDSN(path string) string {
+	query := url.Values{}
+	query.Set("mode", "ro")
+	if config.BusyTimeout > 0 {
+		query.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", config.BusyTimeout))
+	}
+	return "file:" + sqlite_uri_path(path) + "?" + query.Encode()
+}
+
+// sqlite_uri_path 转义 URI 文件名中的特殊字符
+func sqlite_uri_path(path string) string {
+	replacer := strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+	return replacer.Replace(path)
+}
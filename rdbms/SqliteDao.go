@@ -4,12 +4,68 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
 type SqliteDao struct {
-	ds *SqliteDataSource
+	ds          *SqliteDataSource
+	tracking    bool        // 变更跟踪模式
+	consistency Consistency // 读一致性
+	state       *daoState   // 同一个 DAO 派生出的 DAO 共享状态
+	actor       string      // 审计记录的操作人
+}
+
+type daoState struct {
+	snapshots sync.Map    // key: table:id, value: 加载时的字段快照
+	written   atomic.Bool // 是否已经写入过
+}
+
+func newSqliteDao(ds *SqliteDataSource, options *DaoOptions) *SqliteDao {
+	return &SqliteDao{
+		ds:          ds,
+		tracking:    options.ChangeTracking,
+		consistency: options.Consistency,
+		state:       &daoState{},
+		actor:       options.Actor,
+	}
+}
+
+// submit 提交写任务并等待结果, 成功后记录已写入
+func (dao *SqliteDao) submit(task SqlTask) SqlResult {
+	task.actor = dao.actor
+	dao.ds.enqueue(task)
+	result := <-task.Result
+	if result.Err == nil {
+		dao.state.written.Store(true)
+	}
+	return result
+}
+
+// read_from_writer 判断读操作是否需要在写协程中执行以保证读到已完成的写入
+func (dao *SqliteDao) read_from_writer() bool {
+	switch dao.consistency {
+	case ConsistencyStrong:
+		return true
+	case ConsistencySession:
+		return dao.state.written.Load()
+	default:
+		return false
+	}
+}
+
+// Consistent 返回指定读一致性的 DAO, 与原 DAO 共享快照和写入状态, 用于单次调用
+func (dao *SqliteDao) Consistent(consistency Consistency) IDao {
+	return &SqliteDao{
+		ds:          dao.ds,
+		tracking:    dao.tracking,
+		consistency: consistency,
+		state:       dao.state,
+		actor:       dao.actor,
+	}
 }
 
 func (dao *SqliteDao) DataSourceId() string {
@@ -44,8 +100,7 @@ func (dao *SqliteDao) Create(statement string) error {
 	}
 
 	defer task.Close()
-	dao.ds.doTasks <- task
-	result := <-task.Result
+	result := dao.submit(task)
 	if result.Err != nil {
 		return result.Err
 	}
@@ -53,15 +108,55 @@ func (dao *SqliteDao) Create(statement string) error {
 }
 
 func (dao *SqliteDao) prepare_insert_update_tasks(models []ITable, update bool) ([]SqlTask, error) {
-	groups := make(map[string][]ITable)
+	specs := make([]*TableSpec, 0, len(models))
+	groups := make(map[*TableSpec][]ITable)
+	order := []*TableSpec{} // 按首次出现的顺序处理分组
 	for _, model := range models {
-		tableName := model.TableName()
-		if group, ok := groups[tableName]; ok {
-			groups[tableName] = append(group, model)
+		ts, err := dao.ds.getModelSpec(model)
+		if err != nil {
+			return nil, err
+		}
+		// 分区表按时间字段路由到分区, 插入时分区不存在则创建
+		if ts, err = dao.ds.partition_spec(ts, model, !update); err != nil {
+			return nil, err
+		}
+		if group, ok := groups[ts]; ok {
+			groups[ts] = append(group, model)
 		} else {
-			groups[tableName] = []ITable{model}
+			groups[ts] = []ITable{model}
+			order = append(order, ts)
 		}
+		specs = append(specs, ts)
 	}
+
+	// 涉及多个表或分区时按输入顺序在一个事务中执行, 返回的主键与输入顺序一致
+	if len(order) > 1 {
+		task := SqlTask{
+			BatchArgs: make([][]interface{}, 0, len(models)),
+			Result:    make(chan SqlResult, 1),
+			models:    models,
+			batchSQL:  make([]string, 0, len(models)),
+		}
+		for i, model := range models {
+			args, err := insert_update_args(specs[i], model, update)
+			if err != nil {
+				task.Close()
+				return nil, err
+			}
+			statement := specs[i].getInsertSql()
+			if update {
+				statement = specs[i].getUpdateSql()
+			}
+			task.BatchArgs = append(task.BatchArgs, args)
+			task.batchSQL = append(task.batchSQL, statement)
+		}
+		task.SQL = task.batchSQL[0]
+		if !update {
+			assign_ids_in_writer(&task, specs)
+		}
+		return []SqlTask{task}, nil
+	}
+
 	tasks := make([]SqlTask, 0, len(groups))
 	var err error
 	defer func() {
@@ -73,25 +168,16 @@ func (dao *SqliteDao) prepare_insert_update_tasks(models []ITable, update bool)
 	}()
 
 	// 处理各个分组
-	for tableName, group := range groups {
-		ts := dao.ds.GetTableSpec(tableName)
-		if ts == nil {
-			err = fmt.Errorf("table[%s] spec not found", tableName)
-			return nil, err
-		}
-
+	for _, ts := range order {
+		group := groups[ts]
 		// 处理单个任务或批量任务
 		var task SqlTask
 		if len(group) == 1 {
-			args, err := ts.extractInsertUpdateValues(group[0])
+			args, err := insert_update_args(ts, group[0], update)
 			if err != nil {
 				return nil, err
 			}
 			if update {
-				pk := ts.getModelId(group[0])
-				if pk <= 0 {
-					return nil, fmt.Errorf("primary is zero of model %v", group[0])
-				}
 				task = SqlTask{
 					SQL:    ts.getUpdateSql(),
 					Args:   args,
@@ -107,17 +193,10 @@ func (dao *SqliteDao) prepare_insert_update_tasks(models []ITable, update bool)
 		} else {
 			batchArgs := make([][]interface{}, 0, len(group))
 			for _, model := range group {
-				args, err := ts.extractInsertUpdateValues(model)
+				args, err := insert_update_args(ts, model, update)
 				if err != nil {
 					return nil, err
 				}
-				if update {
-					pk := ts.getModelId(model)
-					if pk <= 0 {
-						return nil, fmt.Errorf("primary is zero of model %v", model)
-					}
-					args = append(args, pk)
-				}
 				batchArgs = append(batchArgs, args)
 			}
 			if update {
@@ -134,11 +213,31 @@ func (dao *SqliteDao) prepare_insert_update_tasks(models []ITable, update bool)
 				}
 			}
 		}
+		task.spec = ts
+		task.models = group
+		if !update {
+			groupSpecs := make([]*TableSpec, len(group))
+			for i := range groupSpecs {
+				groupSpecs[i] = ts
+			}
+			assign_ids_in_writer(&task, groupSpecs)
+		}
 		tasks = append(tasks, task)
 	}
 	return tasks, nil
 }
 
+// insert_update_args 插入或更新语句的参数, 更新时模型的主键不能为零; 需要在写协程中分配主键时参数为空, 见 assign_ids_in_writer
+func insert_update_args(ts *TableSpec, model ITable, update bool) ([]interface{}, error) {
+	if update && !ts.hasModelKey(model) {
+		return nil, fmt.Errorf("primary is zero of model %v", model)
+	}
+	if !update && needs_assigned_id(ts, model) {
+		return nil, nil
+	}
+	return ts.extractInsertUpdateValues(model, update)
+}
+
 func (dao *SqliteDao) TableInsert(models ...ITable) ([]int64, error) {
 	if len(models) == 0 {
 		return nil, nil
@@ -150,13 +249,27 @@ func (dao *SqliteDao) TableInsert(models ...ITable) ([]int64, error) {
 
 	pks := make([]int64, 0, len(models))
 	for _, task := range tasks {
-		dao.ds.doTasks <- task
-		result := <-task.Result
+		result := dao.submit(task)
 		if result.Err != nil {
+			if task.assigner != nil {
+				task.assigner.reset(task.models)
+			}
 			return nil, result.Err
 		}
 		if result.LastInsertID != nil {
 			pks = append(pks, result.LastInsertID...)
+			// 回填自增主键
+			if len(result.LastInsertID) == len(task.models) {
+				for i, model := range task.models {
+					spec := task.spec
+					if spec == nil {
+						if spec, err = dao.ds.getModelSpec(model); err != nil {
+							return nil, err
+						}
+					}
+					spec.setModelId(model, result.LastInsertID[i])
+				}
+			}
 		}
 		task.Close()
 	}
@@ -167,15 +280,17 @@ func (dao *SqliteDao) TableUpdate(models ...ITable) (int64, error) {
 	if len(models) == 0 {
 		return 0, nil
 	}
-	tasks, err := dao.prepare_insert_update_tasks(models, false)
+	if dao.tracking {
+		return dao.table_update_changed(models)
+	}
+	tasks, err := dao.prepare_insert_update_tasks(models, true)
 	if err != nil {
 		return 0, err
 	}
 
 	count := int64(0)
 	for _, task := range tasks {
-		dao.ds.doTasks <- task
-		result := <-task.Result
+		result := dao.submit(task)
 		if result.Err != nil {
 			return 0, result.Err
 		}
@@ -185,25 +300,168 @@ func (dao *SqliteDao) TableUpdate(models ...ITable) (int64, error) {
 	return count, nil
 }
 
-func (dao *SqliteDao) TableDelete(tableName string, ids ...int64) (int64, error) {
+func (dao *SqliteDao) TableUpdateColumns(model ITable, columns ...string) (int64, error) {
+	ts, err := dao.ds.getModelSpec(model)
+	if err != nil {
+		return 0, err
+	}
+	if err := ts.checkUpdateColumns(columns); err != nil {
+		return 0, err
+	}
+	target, err := dao.ds.partition_spec(ts, model, false)
+	if err != nil {
+		return 0, err
+	}
+	count, err := dao.table_update_columns(target, model, columns)
+	if err != nil {
+		return 0, err
+	}
+	if dao.tracking {
+		dao.merge_snapshot(ts, model, columns)
+	}
+	return count, nil
+}
+
+func (dao *SqliteDao) table_update_columns(ts *TableSpec, model ITable, columns []string) (int64, error) {
+	if !ts.hasModelKey(model) {
+		return 0, fmt.Errorf("primary is zero of model %v", model)
+	}
+	args, err := ts.extractColumnValues(model, columns)
+	if err != nil {
+		return 0, err
+	}
+
+	task := SqlTask{
+		SQL:    ts.getUpdateColumnsSql(columns),
+		Args:   append(args, ts.getModelKey(model)...),
+		Result: make(chan SqlResult, 1),
+	}
+	defer task.Close()
+
+	result := dao.submit(task)
+	if result.Err != nil {
+		return 0, result.Err
+	}
+	return result.RowsAffected, nil
+}
+
+// table_update_changed 变更跟踪模式下, 只更新与快照相比发生变化的字段, 没有快照的模型全量更新;
+// 所有模型的更新在一个写任务的事务中执行, 任一失败时全部回滚
+func (dao *SqliteDao) table_update_changed(models []ITable) (int64, error) {
+	task := SqlTask{Result: make(chan SqlResult, 1)}
+	defer task.Close()
+	specs := make([]*TableSpec, 0, len(models))
+	for _, model := range models {
+		ts, err := dao.ds.getModelSpec(model)
+		if err != nil {
+			return 0, err
+		}
+		columns := ts.updatableColumns()
+		if snapshot, ok := dao.state.snapshots.Load(snapshot_key(ts, ts.getModelKey(model))); ok {
+			changed, err := ts.diffModel(model, snapshot.(map[string]interface{}))
+			if err != nil {
+				return 0, err
+			}
+			columns = changed
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		target, err := dao.ds.partition_spec(ts, model, false)
+		if err != nil {
+			return 0, err
+		}
+		if !target.hasModelKey(model) {
+			return 0, fmt.Errorf("primary is zero of model %v", model)
+		}
+		args, err := target.extractColumnValues(model, columns)
+		if err != nil {
+			return 0, err
+		}
+		task.batchSQL = append(task.batchSQL, target.getUpdateColumnsSql(columns))
+		task.BatchArgs = append(task.BatchArgs, append(args, target.getModelKey(model)...))
+		task.models = append(task.models, model)
+		specs = append(specs, ts)
+	}
+	if len(task.BatchArgs) == 0 {
+		return 0, nil
+	}
+
+	task.SQL = task.batchSQL[0]
+	result := dao.submit(task)
+	if result.Err != nil {
+		return 0, result.Err
+	}
+	for i, model := range task.models {
+		dao.take_snapshot(specs[i], model)
+	}
+	return result.RowsAffected, nil
+}
+
+func snapshot_key(ts *TableSpec, key Key) string {
+	return fmt.Sprintf("%s:%v", ts.tableName, key)
+}
+
+// take_snapshot 记录模型加载时的字段值
+func (dao *SqliteDao) take_snapshot(ts *TableSpec, value interface{}) {
+	model, ok := value.(ITable)
+	if !ok || !ts.hasModelKey(model) {
+		return
+	}
+	snapshot, err := ts.snapshotModel(model)
+	if err != nil {
+		return
+	}
+	dao.state.snapshots.Store(snapshot_key(ts, ts.getModelKey(model)), snapshot)
+}
+
+// merge_snapshot 只把已写入的字段合并到快照, 其他字段在内存中的修改留给下一次 TableUpdate; 没有快照时不记录
+func (dao *SqliteDao) merge_snapshot(ts *TableSpec, model ITable, columns []string) {
+	key := snapshot_key(ts, ts.getModelKey(model))
+	snapshot, ok := dao.state.snapshots.Load(key)
+	if !ok {
+		return
+	}
+	current, err := ts.snapshotModel(model)
+	if err != nil {
+		dao.state.snapshots.Delete(key)
+		return
+	}
+	merged := make(map[string]interface{}, len(current))
+	for column, value := range snapshot.(map[string]interface{}) {
+		merged[column] = value
+	}
+	for _, column := range columns {
+		merged[column] = current[column]
+	}
+	dao.state.snapshots.Store(key, merged)
+}
+
+func (dao *SqliteDao) TableDelete(tableName string, keys ...interface{}) (int64, error) {
 	ts := dao.ds.GetTableSpec(tableName)
 	if ts == nil {
 		return 0, fmt.Errorf("table[%s] spec not found", tableName)
 	}
-	if len(ids) == 0 {
+	if len(keys) == 0 {
 		return 0, nil
 	}
+	if p := dao.ds.partitioner(ts); p != nil {
+		return p.delete(dao, keys)
+	}
+	args, err := ts.keyArgs(keys)
+	if err != nil {
+		return 0, err
+	}
 
 	task := SqlTask{
-		SQL:    ts.getDeleteSql(len(ids)),
-		Args:   SqlToParams(ids),
+		SQL:    ts.getDeleteSql(len(keys)),
+		Args:   args,
 		Result: make(chan SqlResult, 1),
 	}
 
 	defer task.Close()
 
-	dao.ds.doTasks <- task
-	result := <-task.Result
+	result := dao.submit(task)
 
 	if result.Err != nil {
 		return 0, result.Err
@@ -211,25 +469,39 @@ func (dao *SqliteDao) TableDelete(tableName string, ids ...int64) (int64, error)
 	return result.RowsAffected, nil
 }
 
-func (dao *SqliteDao) TableGet(emptyTableModel interface{}, id int64) error {
-	model, ok := emptyTableModel.(ITable)
-	if !ok {
-		return fmt.Errorf("emptyTableModel not implement ITable interface")
+func (dao *SqliteDao) TableGet(emptyTableModel interface{}, key interface{}) error {
+	ts, err := dao.ds.getModelSpec(emptyTableModel)
+	if err != nil {
+		return err
 	}
-	ts := dao.ds.GetTableSpec(model.TableName())
-	if ts == nil {
-		return fmt.Errorf("table[%s] spec not found", model.TableName())
+	args, err := ts.keyArgs([]interface{}{key})
+	if err != nil {
+		return err
 	}
-	if id == 0 {
-		return fmt.Errorf("id is zero")
+	query := ts
+	if p := dao.ds.partitioner(ts); p != nil {
+		query = p.key_view([]interface{}{key})
 	}
 
-	return dao.ds.reader.Get(emptyTableModel, ts.getSelectSql(1), id)
+	err = dao.Read(func(conn sqlx.Queryer) error {
+		rows, err := conn.Query(query.getSelectSql(1), args...)
+		if err != nil {
+			return err
+		}
+		return query.scanOne(rows, emptyTableModel)
+	})
+	if err != nil {
+		return err
+	}
+	if dao.tracking {
+		dao.take_snapshot(ts, emptyTableModel)
+	}
+	return nil
 }
 
-func (dao *SqliteDao) TableSelect(emptyTableSlice interface{}, ids ...int64) error {
-	if len(ids) == 0 {
-		return fmt.Errorf("ids is empty")
+func (dao *SqliteDao) TableSelect(emptyTableSlice interface{}, keys ...interface{}) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("keys is empty")
 	}
 
 	// 确认 emptyTableSlice 是一个切片的指针
@@ -238,26 +510,187 @@ func (dao *SqliteDao) TableSelect(emptyTableSlice interface{}, ids ...int64) err
 		return fmt.Errorf("emptyTableSlice must be a pointer to a slice")
 	}
 
-	// 获取切片的元素类型
-	elemType := sliceValue.Elem().Type().Elem()
+	// 根据切片的元素类型查找表结构
+	ts, err := dao.ds.getModelSpec(emptyTableSlice)
+	if err != nil {
+		return err
+	}
 
-	// 使用反射创建一个实例
-	newElem := reflect.New(elemType).Elem()
+	args, err := ts.keyArgs(keys)
+	if err != nil {
+		return err
+	}
+	query := ts
+	if p := dao.ds.partitioner(ts); p != nil {
+		query = p.key_view(keys)
+	}
 
-	// 将其转换为 ITable 接口类型
-	emptyTableModel, ok := newElem.Interface().(ITable)
-	if !ok {
-		return fmt.Errorf("failed to convert new element to ITable")
+	err = dao.Read(func(conn sqlx.Queryer) error {
+		rows, err := conn.Query(query.getSelectSql(len(keys)), args...)
+		if err != nil {
+			return err
+		}
+		return query.scanAll(rows, emptyTableSlice)
+	})
+	if err != nil {
+		return err
 	}
+	if dao.tracking {
+		elems := sliceValue.Elem()
+		for i := 0; i < elems.Len(); i++ {
+			elem := elems.Index(i)
+			if elem.Kind() != reflect.Ptr {
+				elem = elem.Addr()
+			}
+			dao.take_snapshot(ts, elem.Interface())
+		}
+	}
+	return nil
+}
 
-	ts := dao.ds.GetTableSpec(emptyTableModel.TableName())
-	if ts == nil {
-		return fmt.Errorf("table[%s] spec not found", emptyTableModel.TableName())
+func (dao *SqliteDao) TableFind(emptyTableSlice interface{}, criteria *Criteria) error {
+	if criteria == nil {
+		criteria = NewCriteria()
+	}
+	ts, err := dao.ds.getModelSpec(emptyTableSlice)
+	if err != nil {
+		return err
+	}
+	query, where := ts, criteria
+	if p := dao.ds.partitioner(ts); p != nil {
+		if query, where, err = p.find(criteria); err != nil {
+			return err
+		}
+	} else if criteria.ranged() {
+		return fmt.Errorf("table[%s] is not partitioned, Between is not supported", ts.tableName)
 	}
 
-	return dao.ds.reader.Select(emptyTableSlice, ts.getSelectSql(len(ids)), ids)
+	statement, err := where.toSql(query)
+	if err != nil {
+		return err
+	}
+	err = dao.Read(func(conn sqlx.Queryer) error {
+		rows, err := conn.Query(statement, where.Args()...)
+		if err != nil {
+			return err
+		}
+		return query.scanAll(rows, emptyTableSlice)
+	})
+	if err != nil {
+		return err
+	}
+	if dao.tracking {
+		elems, _ := model_elems(emptyTableSlice)
+		for _, elem := range elems {
+			dao.take_snapshot(ts, elem.Addr().Interface())
+		}
+	}
+	if len(criteria.preloads) > 0 {
+		return dao.TablePreload(emptyTableSlice, criteria.preloads...)
+	}
+	return nil
+}
+
+// TablePreload 为已加载的模型或模型切片加载关联, 每个关联执行一条 IN 查询
+func (dao *SqliteDao) TablePreload(models interface{}, relations ...string) error {
+	ts, err := dao.ds.getModelSpec(models)
+	if err != nil {
+		return err
+	}
+	elems, err := model_elems(models)
+	if err != nil {
+		return err
+	}
+	for _, path := range relations {
+		if err := dao.preload(ts, elems, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dao *SqliteDao) getModelSpec(model interface{}) (*TableSpec, error) {
+	return dao.ds.getModelSpec(model)
+}
+
+// Read 默认在读连接池执行; 一致性要求读到已完成的写入时, 交给写协程在写连接上执行
+func (dao *SqliteDao) Read(fn func(conn sqlx.Queryer) error) error {
+	if !dao.read_from_writer() {
+		start := time.Now()
+		defer func() {
+			dao.ds.metrics.observe_latency("read", time.Since(start))
+		}()
+		return dao.ds.read(func(reader *sqlx.DB) error {
+			return fn(reader)
+		})
+	}
+	task := SqlTask{
+		Do: func(writer *sqlx.DB) error {
+			return fn(writer)
+		},
+		Result: make(chan SqlResult, 1),
+	}
+	defer task.Close()
+
+	dao.ds.enqueue(task)
+	result := <-task.Result
+	return result.Err
+}
+
+func (dao *SqliteDao) Write(fn func(conn sqlx.Ext) error) error {
+	task := SqlTask{
+		Do: func(writer *sqlx.DB) error {
+			if dao.actor != "" && dao.ds.auditing.Load() {
+				return with_audit_actor(writer, dao.actor, func(tx *sqlx.Tx) error {
+					return fn(tx)
+				})
+			}
+			return fn(writer)
+		},
+		Result: make(chan SqlResult, 1),
+	}
+	defer task.Close()
+
+	result := dao.submit(task)
+	return result.Err
+}
+
+// Exec 在写协程中执行语句, 切片参数展开为 IN 列表
+func (dao *SqliteDao) Exec(statement string, args ...interface{}) (SqlResult, error) {
+	statement, args = expand_sql_args(statement, args)
+	task := SqlTask{
+		SQL:    statement,
+		Args:   args,
+		Result: make(chan SqlResult, 1),
+	}
+	defer task.Close()
+
+	result := dao.submit(task)
+	if result.Err != nil {
+		return result, result.Err
+	}
+	return result, nil
+}
+
+// QueryMap 查询结果转为 map 切片, 读语句走读连接池, 写语句走写协程
+func (dao *SqliteDao) QueryMap(statement string, args ...interface{}) ([]map[string]interface{}, error) {
+	statement, args = expand_sql_args(statement, args)
+	var result []map[string]interface{}
+	err := route_query(dao, statement, func(conn sqlx.Queryer) error {
+		rows, err := conn.Queryx(statement, args...)
+		if err != nil {
+			return err
+		}
+		result, err = rows_to_maps(rows)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
+// Conn 返回读连接池, 直接使用时不受一致性选项约束; 连接池在数据源关闭前一直有效
 func (dao *SqliteDao) Conn() *sqlx.DB {
 	return dao.ds.reader
 }
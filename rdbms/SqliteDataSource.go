@@ -1,12 +1,15 @@
 package rdbms
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -17,14 +20,31 @@ import (
 type SqliteDataSource struct {
 	id         string
 	db_path    string
+	config     *SqliteConfig
+	mmapSize   uint64 // 读连接当前的 mmap_size
+	cacheSize  int64  // 读连接当前的 cache_size
 	writer     *sqlx.DB
 	reader     *sqlx.DB
+	connector  *sqliteConnector // 读连接池的连接器, 刷新设置时更新 DSN
+	lock       sync.RWMutex     // 保护读连接池的设置和附加数据库
+	maintainer *maintainer
+	done       chan struct{} // 关闭数据源时关闭, 停止读连接池监控和变更订阅
+	monitorWg  sync.WaitGroup
+	observers  queryObservers
+	metrics    *dataSourceMetrics
+	capturing  atomic.Bool   // 是否开启了变更记录
+	changeLock sync.Mutex    // 保护 changed
+	changed    chan struct{} // 写任务完成后关闭, 唤醒变更订阅者
+	auditing   atomic.Bool   // 是否开启了审计
+	partitions sync.Map      // key: 模型的 *TableSpec, value: *partitioner
+	sharded    bool          // 分片数据源的分片, 自增主键按分片序号分段
 	tableSpecs sync.Map
 	doTasks    chan SqlTask
 	wg         sync.WaitGroup
+	closed     atomic.Bool // 已关闭, 重复关闭时直接返回
 }
 
-func newSqliteDataSource(id string, db_path string, statements []string) (*SqliteDataSource, error) {
+func newSqliteDataSource(id string, db_path string, config *SqliteConfig, statements []string) (*SqliteDataSource, error) {
 	// 检查文件是否存在
 	// 创建目录
 	err := os.MkdirAll(filepath.Dir(db_path), os.ModePerm)
@@ -45,7 +65,7 @@ func newSqliteDataSource(id string, db_path string, statements []string) (*Sqlit
 		file.Close()
 	}
 
-	writer, err := create_writer(db_path)
+	writer, writerConnector, err := create_writer(db_path, config)
 	if err != nil {
 		log.Printf("failed to create writer: %v\n", err)
 		return nil, err
@@ -65,7 +85,7 @@ func newSqliteDataSource(id string, db_path string, statements []string) (*Sqlit
 		}
 	}
 
-	reader, err := create_reader(db_path, 64*1024*1024)
+	reader, connector, mmapSize, cacheSize, err := create_reader(db_path, config)
 	if err != nil {
 		log.Printf("failed to create reader: %v\n", err)
 		writer.Close()
@@ -75,45 +95,71 @@ func newSqliteDataSource(id string, db_path string, statements []string) (*Sqlit
 	ds := &SqliteDataSource{
 		id:         id,
 		db_path:    db_path,
+		config:     config,
+		mmapSize:   mmapSize,
+		cacheSize:  cacheSize,
 		writer:     writer,
 		reader:     reader,
+		connector:  connector,
 		tableSpecs: sync.Map{},
 		doTasks:    make(chan SqlTask, 1000),
 		wg:         sync.WaitGroup{},
 	}
 
+	ds.metrics = newDataSourceMetrics()
+
+	// 触发器保存在数据库中, 之前开启过变更记录或审计时同样需要唤醒订阅者、记录操作人
+	if err := ds.detect_triggers(writer); err != nil {
+		log.Printf("failed to detect triggers: %v\n", err)
+		writer.Close()
+		reader.Close()
+		return nil, err
+	}
+
+	// 查询观察者
+	ds.observers.redactor = RedactColumns(DefaultSensitiveColumns...)
+	ds.observers.slow = config.SlowQuery
+	ds.observers.explain = ds.explain
+	if config.SlowQuery > 0 {
+		ds.observers.add(NewSlowQueryLogger(config.SlowQuery))
+	}
+	writerConnector.observe(ds, false)
+	connector.observe(ds, true)
+
 	// 启动后台写任务
 	ds.wg.Add(1)
-	go do_sql_task_background(writer, ds.doTasks, &ds.wg)
+	go do_sql_task_background(ds, ds.doTasks, &ds.wg)
+
+	// 启动定期维护
+	ds.maintainer = newMaintainer(ds)
+	ds.maintainer.start()
+
+	// 启动读连接池监控
+	ds.start_reader_monitor()
 
 	return ds, nil
 }
 
-func create_writer(db_path string) (*sqlx.DB, error) {
-	writer, err := sqlx.Connect("sqlite", db_path)
+func create_writer(db_path string, config *SqliteConfig) (*sqlx.DB, *sqliteConnector, error) {
+	connector := newSqliteConnector(config.writerDSN(db_path))
+	writer, err := open_connector(connector)
 	if err != nil {
 		log.Printf("failed to connect to database: %v\n", err)
-		return nil, err
-	}
-
-	// 设置数据库 WAL 模式
-	if _, err := writer.Exec("PRAGMA journal_mode = WAL"); err != nil {
-		writer.Close()
-		log.Printf("failed to set journal mode: %v\n", err)
-		return nil, err
+		return nil, nil, err
 	}
 
 	// 关闭连接池
 	writer.SetMaxOpenConns(1)    // 只允许一个连接
 	writer.SetMaxIdleConns(1)    // 避免创建额外的空闲连接
 	writer.SetConnMaxLifetime(0) // 禁止连接超时
-	return writer, nil
+	return writer, connector, nil
 }
 
-func create_reader(path string, max_memory_map_size uint64) (*sqlx.DB, error) {
+// reader_memory_sizes 计算读连接的 mmap_size 和 cache_size, 配置了固定值时使用配置值
+func reader_memory_sizes(path string, config *SqliteConfig) (uint64, int64, error) {
 	fileInfo, err := os.Stat(path)
 	if err != nil {
-		return nil, fmt.Errorf("database file does not exist: %w", err)
+		return 0, 0, fmt.Errorf("database file does not exist: %w", err)
 	}
 
 	// 获取可用内存的一半，默认64MB
@@ -125,50 +171,112 @@ func create_reader(path string, max_memory_map_size uint64) (*sqlx.DB, error) {
 	}
 
 	fileSize := uint64(fileInfo.Size())
-	mmapSize := calculateMmapSize(fileSize, freeMemory, max_memory_map_size)
-
-	// 设置缓存大小：全部映射时象征性设置为1MB，否则为映射大小的1/10
-	cacheSize := calculateCacheSize(mmapSize, fileSize)
+	mmapSize := calculateMmapSize(fileSize, freeMemory, config.MmapMax)
+	if config.MmapSize >= 0 {
+		mmapSize = uint64(config.MmapSize)
+	}
 
-	// 获取CPU数量并确保最小为1
-	cpuCount := basic.GetCpuCount()
-	if cpuCount < 1 {
-		cpuCount = 1
+	// 设置缓存大小：全部映射时象征性设置为1MB，否则为映射大小的1/10, 负数表示 KiB
+	cacheBytes := calculateCacheSize(mmapSize, fileSize)
+	if config.CacheMax > 0 {
+		cacheBytes = min(cacheBytes, config.CacheMax)
+	}
+	cacheSize := -int64(cacheBytes / 1024)
+	if config.CacheSize != 0 {
+		cacheSize = config.CacheSize
 	}
+	return mmapSize, cacheSize, nil
+}
 
-	// 连接数据库
-	db, err := sqlx.Connect("sqlite", fmt.Sprintf("file:%s?cache=shared&mode=ro", path))
+// create_reader 创建读连接池, 配置了副本时连接副本文件
+func create_reader(path string, config *SqliteConfig) (*sqlx.DB, *sqliteConnector, uint64, int64, error) {
+	path = config.readerPath(path)
+	mmapSize, cacheSize, err := reader_memory_sizes(path, config)
 	if err != nil {
-		return nil, fmt.Errorf("unable to connect to database: %w", err)
+		return nil, nil, 0, 0, err
 	}
 
-	// 设置数据库参数并处理错误
-	if _, err := db.Exec("PRAGMA cache_size = -" + fmt.Sprint(cacheSize/1024)); err != nil {
-		return nil, fmt.Errorf("failed to set cache size: %w", err)
-	}
-	if _, err := db.Exec("PRAGMA mmap_size = " + fmt.Sprint(mmapSize)); err != nil {
-		return nil, fmt.Errorf("failed to set mmap size: %w", err)
-	}
-	if _, err := db.Exec("PRAGMA temp_store = MEMORY"); err != nil {
-		return nil, fmt.Errorf("failed to set temp_store: %w", err)
+	// 连接数据库, pragma 在每个连接建立时执行
+	connector := newSqliteConnector(config.readerDSN(path, mmapSize, cacheSize))
+	db, err := open_connector(connector)
+	if err != nil {
+		return nil, nil, 0, 0, fmt.Errorf("unable to connect to database: %w", err)
 	}
-	if _, err := db.Exec("PRAGMA synchronous = OFF"); err != nil {
-		return nil, fmt.Errorf("failed to set synchronous mode: %w", err)
+
+	set_reader_pool(db, config)
+	return db, connector, mmapSize, cacheSize, nil
+}
+
+// set_reader_pool 设置读连接池
+func set_reader_pool(db *sqlx.DB, config *SqliteConfig) {
+	db.SetMaxOpenConns(config.ReaderPoolSize)
+	db.SetMaxIdleConns(config.ReaderIdleSize)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+}
+
+// renew_reader 之后建立的读连接使用新的 mmap_size 和 cache_size, 空闲的旧连接立即关闭,
+// 使用中的旧连接归还时关闭; 连接池不会关闭, 调用方需要持有写锁
+func (ds *SqliteDataSource) renew_reader(mmapSize uint64, cacheSize int64) {
+	ds.connector.update(ds.config.readerDSN(ds.config.readerPath(ds.db_path), mmapSize, cacheSize))
+	ds.mmapSize, ds.cacheSize = mmapSize, cacheSize
+	ds.reader.SetMaxIdleConns(0)
+	ds.reader.SetMaxIdleConns(ds.config.ReaderIdleSize)
+}
+
+// start_reader_monitor 定期按文件大小和可用内存重新计算读连接的 mmap_size 和 cache_size
+func (ds *SqliteDataSource) start_reader_monitor() {
+	ds.done = make(chan struct{})
+	if ds.config.ReaderRefresh <= 0 {
+		return
 	}
-	if _, err := db.Exec("PRAGMA query_only = true"); err != nil {
-		return nil, fmt.Errorf("failed to set journal mode: %w", err)
+	ds.monitorWg.Add(1)
+	go func() {
+		defer ds.monitorWg.Done()
+		ticker := time.NewTicker(ds.config.ReaderRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ds.done:
+				return
+			case <-ticker.C:
+				if _, err := ds.refresh_reader(); err != nil {
+					log.Printf("failed to refresh reader of sqlite data source[%s]: %v\n", ds.id, err)
+				}
+			}
+		}
+	}()
+}
+
+// refresh_reader 计算结果与当前设置相差超过 10% 时更新读连接的设置, 返回是否更新
+func (ds *SqliteDataSource) refresh_reader() (bool, error) {
+	mmapSize, cacheSize, err := reader_memory_sizes(ds.config.readerPath(ds.db_path), ds.config)
+	if err != nil {
+		return false, err
 	}
-	if _, err := db.Exec("PRAGMA foreign_keys = OFF"); err != nil {
-		return nil, fmt.Errorf("failed to disable foreign keys: %w", err)
+	ds.lock.RLock()
+	changed := size_changed(int64(ds.mmapSize), int64(mmapSize)) || size_changed(ds.cacheSize, cacheSize)
+	ds.lock.RUnlock()
+	if !changed {
+		return false, nil
 	}
 
-	// 设置连接池
-	db.SetMaxOpenConns(cpuCount * 10)
-	db.SetMaxIdleConns(cpuCount)
-	db.SetConnMaxLifetime(6 * time.Hour)
-	db.SetConnMaxIdleTime(10 * time.Minute)
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.renew_reader(mmapSize, cacheSize)
+	log.Printf("sqlite data source[%s] reader refreshed: mmap_size=%d cache_size=%d\n", ds.id, mmapSize, cacheSize)
+	return true, nil
+}
 
-	return db, nil
+func size_changed(current int64, next int64) bool {
+	diff := next - current
+	if diff < 0 {
+		diff = -diff
+	}
+	if current < 0 {
+		current = -current
+	}
+	return diff*10 > current
 }
 
 // calculateMmapSize 计算 mmap 大小
@@ -192,52 +300,99 @@ func calculateCacheSize(mmapSize, fileSize uint64) uint64 {
 	return baseCacheSize
 }
 
-func do_sql_task_background(writer *sqlx.DB, taskChannel <-chan SqlTask, wg *sync.WaitGroup) {
+// do_sql_task_background 串行执行写任务, 每个任务使用 ds.writer, 恢复数据库时写连接会在任务中被替换
+func do_sql_task_background(ds *SqliteDataSource, taskChannel <-chan SqlTask, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	for task := range taskChannel {
+		writer := ds.writer
 		result := SqlResult{
 			LastInsertID: make([]int64, 0),
 			RowsAffected: 0,
 			Err:          nil,
 		}
+		start := time.Now()
+		wait := time.Duration(-1)
+		if !task.enqueued.IsZero() {
+			wait = start.Sub(task.enqueued)
+		}
 		if task.SQL == "" {
+			if task.Do != nil {
+				result.Err = task.Do(writer)
+				ds.metrics.observe_latency("do", time.Since(start))
+			}
+			ds.metrics.observe_task("do", wait, result.Err)
+			ds.notify_changes()
 			task.Result <- result
 			continue
 		}
 
 		var ret sql.Result
 		var err error
+		kind := "exec"
 		if task.BatchArgs != nil && len(task.BatchArgs) > 0 {
+			kind = "batch"
+			ds.metrics.observe_batch(len(task.BatchArgs))
 			tx, err := writer.Beginx()
 			if err != nil {
 				result.Err = err
+				ds.metrics.observe_task(kind, wait, err)
 				task.Result <- result
 				continue
 			}
 
-			for _, args := range task.BatchArgs {
-				if args == nil || len(args) == 0 {
-					ret, err = tx.Exec(task.SQL)
-				} else {
-					ret, err = tx.Exec(task.SQL, args...)
+			actor := ds.audit_actor(&task)
+			if actor != "" {
+				result.Err = set_audit_actor(tx, actor)
+			}
+			if result.Err == nil && task.assigner != nil {
+				result.Err = task.assigner.assign(tx, &task)
+			}
+			for i, args := range task.BatchArgs {
+				if result.Err != nil {
+					break
 				}
+				statement := task.SQL
+				if task.batchSQL != nil {
+					statement = task.batchSQL[i]
+				}
+				// 批量任务在执行完成后整体通知观察者
+				ret, err = tx.ExecContext(without_observe(context.Background()), statement, args...)
 				if err != nil {
-					tx.Rollback() // 执行回滚
 					result.Err = err
 					log.Printf("Error during batch execution: %v\n", err) // 增加日志记录
 					break
 				}
+				record_sql_result(&result, ret)
+			}
+			ret = nil
+			if result.Err == nil && actor != "" {
+				result.Err = clear_audit_actor(tx)
 			}
 
-			// 批量操作成功，提交事务
-			if result.Err == nil {
+			if result.Err != nil {
+				tx.Rollback() // 执行回滚
+				ds.metrics.observe_tx(false)
+			} else {
+				// 批量操作成功，提交事务
 				err = tx.Commit()
+				ds.metrics.observe_tx(err == nil)
 				if err != nil {
 					result.Err = err
 					log.Printf("Failed to commit transaction: %v\n", err)
 				}
 			}
+		} else if actor := ds.audit_actor(&task); actor != "" {
+			// 记录操作人, 与语句在同一事务中执行
+			err = with_audit_actor(writer, actor, func(tx *sqlx.Tx) error {
+				ret, err = tx.Exec(task.SQL, task.Args...)
+				return err
+			})
+			if err != nil {
+				ret = nil
+				result.Err = err
+				log.Printf("Error executing SQL: %v\n", err)
+			}
 		} else {
 			if task.Args != nil && len(task.Args) > 0 {
 				ret, err = writer.Exec(task.SQL, task.Args...)
@@ -251,20 +406,54 @@ func do_sql_task_background(writer *sqlx.DB, taskChannel <-chan SqlTask, wg *syn
 		}
 
 		// 记录执行结果
-		if ret != nil {
-			lastInsertID, _ := ret.LastInsertId()
-			if lastInsertID < 0 {
-				lastInsertID = 0
-			}
-			result.LastInsertID = append(result.LastInsertID, lastInsertID)
-			rowsAffected, _ := ret.RowsAffected()
-			result.RowsAffected += rowsAffected
+		record_sql_result(&result, ret)
+		ds.metrics.observe_latency(statement_type(task.SQL), time.Since(start))
+		ds.metrics.observe_task(kind, wait, result.Err)
+		if kind == "batch" {
+			ds.observe_batch(&task, start, result)
 		}
+		ds.notify_changes()
 
 		task.Result <- result
 	}
 }
 
+// enqueue 提交写任务, 记录入队时间
+func (ds *SqliteDataSource) enqueue(task SqlTask) {
+	task.enqueued = time.Now()
+	ds.doTasks <- task
+}
+
+// observe_batch 通知观察者批量写任务的执行结果, 参数为第一组; 其余语句在驱动层通知
+func (ds *SqliteDataSource) observe_batch(task *SqlTask, start time.Time, result SqlResult) {
+	if !ds.observers.enabled() {
+		return
+	}
+	ds.observers.notify(&QueryEvent{
+		DataSource:   ds.id,
+		SQL:          task.SQL,
+		Args:         task.BatchArgs[0],
+		Batch:        len(task.BatchArgs),
+		Start:        start,
+		Duration:     time.Since(start),
+		RowsAffected: result.RowsAffected,
+		Err:          result.Err,
+	})
+}
+
+func record_sql_result(result *SqlResult, ret sql.Result) {
+	if ret == nil {
+		return
+	}
+	lastInsertID, _ := ret.LastInsertId()
+	if lastInsertID < 0 {
+		lastInsertID = 0
+	}
+	result.LastInsertID = append(result.LastInsertID, lastInsertID)
+	rowsAffected, _ := ret.RowsAffected()
+	result.RowsAffected += rowsAffected
+}
+
 func (ds *SqliteDataSource) Id() string {
 	return ds.id
 }
@@ -293,8 +482,11 @@ func (ds *SqliteDataSource) Password() string {
 	return ""
 }
 
-func (ds *SqliteDataSource) ScanTable(models ...ITable) {
-	scan_table(&ds.tableSpecs, models...)
+func (ds *SqliteDataSource) ScanTable(models ...ITable) error {
+	if err := scan_table(&ds.tableSpecs, models...); err != nil {
+		return err
+	}
+	return ds.ensure_search_indexes(models...)
 }
 
 func (ds *SqliteDataSource) GetTableSpec(tableName string) *TableSpec {
@@ -304,8 +496,93 @@ func (ds *SqliteDataSource) GetTableSpec(tableName string) *TableSpec {
 	return nil
 }
 
-func (ds *SqliteDataSource) NewDao() IDao {
-	return &SqliteDao{ds: ds}
+// getModelSpec 根据模型类型查找表结构, 未注册的类型按 TableName() 查找
+func (ds *SqliteDataSource) getModelSpec(model interface{}) (*TableSpec, error) {
+	t := model_type(model)
+	if t == nil {
+		return nil, fmt.Errorf("model is nil")
+	}
+	if ts, ok := ds.tableSpecs.Load(t); ok {
+		return ts.(*TableSpec), nil
+	}
+	if m, ok := reflect.New(t).Interface().(ITable); ok {
+		tableName := m.TableName()
+		if tableName == "" {
+			tableName = to_snake_case(t.Name())
+		}
+		if ts := ds.GetTableSpec(tableName); ts != nil {
+			return ts, nil
+		}
+	}
+	return nil, fmt.Errorf("table spec of model[%s] not found", t.Name())
+}
+
+// Settings 查询写连接和读连接实际生效的 pragma, 以及读连接池的参数
+func (ds *SqliteDataSource) Settings() (map[string]string, error) {
+	settings := make(map[string]string)
+	query_pragmas := func(conn sqlx.Queryer, prefix string, pragmas []string) error {
+		for _, pragma := range pragmas {
+			var value string
+			if err := conn.QueryRowx("PRAGMA " + pragma).Scan(&value); err != nil {
+				return fmt.Errorf("query pragma %s failed: %w", pragma, err)
+			}
+			settings[prefix+pragma] = value
+		}
+		return nil
+	}
+
+	// 写连接只有一个, 通过写协程查询
+	result := make(chan SqlResult, 1)
+	ds.enqueue(SqlTask{
+		Do: func(writer *sqlx.DB) error {
+			return query_pragmas(writer, "writer.", []string{"journal_mode", "synchronous", "busy_timeout", "foreign_keys", "auto_vacuum"})
+		},
+		Result: result,
+	})
+	if ret := <-result; ret.Err != nil {
+		return nil, ret.Err
+	}
+
+	err := ds.read(func(reader *sqlx.DB) error {
+		return query_pragmas(reader, "reader.", []string{"journal_mode", "synchronous", "busy_timeout", "mmap_size", "cache_size", "query_only"})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	settings["reader.shared_cache"] = fmt.Sprint(ds.config.SharedCache)
+	settings["reader.pool_size"] = fmt.Sprint(ds.config.ReaderPoolSize)
+	settings["reader.idle_size"] = fmt.Sprint(ds.config.ReaderIdleSize)
+	settings["reader.conn_max_lifetime"] = ds.config.ConnMaxLifetime.String()
+	settings["reader.conn_max_idle_time"] = ds.config.ConnMaxIdleTime.String()
+	settings["reader.refresh_interval"] = ds.config.ReaderRefresh.String()
+	settings["maintenance.checkpoint_interval"] = ds.config.CheckpointInterval.String()
+	settings["maintenance.vacuum_interval"] = ds.config.VacuumInterval.String()
+	settings["maintenance.optimize_interval"] = ds.config.OptimizeInterval.String()
+	settings["maintenance.integrity_check_interval"] = ds.config.IntegrityCheckInterval.String()
+	settings["maintenance.partition_retention_interval"] = ds.config.PartitionRetention.String()
+	return settings, nil
+}
+
+// read 使用读连接池执行查询
+func (ds *SqliteDataSource) read(fn func(reader *sqlx.DB) error) error {
+	return fn(ds.reader)
+}
+
+// read_primary 读取主库, 配置了只读副本时读连接池可能读到未同步的旧数据, 改为在写协程中读取
+func (ds *SqliteDataSource) read_primary(fn func(conn *sqlx.DB) error) error {
+	if ds.config.Replica == "" {
+		return ds.read(fn)
+	}
+	return ds.run_writer(fn)
+}
+
+func (ds *SqliteDataSource) NewDao(opts ...DaoOption) IDao {
+	options := &DaoOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return newSqliteDao(ds, options)
 }
 
 func (ds *SqliteDataSource) Close() error {
@@ -318,6 +595,13 @@ func (ds *SqliteDataSource) Close() error {
 		log.Printf("sqlite data source doTasks is nil\n")
 		return nil
 	}
+	if !ds.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	// 先停止定期维护和读连接池监控, 避免向已关闭的任务队列提交任务
+	ds.maintainer.stop()
+	close(ds.done)
+	ds.monitorWg.Wait()
 	close(ds.doTasks)
 	// 等待后台任务结束
 	ds.wg.Wait()
@@ -1,6 +1,7 @@
 package rdbms
 
 import (
+	"database/sql"
 	"fmt"
 	"reflect"
 	"strings"
@@ -8,26 +9,64 @@ import (
 )
 
 type TableSpec struct {
-	tableName         string            // 表名
-	primaryInt64Key   string            // 主键字段
-	deleteInt64Key    string            // 逻辑删除字段
-	dbTags            []string          // db tags in order
-	autoUpdateDBTags  map[string]bool   // 自动更新字段
-	fieldNameDBTags   map[string]string // key: field name, value: db tag
-	dbTagFieldNames   map[string]string // key: db tag, value: field name
-	dbTagFieldIndexes map[string]int    // key: db tag, value: field index
-	selectSQL         string            // 查询 SQL 语句
-	insertSQL         string            // 插入 SQL 语句
-	updateSQL         string            // 更新 SQL 语句
-	deleteSQL         string            // 删除 SQL 语句
+	tableName         string               // 表名
+	modelTable        string               // 模型的表名, 分区的表结构中保持不变
+	modelType         reflect.Type         // 模型结构体类型
+	primaryKeys       []string             // 主键字段, 多个字段时为复合主键
+	autoIncrement     bool                 // 单个整数主键, 插入时由数据库生成
+	deleteInt64Key    string               // 逻辑删除字段
+	dbTags            []string             // db tags in order
+	autoUpdateDBTags  map[string]bool      // 自动更新字段
+	fieldNameDBTags   map[string]string    // key: field name, value: db tag
+	dbTagFieldNames   map[string]string    // key: db tag, value: field name
+	dbTagFieldIndexes map[string][]int     // key: db tag, value: field index path, 嵌入结构体的字段路径长度大于 1
+	dbTagConverters   map[string][]string  // key: db tag, value: converter names
+	relations         map[string]*relation // key: field name, value: 关联定义
+	searchColumns     []string             // 全文检索字段
+	searchTokenizer   string               // 全文检索的分词器, 为空时使用 FTS5 默认分词器
+	shardKey          string               // 分片字段, 为空时按主键分片, 见 NewShardedDataSource
+	selectSQL         string               // 查询 SQL 语句
+	insertSQL         string               // 插入 SQL 语句
+	updateSQL         string               // 更新 SQL 语句
+	deleteSQL         string               // 删除 SQL 语句
 }
 
 func (ts *TableSpec) TableName() string {
 	return ts.tableName
 }
 
+func (ts *TableSpec) ModelType() reflect.Type {
+	return ts.modelType
+}
+
+// PrimaryInt64Key 返回第一个主键字段
 func (ts *TableSpec) PrimaryInt64Key() string {
-	return ts.primaryInt64Key
+	if len(ts.primaryKeys) == 0 {
+		return ""
+	}
+	return ts.primaryKeys[0]
+}
+
+func (ts *TableSpec) PrimaryKeys() []string {
+	return ts.primaryKeys
+}
+
+// SearchColumns 全文检索字段, 通过 db tag 的 search 选项声明
+func (ts *TableSpec) SearchColumns() []string {
+	return ts.searchColumns
+}
+
+func (ts *TableSpec) IsAutoIncrement() bool {
+	return ts.autoIncrement
+}
+
+func (ts *TableSpec) isPrimaryKey(dbTag string) bool {
+	for _, key := range ts.primaryKeys {
+		if key == dbTag {
+			return true
+		}
+	}
+	return false
 }
 
 func (ts *TableSpec) DeleteInt64Key() string {
@@ -42,142 +81,479 @@ func (ts *TableSpec) IsLogicDelete() bool {
 	return ts.deleteInt64Key != ""
 }
 
-func (ts *TableSpec) GetFieldIndex(dbTag string) (int, bool) {
+func (ts *TableSpec) GetFieldIndex(dbTag string) ([]int, bool) {
 	index, ok := ts.dbTagFieldIndexes[dbTag]
 	return index, ok
 }
 
-func (ts *TableSpec) getInsertSql() string {
-	if ts.insertSQL != "" {
-		return ts.insertSQL
+// fieldValue 按字段路径获取模型字段, 经过为 nil 的嵌入指针时, alloc 为 true 则创建, 否则返回 false
+func (ts *TableSpec) fieldValue(v reflect.Value, dbTag string, alloc bool) (reflect.Value, bool) {
+	index, ok := ts.GetFieldIndex(dbTag)
+	if !ok {
+		return reflect.Value{}, false
 	}
+	return field_by_index(v, index, alloc)
+}
+
+// field_by_index 按字段路径获取字段, 经过为 nil 的嵌入指针时, alloc 为 true 则创建, 否则返回 false
+func field_by_index(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc || !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// model_value 返回模型的结构体值
+func model_value(model interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(model)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return v, fmt.Errorf("model is not a struct")
+	}
+	return v, nil
+}
+
+// prepareSql 预先生成常用的 SQL 语句, 表结构注册后只读, 可以并发使用
+func (ts *TableSpec) prepareSql() {
 	ts.insertSQL = generateInsertQueryFromTableSpec(ts)
+	ts.updateSQL = generateUpdateQueryFromTableSpec(ts)
+	if len(ts.primaryKeys) > 0 {
+		ts.selectSQL = generateSelectQueryFromTableSpec(ts, 1)
+	}
+}
+
+func (ts *TableSpec) getInsertSql() string {
 	return ts.insertSQL
 }
 
 func (ts *TableSpec) getUpdateSql() string {
-	if ts.updateSQL != "" {
-		return ts.updateSQL
-	}
-	ts.updateSQL = generateUpdateQueryFromTableSpec(ts)
 	return ts.updateSQL
 }
 
+func (ts *TableSpec) getUpdateColumnsSql(columns []string) string {
+	return generateUpdateColumnsQuery(ts, columns)
+}
+
+// getDeleteSql 逻辑删除的语句包含当前时间, 每次生成
 func (ts *TableSpec) getDeleteSql(size int) string {
-	if size == 1 {
-		if ts.deleteSQL != "" {
-			return ts.deleteSQL
-		}
-		ts.deleteSQL = generateDeleteQueryFromTableSpec(ts, 1)
-		return ts.deleteSQL
-	}
 	return generateDeleteQueryFromTableSpec(ts, size)
 }
 
 func (ts *TableSpec) getSelectSql(size int) string {
 	if size == 1 {
-		if ts.selectSQL != "" {
-			return ts.selectSQL
-		}
-		ts.selectSQL = generateSelectQueryFromTableSpec(ts, 1)
 		return ts.selectSQL
 	}
 	return generateSelectQueryFromTableSpec(ts, size)
 }
 
-func (ts *TableSpec) extractInsertUpdateValues(model ITable) ([]interface{}, error) {
-	v := reflect.ValueOf(model)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
+func (ts *TableSpec) extractInsertUpdateValues(model ITable, update bool) ([]interface{}, error) {
+	if update {
+		values, err := ts.extractColumnValues(model, ts.updatableColumns())
+		if err != nil {
+			return nil, err
+		}
+		return append(values, ts.getModelKey(model)...), nil
 	}
-	if v.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("model is not a struct")
+	columns := ts.insertColumns()
+	values, err := ts.extractColumnValues(model, columns)
+	if err != nil {
+		return nil, err
+	}
+	// 自增主键为零时写入 NULL, 由数据库分配; 非零时按指定的值写入
+	if ts.autoIncrement {
+		for i, column := range columns {
+			if column == ts.primaryKeys[0] && reflect.ValueOf(values[i]).IsZero() {
+				values[i] = nil
+			}
+		}
 	}
-	values := make([]interface{}, 0, len(ts.dbTags))
+	return values, nil
+}
+
+// insertColumns 返回插入时需要传值的字段: 排除逻辑删除字段
+func (ts *TableSpec) insertColumns() []string {
+	columns := make([]string, 0, len(ts.dbTags))
 	for _, dbTag := range ts.dbTags {
-		if dbTag == ts.primaryInt64Key {
+		if dbTag == ts.deleteInt64Key {
 			continue
 		}
-		fieldIndex, ok := ts.GetFieldIndex(dbTag)
+		columns = append(columns, dbTag)
+	}
+	return columns
+}
+
+// updatableColumns 返回可更新的字段: 排除主键、逻辑删除字段和自动更新字段
+func (ts *TableSpec) updatableColumns() []string {
+	columns := make([]string, 0, len(ts.dbTags))
+	for _, dbTag := range ts.dbTags {
+		if ts.isPrimaryKey(dbTag) || dbTag == ts.deleteInt64Key || ts.autoUpdateDBTags[dbTag] {
+			continue
+		}
+		columns = append(columns, dbTag)
+	}
+	return columns
+}
+
+// checkUpdateColumns 校验指定更新的字段, 主键和逻辑删除字段不允许直接更新
+func (ts *TableSpec) checkUpdateColumns(columns []string) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("update columns is empty")
+	}
+	for _, column := range columns {
+		if _, ok := ts.dbTagFieldIndexes[column]; !ok {
+			return fmt.Errorf("column %s not found in table[%s]", column, ts.tableName)
+		}
+		if ts.isPrimaryKey(column) || column == ts.deleteInt64Key {
+			return fmt.Errorf("column %s of table[%s] is not updatable", column, ts.tableName)
+		}
+	}
+	return nil
+}
+
+// extractColumnValues 按字段顺序提取模型的值
+func (ts *TableSpec) extractColumnValues(model ITable, columns []string) ([]interface{}, error) {
+	v, err := model_value(model)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, 0, len(columns))
+	key := ts.getModelKey(model)
+	for _, column := range columns {
+		if _, ok := ts.GetFieldIndex(column); !ok {
+			return nil, fmt.Errorf("db tag %s not found in table spec", column)
+		}
+		// 嵌入的结构体指针为 nil 时, 字段值为 NULL
+		field, ok := ts.fieldValue(v, column, false)
 		if !ok {
-			return nil, fmt.Errorf("db tag %s not found in table spec", dbTag)
+			values = append(values, nil)
+			continue
 		}
-		values = append(values, v.Field(fieldIndex).Interface())
+		if names, ok := ts.dbTagConverters[column]; ok {
+			value, err := encode_field(field, names, &ColumnContext{Table: ts.modelTable, Column: column, Key: key})
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", column, err)
+			}
+			values = append(values, value)
+			continue
+		}
+		values = append(values, field.Interface())
 	}
 	return values, nil
 }
 
-func (ts *TableSpec) getModelId(model ITable) int64 {
+// snapshotModel 记录模型可更新字段的当前值, 用于更新时比对变更
+func (ts *TableSpec) snapshotModel(model ITable) (map[string]interface{}, error) {
+	v, err := model_value(model)
+	if err != nil {
+		return nil, err
+	}
+	columns := ts.updatableColumns()
+	snapshot := make(map[string]interface{}, len(columns))
+	for _, column := range columns {
+		// 嵌入的结构体指针为 nil 时, 字段值为 NULL
+		var value interface{}
+		if field, ok := ts.fieldValue(v, column, false); ok {
+			if names, ok := ts.dbTagConverters[column]; ok {
+				if value, err = snapshot_field(field, names); err != nil {
+					return nil, fmt.Errorf("column %s: %w", column, err)
+				}
+			} else {
+				value = field.Interface()
+			}
+		}
+		// 指针字段记录指向的值, 否则原地修改无法检测
+		if rv := reflect.ValueOf(value); rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				value = nil
+			} else {
+				value = rv.Elem().Interface()
+			}
+		}
+		// []byte 共享底层数组, 需要复制一份
+		if bytes, ok := value.([]byte); ok {
+			value = append([]byte(nil), bytes...)
+		}
+		snapshot[column] = value
+	}
+	return snapshot, nil
+}
+
+// diffModel 返回与快照相比发生变化的字段
+func (ts *TableSpec) diffModel(model ITable, snapshot map[string]interface{}) ([]string, error) {
+	current, err := ts.snapshotModel(model)
+	if err != nil {
+		return nil, err
+	}
+	changed := make([]string, 0)
+	for _, column := range ts.updatableColumns() {
+		if !reflect.DeepEqual(current[column], snapshot[column]) {
+			changed = append(changed, column)
+		}
+	}
+	return changed, nil
+}
+
+// scanRow 将一行查询结果按字段名写入模型, 未知的字段忽略; 声明了转换器的字段先读取原始值再解码
+func (ts *TableSpec) scanRow(rows *sql.Rows, columns []string, v reflect.Value) error {
+	return ts.scanRowExtras(rows, columns, v, nil)
+}
+
+// scanRowExtras 读取一行到模型, extras 中的字段读取到对应的指针, 例如检索的相关度
+func (ts *TableSpec) scanRowExtras(rows *sql.Rows, columns []string, v reflect.Value, extras map[string]interface{}) error {
+	dest := make([]interface{}, len(columns))
+	raws := make(map[string]*interface{})
+	for i, column := range columns {
+		if extra, ok := extras[column]; ok {
+			dest[i] = extra
+			continue
+		}
+		if _, ok := ts.dbTagConverters[column]; ok {
+			raw := new(interface{})
+			raws[column] = raw
+			dest[i] = raw
+			continue
+		}
+		field, ok := ts.fieldValue(v, column, true)
+		if !ok {
+			dest[i] = new(interface{})
+			continue
+		}
+		dest[i] = field.Addr().Interface()
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return err
+	}
+	// 主键没有声明转换器, 此时已写入模型
+	key := ts.rowKey(v)
+	for column, raw := range raws {
+		field, ok := ts.fieldValue(v, column, true)
+		if !ok {
+			continue
+		}
+		if err := decode_field(*raw, field, ts.dbTagConverters[column], &ColumnContext{Table: ts.modelTable, Column: column, Key: key}); err != nil {
+			return fmt.Errorf("column %s: %w", column, err)
+		}
+	}
+	return nil
+}
+
+// scanOne 读取第一行到模型, 没有数据时返回 sql.ErrNoRows
+func (ts *TableSpec) scanOne(rows *sql.Rows, model interface{}) error {
+	defer rows.Close()
 	v := reflect.ValueOf(model)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("model must be a pointer to a struct")
 	}
-	if v.Kind() != reflect.Struct {
-		return 0
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
 	}
-	fieldIndex, ok := ts.GetFieldIndex(ts.primaryInt64Key)
-	if !ok {
-		return 0
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	return ts.scanRow(rows, columns, v.Elem())
+}
+
+// scanAll 读取全部结果追加到切片, 切片元素可以是结构体或结构体指针
+func (ts *TableSpec) scanAll(rows *sql.Rows, slicePtr interface{}) error {
+	defer rows.Close()
+	sliceValue := reflect.ValueOf(slicePtr)
+	if sliceValue.Kind() != reflect.Ptr || sliceValue.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("emptyTableSlice must be a pointer to a slice")
+	}
+	slice := sliceValue.Elem()
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		elem := reflect.New(elemType)
+		if err := ts.scanRow(rows, columns, elem.Elem()); err != nil {
+			return err
+		}
+		if isPtr {
+			slice = reflect.Append(slice, elem)
+		} else {
+			slice = reflect.Append(slice, elem.Elem())
+		}
 	}
-	return v.Field(fieldIndex).Int()
+	sliceValue.Elem().Set(slice)
+	return rows.Err()
 }
 
-func (ts *TableSpec) getModelIds(models []ITable) []int64 {
-	ids := make([]int64, 0, len(models))
-	for _, model := range models {
-		ids = append(ids, ts.getModelId(model))
+// getModelKey 按主键顺序返回模型的主键值
+func (ts *TableSpec) getModelKey(model ITable) Key {
+	v, err := model_value(model)
+	if err != nil {
+		return nil
 	}
-	return ids
+	return ts.rowKey(v)
 }
 
+// rowKey 按主键顺序返回结构体的主键值
+func (ts *TableSpec) rowKey(v reflect.Value) Key {
+	key := make(Key, 0, len(ts.primaryKeys))
+	for _, primaryKey := range ts.primaryKeys {
+		field, ok := ts.fieldValue(v, primaryKey, false)
+		if !ok {
+			return nil
+		}
+		key = append(key, field.Interface())
+	}
+	return key
+}
+
+// hasModelKey 判断模型的主键是否已赋值
+func (ts *TableSpec) hasModelKey(model ITable) bool {
+	key := ts.getModelKey(model)
+	if len(key) == 0 {
+		return false
+	}
+	// 单个主键为零值视为未设置; 复合主键的部分可以为零值, 只排除 NULL
+	if len(key) == 1 {
+		return !is_nil_value(key[0]) && !reflect.ValueOf(key[0]).IsZero()
+	}
+	for _, value := range key {
+		if is_nil_value(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// setModelId 为自增主键的模型回填插入后的 ID
 func (ts *TableSpec) setModelId(model ITable, id int64) {
+	if !ts.autoIncrement {
+		return
+	}
 	v := reflect.ValueOf(model)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
+	if v.Kind() != reflect.Ptr {
+		return
 	}
+	v = v.Elem()
 	if v.Kind() != reflect.Struct {
 		return
 	}
-	fieldIndex, ok := ts.GetFieldIndex(ts.primaryInt64Key)
+	field, ok := ts.fieldValue(v, ts.primaryKeys[0], true)
 	if !ok {
 		return
 	}
-	v.Field(fieldIndex).SetInt(id)
+	if field.CanSet() {
+		field.SetInt(id)
+	}
 }
 
-func (ts *TableSpec) UnMap(model *ITable, value map[string]interface{}) error {
-	v := reflect.ValueOf(model)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
+// is_nil_value 值为 nil 或 nil 指针
+func is_nil_value(value interface{}) bool {
+	if value == nil {
+		return true
 	}
-	if v.Kind() != reflect.Struct {
-		return fmt.Errorf("model is not a struct")
+	v := reflect.ValueOf(value)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+// keyArgs 将主键值展开为 SQL 参数, 复合主键需要使用 Key 按主键顺序传值
+func (ts *TableSpec) keyArgs(keys []interface{}) ([]interface{}, error) {
+	if len(ts.primaryKeys) == 0 {
+		return nil, fmt.Errorf("table[%s] has no primary key", ts.tableName)
+	}
+	args := make([]interface{}, 0, len(keys)*len(ts.primaryKeys))
+	for _, key := range keys {
+		values, ok := key.(Key)
+		if !ok {
+			values = Key{key}
+		}
+		if len(values) != len(ts.primaryKeys) {
+			return nil, fmt.Errorf("key %v does not match primary keys %v of table[%s]", key, ts.primaryKeys, ts.tableName)
+		}
+		for _, value := range values {
+			if is_nil_value(value) {
+				return nil, fmt.Errorf("key %v of table[%s] is nil", key, ts.tableName)
+			}
+		}
+		args = append(args, values...)
 	}
+	return args, nil
+}
+
+// UnMap 将 map 的值写入模型, key 可以是 db tag 也可以是字段名
+func (ts *TableSpec) UnMap(model ITable, value map[string]interface{}) error {
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("model must be a pointer to a struct")
+	}
+	v = v.Elem()
 	for key, fieldValue := range value {
-		// key is db tag
-		if fieldIndex, ok := ts.GetFieldIndex(key); ok {
-			v.Field(fieldIndex).Set(reflect.ValueOf(fieldValue))
+		dbTag := key
+		if _, ok := ts.GetFieldIndex(key); !ok {
+			// key is field name
+			if dbTag, ok = ts.fieldNameDBTags[key]; !ok {
+				continue
+			}
+		}
+		field, ok := ts.fieldValue(v, dbTag, true)
+		if !ok {
 			continue
 		}
-		// key is filed name
-		dbTag, ok := ts.fieldNameDBTags[key]
-		if ok {
-			fieldIndex, ok := ts.GetFieldIndex(dbTag)
-			if ok {
-				v.Field(fieldIndex).Set(reflect.ValueOf(fieldValue))
-			}
+		if err := set_field_value(field, fieldValue); err != nil {
+			return fmt.Errorf("set field %s failed: %w", dbTag, err)
 		}
 	}
 	return nil
 }
 
+// set_field_value 为字段赋值, 类型不同时尝试转换, 指针字段自动取址
+func set_field_value(field reflect.Value, value interface{}) error {
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	rv := reflect.ValueOf(value)
+	if field.Kind() == reflect.Ptr && rv.Kind() != reflect.Ptr {
+		ptr := reflect.New(field.Type().Elem())
+		if err := set_field_value(ptr.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+	if rv.Type().AssignableTo(field.Type()) {
+		field.Set(rv)
+		return nil
+	}
+	if scanner, ok := field.Addr().Interface().(sql.Scanner); ok {
+		return scanner.Scan(value)
+	}
+	if rv.Type().ConvertibleTo(field.Type()) {
+		field.Set(rv.Convert(field.Type()))
+		return nil
+	}
+	return fmt.Errorf("cannot assign %T to %s", value, field.Type())
+}
+
 func generateInsertQueryFromTableSpec(ts *TableSpec) string {
 	columns := make([]string, 0, len(ts.dbTags))
 	values := make([]string, 0, len(ts.dbTags))
 	for _, dbTag := range ts.dbTags {
-		if dbTag == ts.primaryInt64Key {
-			continue
-		}
 		columns = append(columns, dbTag)
 		if dbTag == ts.deleteInt64Key {
 			values = append(values, "0")
@@ -195,94 +571,140 @@ func generateInsertQueryFromTableSpec(ts *TableSpec) string {
 }
 
 func generateUpdateQueryFromTableSpec(ts *TableSpec) string {
-	columns := make([]string, 0, len(ts.dbTags))
-	for _, dbTag := range ts.dbTags {
-		if dbTag == ts.primaryInt64Key || dbTag == ts.deleteInt64Key || ts.autoUpdateDBTags[dbTag] {
-			continue
-		}
-		columns = append(columns, fmt.Sprintf("%s = ?", dbTag))
+	return generateUpdateColumnsQuery(ts, ts.updatableColumns())
+}
+
+func generateUpdateColumnsQuery(ts *TableSpec, columns []string) string {
+	sets := make([]string, 0, len(columns))
+	for _, column := range columns {
+		sets = append(sets, fmt.Sprintf("%s = ?", column))
 	}
 	sql := fmt.Sprintf(
-		"UPDATE %s SET %s WHERE %s = ?",
+		"UPDATE %s SET %s WHERE %s",
 		ts.tableName,
-		strings.Join(columns, ","),
-		ts.primaryInt64Key,
+		strings.Join(sets, ","),
+		generateKeyCondition(ts, 1),
 	)
 	return sql
 }
 
-func generateDeleteQueryFromTableSpec(ts *TableSpec, size int) string {
-	var sql string
-	if size == 1 {
-		if ts.IsLogicDelete() {
-			sql = fmt.Sprintf(
-				"UPDATE %s SET %s = %d WHERE %s = ?",
-				ts.tableName,
-				ts.deleteInt64Key,
-				time.Now().Unix(),
-				ts.primaryInt64Key,
-			)
-		} else {
-			sql = fmt.Sprintf(
-				"DELETE FROM %s WHERE %s = ?",
-				ts.tableName,
-				ts.primaryInt64Key,
-			)
+// generateKeyCondition 生成主键条件, 复合主键使用行值比较
+func generateKeyCondition(ts *TableSpec, size int) string {
+	if len(ts.primaryKeys) == 1 {
+		if size == 1 {
+			return fmt.Sprintf("%s = ?", ts.primaryKeys[0])
 		}
-	} else {
-		placeholder := make([]string, 0, size)
-		for i := 0; i < size; i++ {
-			placeholder = append(placeholder, "?")
-		}
-		if ts.IsLogicDelete() {
-			sql = fmt.Sprintf(
-				"UPDATE %s SET %s = %d WHERE %s in (%s)",
-				ts.tableName,
-				ts.deleteInt64Key,
-				time.Now().Unix(),
-				ts.primaryInt64Key,
-				strings.Join(placeholder, ","),
-			)
-		} else {
-			sql = fmt.Sprintf(
-				"DELETE FROM %s WHERE %s in (%s)",
-				ts.tableName,
-				ts.primaryInt64Key,
-				strings.Join(placeholder, ","),
-			)
+		return fmt.Sprintf("%s in %s", ts.primaryKeys[0], SqlInValues(size))
+	}
+	if size == 1 {
+		conditions := make([]string, 0, len(ts.primaryKeys))
+		for _, key := range ts.primaryKeys {
+			conditions = append(conditions, fmt.Sprintf("%s = ?", key))
 		}
+		return strings.Join(conditions, " AND ")
 	}
-	return sql
+	rows := make([]string, 0, size)
+	for i := 0; i < size; i++ {
+		rows = append(rows, SqlInValues(len(ts.primaryKeys)))
+	}
+	return fmt.Sprintf("(%s) in (VALUES %s)", strings.Join(ts.primaryKeys, ","), strings.Join(rows, ","))
 }
 
-func generateSelectQueryFromTableSpec(ts *TableSpec, size int) string {
-	columns := make([]string, 0, len(ts.dbTags))
-	for _, dbTag := range ts.dbTags {
-		columns = append(columns, dbTag)
-	}
+func generateDeleteQueryFromTableSpec(ts *TableSpec, size int) string {
 	var sql string
-	if size == 1 {
+	if ts.IsLogicDelete() {
 		sql = fmt.Sprintf(
-			"SELECT %s FROM %s WHERE %s = ?",
-			strings.Join(columns, ","),
+			"UPDATE %s SET %s = %d WHERE %s",
 			ts.tableName,
-			ts.primaryInt64Key,
+			ts.deleteInt64Key,
+			time.Now().Unix(),
+			generateKeyCondition(ts, size),
 		)
 	} else {
-		placeholder := make([]string, 0, size)
-		for i := 0; i < size; i++ {
-			placeholder = append(placeholder, "?")
-		}
 		sql = fmt.Sprintf(
-			"SELECT %s FROM %s WHERE %s in (%s)",
-			strings.Join(columns, ","),
+			"DELETE FROM %s WHERE %s",
 			ts.tableName,
-			ts.primaryInt64Key,
-			strings.Join(placeholder, ","),
+			generateKeyCondition(ts, size),
 		)
 	}
+	return sql
+}
+
+func generateSelectQueryFromTableSpec(ts *TableSpec, size int) string {
+	sql := fmt.Sprintf(
+		"SELECT %s FROM %s WHERE %s",
+		strings.Join(ts.dbTags, ","),
+		ts.tableName,
+		generateKeyCondition(ts, size),
+	)
 	if ts.IsLogicDelete() {
 		sql = fmt.Sprintf("%s AND %s = 0", sql, ts.deleteInt64Key)
 	}
 	return sql
 }
+
+// withTableName 复制表结构并替换表名, 用于分区表和跨分区查询
+func (ts *TableSpec) withTableName(tableName string) *TableSpec {
+	clone := *ts
+	clone.tableName = tableName
+	clone.prepareSql()
+	return &clone
+}
+
+// columnType 按字段类型和最后一个转换器推断列类型, 无法推断时返回空, 由 sqlite 按值存储
+func (ts *TableSpec) columnType(dbTag string) string {
+	if names := ts.dbTagConverters[dbTag]; len(names) > 0 {
+		switch names[len(names)-1] {
+		case converter_time:
+			if layout := get_time_layout(); layout == "unix" || layout == "unixmilli" {
+				return "INTEGER"
+			}
+			return "TEXT"
+		case converter_json, converter_encrypted:
+			return "TEXT"
+		default:
+			return ""
+		}
+	}
+	index, ok := ts.dbTagFieldIndexes[dbTag]
+	if !ok {
+		return ""
+	}
+	t := ts.modelType.FieldByIndex(index).Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "INTEGER"
+	case reflect.Float32, reflect.Float64:
+		return "REAL"
+	case reflect.String:
+		return "TEXT"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "BLOB"
+		}
+	}
+	return ""
+}
+
+// generateCreateTableQuery 按表结构生成建表语句, 单个整数主键为自增主键
+func generateCreateTableQuery(ts *TableSpec, tableName string) string {
+	columns := make([]string, 0, len(ts.dbTags)+1)
+	for _, dbTag := range ts.dbTags {
+		column := strings.TrimSpace(dbTag + " " + ts.columnType(dbTag))
+		switch {
+		case ts.autoIncrement && ts.isPrimaryKey(dbTag):
+			column = dbTag + " INTEGER PRIMARY KEY AUTOINCREMENT"
+		case dbTag == ts.deleteInt64Key:
+			column += " NOT NULL DEFAULT 0"
+		}
+		columns = append(columns, column)
+	}
+	if !ts.autoIncrement && len(ts.primaryKeys) > 0 {
+		columns = append(columns, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(ts.primaryKeys, ", ")))
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", tableName, strings.Join(columns, ", "))
+}
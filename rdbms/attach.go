@@ -0,0 +1,103 @@
+package rdbms
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"modernc.org/sqlite"
+)
+
+// 附加数据库: 在 DB URL 中声明 attach.<schema>=<path>, 每个连接建立后 ATTACH, 例如:
+//
+//	sqlite:./data/app.db?attach.archive=./data/archive.db&attach.ref=./data/ref.db
+//
+// 表模型的 TableName 返回 archive.orders 即可读写附加库的表, 也可以在 SQL 中跨库关联;
+// 写连接以读写方式附加, 文件不存在时创建; 读连接以只读方式附加;
+// 附加库的表不支持全文检索、审计和变更捕获, 这些功能依赖的触发器不能跨库写入
+
+const attachParam = "_lts_attach"
+
+func init() {
+	sqlite.RegisterConnectionHook(attach_databases)
+}
+
+// attach_databases 连接钩子, 按 DSN 中的 _lts_attach 参数附加数据库, 只读连接以只读方式附加
+func attach_databases(conn sqlite.ExecQuerierContext, dsn string) error {
+	_, rawQuery, ok := strings.Cut(dsn, "?")
+	if !ok {
+		return nil
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil
+	}
+	readonly := query.Get("mode") == "ro"
+	for _, attach := range query[attachParam] {
+		schema, path, _ := strings.Cut(attach, "=")
+		if !is_identifier(schema) {
+			return fmt.Errorf("invalid attach schema: %s", schema)
+		}
+		uri := "file:" + sqlite_uri_path(path)
+		if readonly {
+			uri += "?mode=ro"
+		}
+		args := []driver.NamedValue{{Ordinal: 1, Value: uri}}
+		if _, err := conn.ExecContext(context.Background(), "ATTACH DATABASE ? AS "+schema, args); err != nil {
+			return fmt.Errorf("attach %s as %s failed: %w", path, schema, err)
+		}
+	}
+	return nil
+}
+
+// split_table_name 拆分 schema.table 形式的表名, main 库的表返回空 schema
+func split_table_name(tableName string) (string, string) {
+	schema, name, ok := strings.Cut(tableName, ".")
+	if !ok {
+		return "", tableName
+	}
+	if strings.EqualFold(schema, "main") {
+		return "", name
+	}
+	return schema, name
+}
+
+// check_main_table 依赖触发器的功能只支持 main 库的表
+func check_main_table(tableName string, feature string) error {
+	if schema, _ := split_table_name(tableName); schema != "" {
+		return fmt.Errorf("%s is not supported for attached table %s", feature, tableName)
+	}
+	return nil
+}
+
+func is_identifier(name string) bool {
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !is_ident_char(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// AttachURL 在 sqlite DB URL 后追加附加数据库和只读副本参数, replica 为空时不使用副本
+func AttachURL(db_url string, attach map[string]string, replica string) string {
+	query := url.Values{}
+	for schema, path := range attach {
+		query.Set("attach."+schema, path)
+	}
+	if replica != "" {
+		query.Set("replica", replica)
+	}
+	if len(query) == 0 {
+		return db_url
+	}
+	if strings.Contains(db_url, "?") {
+		return db_url + "&" + query.Encode()
+	}
+	return db_url + "?" + query.Encode()
+}
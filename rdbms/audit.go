@@ -0,0 +1,346 @@
+package rdbms
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 审计: 开启后由触发器在写入的同一事务中把前后镜像写入 <table>_audit 表, 例如:
+//
+//	ds.EnableAudit(&User{})
+//	dao := ds.NewDao(rdbms.WithContext(rdbms.ContextWithActor(ctx, "alice")))
+//	dao.TableUpdate(user)
+//	records, _ := dao.AuditHistory(&User{}, user.ID)
+//
+// 操作人由写协程在同一事务中写入 _lts_audit_actor 表, 触发器读取后事务提交前清除;
+// 没有操作人的写入(例如其他进程) 同样会记录, 操作人为空
+
+// 审计操作类型, 与 ChangeEvent 一致
+const (
+	AuditInsert = ChangeInsert
+	AuditUpdate = ChangeUpdate
+	AuditDelete = ChangeDelete
+)
+
+const auditActorTableName = "_lts_audit_actor"
+
+// 镜像中 BLOB 字段的标记, 记录为 {"$blob": "十六进制"}, 读取时还原为 []byte
+const auditBlobKey = "$blob"
+
+// AuditRecord 一次写入的审计记录
+type AuditRecord struct {
+	ID     int64                  `json:"id"`
+	Op     string                 `json:"op"`     // 操作类型, 逻辑删除记录为 update
+	Key    []interface{}          `json:"key"`    // 主键值, 按主键字段顺序
+	Before map[string]interface{} `json:"before"` // 写入前的字段值, insert 为 nil
+	After  map[string]interface{} `json:"after"`  // 写入后的字段值, delete 为 nil
+	Actor  string                 `json:"actor"`  // 操作人
+	Time   time.Time              `json:"time"`   // 写入时间, 毫秒精度
+}
+
+type actorKey struct{}
+
+// ContextWithActor 在 context 中记录操作人, 通过 WithContext 传给 DAO
+func ContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext 读取 context 中的操作人
+func ActorFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+func audit_table_name(ts *TableSpec) string {
+	return ts.tableName + "_audit"
+}
+
+func generateAuditTableQueries(ts *TableSpec) []string {
+	audit := audit_table_name(ts)
+	return []string{
+		`CREATE TABLE IF NOT EXISTS ` + auditActorTableName + ` (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		actor TEXT NOT NULL
+	)`,
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		pk TEXT NOT NULL,
+		op TEXT NOT NULL,
+		before_image TEXT,
+		after_image TEXT,
+		actor TEXT,
+		created_at INTEGER NOT NULL
+	)`, audit),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_pk ON %s(pk, created_at)", audit, audit),
+	}
+}
+
+// generateAuditTriggerQueries 生成记录前后镜像的触发器, BLOB 字段记录为带标记的十六进制文本
+func generateAuditTriggerQueries(ts *TableSpec) []string {
+	keys := ts.primaryKeys
+	if len(keys) == 0 {
+		keys = []string{"rowid"}
+	}
+	row_key := func(prefix string) string {
+		values := make([]string, 0, len(keys))
+		for _, key := range keys {
+			values = append(values, prefix+"."+key)
+		}
+		return "json_array(" + strings.Join(values, ", ") + ")"
+	}
+	image := func(prefix string) string {
+		pairs := make([]string, 0, len(ts.dbTags))
+		for _, column := range ts.dbTags {
+			value := prefix + "." + column
+			pairs = append(pairs, fmt.Sprintf("'%s', CASE typeof(%s) WHEN 'blob' THEN json_object('%s', hex(%s)) ELSE %s END", column, value, auditBlobKey, value, value))
+		}
+		return "json_object(" + strings.Join(pairs, ", ") + ")"
+	}
+	conditions := make([]string, 0, len(ts.dbTags))
+	for _, column := range ts.dbTags {
+		conditions = append(conditions, fmt.Sprintf("old.%s IS NOT new.%s", column, column))
+	}
+	audit := audit_table_name(ts)
+	record := func(op string, key string, before string, after string) string {
+		return fmt.Sprintf("INSERT INTO %s(pk, op, before_image, after_image, actor, created_at) VALUES (%s, '%s', %s, %s, (SELECT actor FROM %s WHERE id = 1), CAST(unixepoch('subsec') * 1000 AS INTEGER));",
+			audit, key, op, before, after, auditActorTableName)
+	}
+
+	return []string{
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s_ai", audit),
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s_au", audit),
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s_ad", audit),
+		fmt.Sprintf("CREATE TRIGGER %s_ai AFTER INSERT ON %s BEGIN %s END", audit, ts.tableName,
+			record(AuditInsert, row_key("new"), "NULL", image("new"))),
+		fmt.Sprintf("CREATE TRIGGER %s_au AFTER UPDATE ON %s WHEN %s BEGIN %s END", audit, ts.tableName,
+			strings.Join(conditions, " OR "), record(AuditUpdate, row_key("new"), image("old"), image("new"))),
+		fmt.Sprintf("CREATE TRIGGER %s_ad AFTER DELETE ON %s BEGIN %s END", audit, ts.tableName,
+			record(AuditDelete, row_key("old"), image("old"), "NULL")),
+	}
+}
+
+// EnableAudit 为模型对应的表开启审计, 可以重复调用, 表结构变化后重新调用即可更新触发器
+func (ds *SqliteDataSource) EnableAudit(models ...ITable) error {
+	specs := make([]*TableSpec, 0, len(models))
+	for _, model := range models {
+		ts, err := ds.getModelSpec(model)
+		if err != nil {
+			return err
+		}
+		if err := check_main_table(ts.tableName, "audit"); err != nil {
+			return err
+		}
+		specs = append(specs, ts)
+	}
+	task := SqlTask{
+		Do: func(writer *sqlx.DB) error {
+			tx, err := writer.Beginx()
+			if err != nil {
+				return err
+			}
+			statements := []string{}
+			for _, ts := range specs {
+				statements = append(statements, generateAuditTableQueries(ts)...)
+				statements = append(statements, generateAuditTriggerQueries(ts)...)
+			}
+			for _, statement := range statements {
+				if _, err := tx.Exec(statement); err != nil {
+					tx.Rollback()
+					return fmt.Errorf("enable audit failed: %w", err)
+				}
+			}
+			return tx.Commit()
+		},
+		Result: make(chan SqlResult, 1),
+	}
+	defer task.Close()
+	ds.enqueue(task)
+	if result := <-task.Result; result.Err != nil {
+		return result.Err
+	}
+	ds.auditing.Store(true)
+	return nil
+}
+
+// DisableAudit 删除模型对应表的审计触发器, 已有的审计记录保留
+func (ds *SqliteDataSource) DisableAudit(models ...ITable) error {
+	statements := []string{}
+	for _, model := range models {
+		ts, err := ds.getModelSpec(model)
+		if err != nil {
+			return err
+		}
+		audit := audit_table_name(ts)
+		statements = append(statements,
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s_ai", audit),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s_au", audit),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s_ad", audit),
+		)
+	}
+	result := make(chan SqlResult, 1)
+	ds.enqueue(SqlTask{
+		Do: func(writer *sqlx.DB) error {
+			for _, statement := range statements {
+				if _, err := writer.Exec(statement); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Result: result,
+	})
+	return (<-result).Err
+}
+
+// has_audit_triggers 数据库中是否有审计触发器, 例如上次启动或其他进程开启的审计
+func has_audit_triggers(writer *sqlx.DB) (bool, error) {
+	var count int
+	err := writer.Get(&count, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE '%\\_audit\\_a_' ESCAPE '\\'")
+	return count > 0, err
+}
+
+// audit_actor 返回写任务需要记录的操作人, 未开启审计时为空
+func (ds *SqliteDataSource) audit_actor(task *SqlTask) string {
+	if !ds.auditing.Load() {
+		return ""
+	}
+	return task.actor
+}
+
+func set_audit_actor(tx *sqlx.Tx, actor string) error {
+	_, err := tx.Exec("INSERT OR REPLACE INTO "+auditActorTableName+"(id, actor) VALUES (1, ?)", actor)
+	return err
+}
+
+func clear_audit_actor(tx *sqlx.Tx) error {
+	_, err := tx.Exec("DELETE FROM " + auditActorTableName)
+	return err
+}
+
+// with_audit_actor 在事务中记录操作人后执行 fn, 提交前清除操作人
+func with_audit_actor(writer *sqlx.DB, actor string, fn func(tx *sqlx.Tx) error) error {
+	tx, err := writer.Beginx()
+	if err != nil {
+		return err
+	}
+	if err = set_audit_actor(tx, actor); err == nil {
+		if err = fn(tx); err == nil {
+			err = clear_audit_actor(tx)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// AuditHistory 按时间顺序返回记录的全部审计记录
+func (dao *SqliteDao) AuditHistory(emptyTableModel interface{}, key interface{}) ([]AuditRecord, error) {
+	ts, err := dao.ds.getModelSpec(emptyTableModel)
+	if err != nil {
+		return nil, err
+	}
+	args, err := ts.keyArgs([]interface{}{key})
+	if err != nil {
+		return nil, err
+	}
+	type auditRow struct {
+		ID        int64   `db:"id"`
+		Key       string  `db:"pk"`
+		Op        string  `db:"op"`
+		Before    *string `db:"before_image"`
+		After     *string `db:"after_image"`
+		Actor     *string `db:"actor"`
+		CreatedAt int64   `db:"created_at"`
+	}
+	rows := []auditRow{}
+	statement := fmt.Sprintf("SELECT id, pk, op, before_image, after_image, actor, created_at FROM %s WHERE pk = json_array(%s) ORDER BY id",
+		audit_table_name(ts), strings.Repeat("?, ", len(args)-1)+"?")
+	err = dao.Read(func(conn sqlx.Queryer) error {
+		return sqlx.Select(conn, &rows, statement, args...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	records := make([]AuditRecord, 0, len(rows))
+	for _, row := range rows {
+		record := AuditRecord{ID: row.ID, Op: row.Op, Time: time.UnixMilli(row.CreatedAt)}
+		if row.Actor != nil {
+			record.Actor = *row.Actor
+		}
+		if record.Key, err = decode_change_key(row.Key); err != nil {
+			return nil, fmt.Errorf("decode audit %d key failed: %w", row.ID, err)
+		}
+		if record.Before, err = decode_audit_image(row.Before); err != nil {
+			return nil, fmt.Errorf("decode audit %d before image failed: %w", row.ID, err)
+		}
+		if record.After, err = decode_audit_image(row.After); err != nil {
+			return nil, fmt.Errorf("decode audit %d after image failed: %w", row.ID, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// AuditAsOf 使用审计记录还原指定时间的记录, 当时记录不存在或已被删除时返回 sql.ErrNoRows
+func (dao *SqliteDao) AuditAsOf(emptyTableModel interface{}, key interface{}, at time.Time) error {
+	ts, err := dao.ds.getModelSpec(emptyTableModel)
+	if err != nil {
+		return err
+	}
+	args, err := ts.keyArgs([]interface{}{key})
+	if err != nil {
+		return err
+	}
+	columns := make([]string, 0, len(ts.dbTags))
+	for _, column := range ts.dbTags {
+		path := fmt.Sprintf("'$.\"%s\"'", column)
+		columns = append(columns, fmt.Sprintf("CASE json_type(after_image, %s) WHEN 'object' THEN unhex(json_extract(after_image, '$.\"%s\".\"%s\"')) ELSE json_extract(after_image, %s) END AS %s",
+			path, column, auditBlobKey, path, column))
+	}
+	statement := fmt.Sprintf("SELECT %s FROM (SELECT op, after_image FROM %s WHERE pk = json_array(%s) AND created_at <= ? ORDER BY id DESC LIMIT 1) WHERE op != '%s'",
+		strings.Join(columns, ", "), audit_table_name(ts), strings.Repeat("?, ", len(args)-1)+"?", AuditDelete)
+	args = append(args, at.UnixMilli())
+	return dao.Read(func(conn sqlx.Queryer) error {
+		rows, err := conn.Query(statement, args...)
+		if err != nil {
+			return err
+		}
+		return ts.scanOne(rows, emptyTableModel)
+	})
+}
+
+func decode_audit_image(text *string) (map[string]interface{}, error) {
+	if text == nil {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(*text))
+	decoder.UseNumber()
+	image := map[string]interface{}{}
+	if err := decoder.Decode(&image); err != nil {
+		return nil, err
+	}
+	for column, value := range image {
+		if blob, ok := value.(map[string]interface{}); ok {
+			encoded, _ := blob[auditBlobKey].(string)
+			data, err := hex.DecodeString(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("decode blob column %s failed: %w", column, err)
+			}
+			image[column] = data
+			continue
+		}
+		image[column] = json_number_value(value)
+	}
+	return image, nil
+}
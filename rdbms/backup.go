@@ -0,0 +1,154 @@
+package rdbms
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"modernc.org/sqlite"
+)
+
+// Backup 使用 VACUUM INTO 在独立的只读连接上生成一致性快照, 不阻塞写协程;
+// 快照包含调用前已提交的所有写任务, 先写入临时文件再重命名为 destPath
+func (ds *SqliteDataSource) Backup(ctx context.Context, destPath string) error {
+	if destPath == "" {
+		return fmt.Errorf("backup path is empty")
+	}
+	if err := ds.barrier(ctx); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	tmpPath := destPath + ".tmp"
+	if err := vacuum_into(ctx, ds.config, ds.db_path, tmpPath); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to move backup file: %w", err)
+	}
+	log.Printf("sqlite data source[%s] backup to %s\n", ds.id, destPath)
+	return nil
+}
+
+// Restore 校验备份文件后在写协程中用备份内容覆盖当前数据库, 期间写任务排队等待;
+// 覆盖是原子的, 失败时数据库保持原样; Conn() 返回的连接池保持有效, 之后的查询读到恢复后的数据.
+// WAL 模式下备份文件的 page_size 必须与当前数据库一致
+func (ds *SqliteDataSource) Restore(srcPath string) error {
+	if _, err := os.Stat(srcPath); err != nil {
+		return fmt.Errorf("backup file does not exist: %w", err)
+	}
+
+	// 先生成不依赖 WAL 的单文件副本并校验
+	tmpPath := ds.db_path + ".restore"
+	if err := vacuum_into(context.Background(), ds.config, srcPath, tmpPath); err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	if err := check_integrity(ds.config, tmpPath); err != nil {
+		return err
+	}
+
+	result := make(chan SqlResult, 1)
+	ds.enqueue(SqlTask{
+		Do: func(writer *sqlx.DB) error {
+			if err := restore_database(writer, tmpPath); err != nil {
+				return err
+			}
+			// 恢复的数据库可能开启或关闭了变更记录、审计
+			return ds.detect_triggers(writer)
+		},
+		Result: result,
+	})
+	if ret := <-result; ret.Err != nil {
+		return ret.Err
+	}
+	log.Printf("sqlite data source[%s] restored from %s\n", ds.id, srcPath)
+	return nil
+}
+
+// barrier 等待写协程处理完之前提交的任务
+func (ds *SqliteDataSource) barrier(ctx context.Context) error {
+	result := make(chan SqlResult, 1)
+	select {
+	case ds.doTasks <- SqlTask{Result: result, enqueued: time.Now()}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-result:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// restore_database 在写协程中执行, 通过 SQLite 在线备份接口把 srcPath 的内容整体写入当前数据库;
+// 写入在一个事务内完成, 失败时数据库保持不变, 读写连接池都不会关闭
+func restore_database(writer *sqlx.DB, srcPath string) error {
+	conn, err := writer.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn interface{}) error {
+		if c, ok := driverConn.(*sqliteConn); ok {
+			driverConn = c.Conn
+		}
+		restorer, ok := driverConn.(interface {
+			NewRestore(srcUri string) (*sqlite.Backup, error)
+		})
+		if !ok {
+			return fmt.Errorf("sqlite driver does not support restore")
+		}
+		bck, err := restorer.NewRestore(srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to open backup file: %w", err)
+		}
+		for more := true; more; {
+			if more, err = bck.Step(-1); err != nil {
+				bck.Finish()
+				return fmt.Errorf("failed to restore database: %w", err)
+			}
+		}
+		return bck.Finish()
+	})
+}
+
+// vacuum_into 将 srcPath 的一致性快照写入 destPath, destPath 已存在时先删除
+func vacuum_into(ctx context.Context, config *SqliteConfig, srcPath string, destPath string) error {
+	os.Remove(destPath)
+	db, err := sqlx.Open("sqlite", config.snapshotDSN(srcPath))
+	if err != nil {
+		return fmt.Errorf("failed to open database[%s]: %w", srcPath, err)
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", destPath); err != nil {
+		os.Remove(destPath)
+		return fmt.Errorf("failed to snapshot database[%s]: %w", srcPath, err)
+	}
+	return nil
+}
+
+// check_integrity 检查数据库文件是否完整
+func check_integrity(config *SqliteConfig, path string) error {
+	db, err := sqlx.Open("sqlite", config.snapshotDSN(path))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var result string
+	if err := db.QueryRow("PRAGMA quick_check").Scan(&result); err != nil {
+		return fmt.Errorf("failed to check database[%s]: %w", path, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("database[%s] is corrupted: %s", path, result)
+	}
+	return nil
+}
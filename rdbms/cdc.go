@@ -0,0 +1,345 @@
+package rdbms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 变更记录表, 由触发器在写入的同一事务中记录, 写入提交后通知订阅者
+const changeTableName = "_lts_change"
+
+// 变更类型
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// ChangeEvent 表数据变更
+type ChangeEvent struct {
+	ID      int64         `json:"id"`      // 变更序号, 作为游标使用
+	Table   string        `json:"table"`   // 表名
+	Op      string        `json:"op"`      // 变更类型, 逻辑删除记录为 update
+	Key     []interface{} `json:"key"`     // 主键值, 按主键字段顺序
+	Columns []string      `json:"columns"` // 变更的字段, insert 为所有字段, delete 为空
+	Time    time.Time     `json:"time"`    // 变更时间, 毫秒精度
+}
+
+var changeTableQueries = []string{
+	`CREATE TABLE IF NOT EXISTS ` + changeTableName + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		table_name TEXT NOT NULL,
+		op TEXT NOT NULL,
+		pk TEXT NOT NULL,
+		columns TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	)`,
+}
+
+// generateChangeTriggerQueries 生成记录变更的触发器, update 只记录值发生变化的字段
+func generateChangeTriggerQueries(ts *TableSpec) []string {
+	keys := ts.primaryKeys
+	if len(keys) == 0 {
+		keys = []string{"rowid"}
+	}
+	row_key := func(prefix string) string {
+		values := make([]string, 0, len(keys))
+		for _, key := range keys {
+			values = append(values, prefix+"."+key)
+		}
+		return "json_array(" + strings.Join(values, ", ") + ")"
+	}
+	names := make([]string, 0, len(ts.dbTags))
+	changed := make([]string, 0, len(ts.dbTags))
+	conditions := make([]string, 0, len(ts.dbTags))
+	for _, column := range ts.dbTags {
+		names = append(names, "'"+column+"'")
+		changed = append(changed, fmt.Sprintf("CASE WHEN old.%s IS NOT new.%s THEN '%s' END", column, column, column))
+		conditions = append(conditions, fmt.Sprintf("old.%s IS NOT new.%s", column, column))
+	}
+	now := "CAST(unixepoch('subsec') * 1000 AS INTEGER)"
+	record := func(op string, key string, columns string) string {
+		return fmt.Sprintf("INSERT INTO %s(table_name, op, pk, columns, created_at) VALUES ('%s', '%s', %s, %s, %s);",
+			changeTableName, ts.tableName, op, key, columns, now)
+	}
+	changedColumns := fmt.Sprintf("(SELECT json_group_array(value) FROM json_each(json_array(%s)) WHERE type != 'null')", strings.Join(changed, ", "))
+
+	trigger := change_trigger_name(ts)
+	return []string{
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s_ai", trigger),
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s_au", trigger),
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s_ad", trigger),
+		fmt.Sprintf("CREATE TRIGGER %s_ai AFTER INSERT ON %s BEGIN %s END", trigger, ts.tableName,
+			record(ChangeInsert, row_key("new"), "json_array("+strings.Join(names, ", ")+")")),
+		fmt.Sprintf("CREATE TRIGGER %s_au AFTER UPDATE ON %s WHEN %s BEGIN %s END", trigger, ts.tableName,
+			strings.Join(conditions, " OR "), record(ChangeUpdate, row_key("new"), changedColumns)),
+		fmt.Sprintf("CREATE TRIGGER %s_ad AFTER DELETE ON %s BEGIN %s END", trigger, ts.tableName,
+			record(ChangeDelete, row_key("old"), "json_array()")),
+	}
+}
+
+func change_trigger_name(ts *TableSpec) string {
+	return changeTableName + "_" + ts.tableName
+}
+
+// has_change_triggers 数据库中是否有变更记录触发器, 例如上次启动或其他进程开启的变更记录
+func has_change_triggers(writer *sqlx.DB) (bool, error) {
+	var count int
+	err := writer.Get(&count, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE '\\_lts\\_change\\_%' ESCAPE '\\'")
+	return count > 0, err
+}
+
+// detect_triggers 按数据库中已有的触发器设置变更记录和审计状态, 打开数据库和恢复备份后调用
+func (ds *SqliteDataSource) detect_triggers(writer *sqlx.DB) error {
+	capturing, err := has_change_triggers(writer)
+	if err != nil {
+		return err
+	}
+	auditing, err := has_audit_triggers(writer)
+	if err != nil {
+		return err
+	}
+	ds.capturing.Store(capturing)
+	ds.auditing.Store(auditing)
+	return nil
+}
+
+// EnableChangeCapture 为模型对应的表开启变更记录, 可以重复调用, 表结构变化后重新调用即可更新触发器
+func (ds *SqliteDataSource) EnableChangeCapture(models ...ITable) error {
+	specs := make([]*TableSpec, 0, len(models))
+	for _, model := range models {
+		ts, err := ds.getModelSpec(model)
+		if err != nil {
+			return err
+		}
+		if err := check_main_table(ts.tableName, "change capture"); err != nil {
+			return err
+		}
+		specs = append(specs, ts)
+	}
+	task := SqlTask{
+		Do: func(writer *sqlx.DB) error {
+			tx, err := writer.Beginx()
+			if err != nil {
+				return err
+			}
+			statements := append([]string{}, changeTableQueries...)
+			for _, ts := range specs {
+				statements = append(statements, generateChangeTriggerQueries(ts)...)
+			}
+			for _, statement := range statements {
+				if _, err := tx.Exec(statement); err != nil {
+					tx.Rollback()
+					return fmt.Errorf("enable change capture failed: %w", err)
+				}
+			}
+			return tx.Commit()
+		},
+		Result: make(chan SqlResult, 1),
+	}
+	defer task.Close()
+	ds.enqueue(task)
+	if result := <-task.Result; result.Err != nil {
+		return result.Err
+	}
+	ds.capturing.Store(true)
+	return nil
+}
+
+// DisableChangeCapture 删除模型对应表的变更触发器, 已记录的变更保留
+func (ds *SqliteDataSource) DisableChangeCapture(models ...ITable) error {
+	statements := []string{}
+	for _, model := range models {
+		ts, err := ds.getModelSpec(model)
+		if err != nil {
+			return err
+		}
+		trigger := change_trigger_name(ts)
+		statements = append(statements,
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s_ai", trigger),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s_au", trigger),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s_ad", trigger),
+		)
+	}
+	result := make(chan SqlResult, 1)
+	ds.enqueue(SqlTask{
+		Do: func(writer *sqlx.DB) error {
+			for _, statement := range statements {
+				if _, err := writer.Exec(statement); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Result: result,
+	})
+	return (<-result).Err
+}
+
+// Changes 读取游标之后的变更, cursor 为已处理的最后一个变更的 ID, 从头读取时为 0
+func (ds *SqliteDataSource) Changes(cursor int64, limit int) ([]ChangeEvent, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	type changeRow struct {
+		ID        int64  `db:"id"`
+		Table     string `db:"table_name"`
+		Op        string `db:"op"`
+		Key       string `db:"pk"`
+		Columns   string `db:"columns"`
+		CreatedAt int64  `db:"created_at"`
+	}
+	rows := []changeRow{}
+	// 变更通知在主库提交后发出, 从主库读取才能读到刚提交的变更
+	err := ds.read_primary(func(conn *sqlx.DB) error {
+		// 未开启过变更记录时没有变更表
+		var count int
+		if err := conn.Get(&count, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", changeTableName); err != nil || count == 0 {
+			return err
+		}
+		return conn.Select(&rows, "SELECT id, table_name, op, pk, columns, created_at FROM "+changeTableName+" WHERE id > ? ORDER BY id LIMIT ?", cursor, limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	events := make([]ChangeEvent, 0, len(rows))
+	for _, row := range rows {
+		event := ChangeEvent{ID: row.ID, Table: row.Table, Op: row.Op, Time: time.UnixMilli(row.CreatedAt)}
+		key, err := decode_change_key(row.Key)
+		if err != nil {
+			return nil, fmt.Errorf("decode change %d key failed: %w", row.ID, err)
+		}
+		event.Key = key
+		if err := json.Unmarshal([]byte(row.Columns), &event.Columns); err != nil {
+			return nil, fmt.Errorf("decode change %d columns failed: %w", row.ID, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Subscribe 从游标之后开始推送变更, 写入提交后立即推送; ctx 结束或数据源关闭时关闭通道;
+// 订阅者记录最后处理的 ID, 重启后从该 ID 继续订阅; 读取失败时通知 WithSubscribeError 指定的回调并稍后重试
+func (ds *SqliteDataSource) Subscribe(ctx context.Context, cursor int64, opts ...SubscribeOption) <-chan ChangeEvent {
+	options := &SubscribeOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.OnError == nil {
+		options.OnError = func(err error) {
+			log.Printf("read changes of sqlite data source[%s] failed: %v\n", ds.id, err)
+		}
+	}
+	events := make(chan ChangeEvent, 100)
+	go func() {
+		defer close(events)
+		for {
+			// 先取通知通道再读取, 避免读取后、等待前的写入被遗漏
+			notify := ds.change_notify()
+			changes, err := ds.Changes(cursor, 100)
+			if err != nil {
+				options.OnError(err)
+			}
+			for _, change := range changes {
+				select {
+				case events <- change:
+					cursor = change.ID
+				case <-ctx.Done():
+					return
+				}
+			}
+			if len(changes) == 100 {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ds.done:
+				return
+			case <-notify:
+			case <-time.After(time.Second):
+				// 兜底轮询, 例如其他进程写入
+			}
+		}
+	}()
+	return events
+}
+
+// PurgeChanges 删除 ID 不大于 cursor 的变更, 所有订阅者都处理完成后调用, 返回删除的数量
+func (ds *SqliteDataSource) PurgeChanges(cursor int64) (int64, error) {
+	var deleted int64
+	result := make(chan SqlResult, 1)
+	ds.enqueue(SqlTask{
+		Do: func(writer *sqlx.DB) error {
+			ret, err := writer.Exec("DELETE FROM "+changeTableName+" WHERE id <= ?", cursor)
+			if err != nil {
+				return err
+			}
+			deleted, err = ret.RowsAffected()
+			return err
+		},
+		Result: result,
+	})
+	if ret := <-result; ret.Err != nil {
+		return 0, ret.Err
+	}
+	return deleted, nil
+}
+
+// decode_change_key 解码主键值, 整数主键解码为 int64, 避免大整数丢失精度
+func decode_change_key(text string) ([]interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var key []interface{}
+	if err := decoder.Decode(&key); err != nil {
+		return nil, err
+	}
+	for i, value := range key {
+		key[i] = json_number_value(value)
+	}
+	return key, nil
+}
+
+// json_number_value 将 json.Number 转为 int64 或 float64
+func json_number_value(value interface{}) interface{} {
+	number, ok := value.(json.Number)
+	if !ok {
+		return value
+	}
+	if n, err := number.Int64(); err == nil {
+		return n
+	}
+	if f, err := number.Float64(); err == nil {
+		return f
+	}
+	return number.String()
+}
+
+// change_notify 返回在下一次写任务完成后关闭的通道
+func (ds *SqliteDataSource) change_notify() <-chan struct{} {
+	ds.changeLock.Lock()
+	defer ds.changeLock.Unlock()
+	if ds.changed == nil {
+		ds.changed = make(chan struct{})
+	}
+	return ds.changed
+}
+
+// notify_changes 写任务完成后唤醒订阅者
+func (ds *SqliteDataSource) notify_changes() {
+	if !ds.capturing.Load() {
+		return
+	}
+	ds.changeLock.Lock()
+	defer ds.changeLock.Unlock()
+	if ds.changed != nil {
+		close(ds.changed)
+		ds.changed = nil
+	}
+}
@@ -0,0 +1,230 @@
+package rdbms
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 连接器: 连接池通过连接器按当前的 DSN 建立连接; 设置变化后更新 DSN, 旧连接在归还或复用时丢弃,
+// 连接池本身不会关闭, 通过 Conn() 交给调用方的连接池一直有效;
+// 连接上执行的语句在驱动层通知查询观察者, 包括写任务、Read/Write 以及直接使用 Conn() 的查询
+
+// sqliteDriver 已注册的 sqlite 驱动, 连接钩子注册在该驱动上
+var sqliteDriver = func() driver.Driver {
+	db, err := sql.Open("sqlite", "")
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	return db.Driver()
+}()
+
+type sqliteConnector struct {
+	lock       sync.RWMutex
+	dsn        string
+	generation int64                        // DSN 的版本, 每次更新加一
+	observer   atomic.Pointer[connObserver] // 为空时不通知观察者
+}
+
+// connObserver 连接所属的数据源, 以及是否为读连接
+type connObserver struct {
+	ds     *SqliteDataSource
+	reader bool
+}
+
+// observeKey 上下文中带有该标记的语句不在驱动层通知观察者
+type observeKey struct{}
+
+// without_observe 标记不在驱动层通知的语句: 写协程的批量任务整体通知一次, 获取执行计划的语句不通知
+func without_observe(ctx context.Context) context.Context {
+	return context.WithValue(ctx, observeKey{}, true)
+}
+
+func newSqliteConnector(dsn string) *sqliteConnector {
+	return &sqliteConnector{dsn: dsn}
+}
+
+func (c *sqliteConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.lock.RLock()
+	dsn, generation := c.dsn, c.generation
+	c.lock.RUnlock()
+	conn, err := sqliteDriver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteConn{Conn: conn, connector: c, generation: generation}, nil
+}
+
+func (c *sqliteConnector) Driver() driver.Driver {
+	return sqliteDriver
+}
+
+// observe 设置连接所属的数据源, 之后连接上执行的语句通知数据源的观察者
+func (c *sqliteConnector) observe(ds *SqliteDataSource, reader bool) {
+	c.observer.Store(&connObserver{ds: ds, reader: reader})
+}
+
+// update 更新 DSN, 之后建立的连接使用新的设置, 旧连接不再复用
+func (c *sqliteConnector) update(dsn string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dsn = dsn
+	c.generation++
+}
+
+func (c *sqliteConnector) current(generation int64) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.generation == generation
+}
+
+// open_connector 通过连接器创建连接池, 并建立第一个连接检查 DSN
+func open_connector(connector *sqliteConnector) (*sqlx.DB, error) {
+	db := sqlx.NewDb(sql.OpenDB(connector), "sqlite")
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// sqliteConn 记录建立连接时的 DSN 版本, 版本过期的连接不再放回连接池
+type sqliteConn struct {
+	driver.Conn
+	connector  *sqliteConnector
+	generation int64
+}
+
+func (c *sqliteConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if conn, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return conn.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *sqliteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if conn, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = conn.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteStmt{Stmt: stmt, conn: c, query: query}, nil
+}
+
+func (c *sqliteConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	conn, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := conn.ExecContext(ctx, query, args)
+	c.observe(ctx, query, args, start, result, err)
+	return result, err
+}
+
+func (c *sqliteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	conn, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := conn.QueryContext(ctx, query, args)
+	c.observe(ctx, query, args, start, nil, err)
+	return rows, err
+}
+
+// observe 通知数据源的查询观察者, 查询语句的耗时不包含读取结果的时间
+func (c *sqliteConn) observe(ctx context.Context, query string, args []driver.NamedValue, start time.Time, result driver.Result, err error) {
+	observer := c.connector.observer.Load()
+	if observer == nil || !observer.ds.observers.enabled() || ctx.Value(observeKey{}) != nil {
+		return
+	}
+	event := &QueryEvent{
+		DataSource: observer.ds.id,
+		SQL:        query,
+		Reader:     observer.reader,
+		Start:      start,
+		Duration:   time.Since(start),
+		Err:        err,
+	}
+	if len(args) > 0 {
+		event.Args = make([]interface{}, len(args))
+		for i, arg := range args {
+			event.Args[i] = arg.Value
+		}
+	}
+	if result != nil && err == nil {
+		event.RowsAffected, _ = result.RowsAffected()
+	}
+	observer.ds.observers.notify(event)
+}
+
+func (c *sqliteConn) Ping(ctx context.Context) error {
+	if conn, ok := c.Conn.(driver.Pinger); ok {
+		return conn.Ping(ctx)
+	}
+	return nil
+}
+
+// ResetSession 复用空闲连接前调用, 版本过期时返回 ErrBadConn, 连接池会关闭它并重新获取连接
+func (c *sqliteConn) ResetSession(ctx context.Context) error {
+	if !c.connector.current(c.generation) {
+		return driver.ErrBadConn
+	}
+	if conn, ok := c.Conn.(driver.SessionResetter); ok {
+		return conn.ResetSession(ctx)
+	}
+	return nil
+}
+
+// IsValid 连接归还时调用, 版本过期时丢弃
+func (c *sqliteConn) IsValid() bool {
+	if !c.connector.current(c.generation) {
+		return false
+	}
+	if conn, ok := c.Conn.(driver.Validator); ok {
+		return conn.IsValid()
+	}
+	return true
+}
+
+// sqliteStmt 预编译语句, 执行时同样通知观察者
+type sqliteStmt struct {
+	driver.Stmt
+	conn  *sqliteConn
+	query string
+}
+
+func (s *sqliteStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	stmt, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return nil, fmt.Errorf("sqlite driver does not support StmtExecContext")
+	}
+	start := time.Now()
+	result, err := stmt.ExecContext(ctx, args)
+	s.conn.observe(ctx, s.query, args, start, result, err)
+	return result, err
+}
+
+func (s *sqliteStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	stmt, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return nil, fmt.Errorf("sqlite driver does not support StmtQueryContext")
+	}
+	start := time.Now()
+	rows, err := stmt.QueryContext(ctx, args)
+	s.conn.observe(ctx, s.query, args, start, nil, err)
+	return rows, err
+}
@@ -0,0 +1,327 @@
+package rdbms
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sssxyd/go-lts-core/basic"
+)
+
+// IConverter 字段值转换器, 写入时 Encode 为数据库可以接受的值, 读取时 Decode 回字段
+// 通过 db tag 选项声明, 例如 `db:"tags,json"`; 多个转换器按声明顺序编码, 逆序解码
+type IConverter interface {
+	Encode(value interface{}) (interface{}, error)
+	Decode(src interface{}, dest reflect.Value) error
+}
+
+// IColumnConverter 需要字段上下文的转换器, 读写表模型时代替 Encode/Decode 调用, 例如加密字段绑定表名、字段名和主键
+type IColumnConverter interface {
+	IConverter
+	EncodeColumnValue(column ColumnContext, value interface{}) (interface{}, error)
+	DecodeColumnValue(column ColumnContext, src interface{}, dest reflect.Value) error
+}
+
+// ColumnContext 字段值所在的表、字段和行
+type ColumnContext struct {
+	Table  string // 模型的表名, 分区表为主表名
+	Column string // 字段名
+	Key    Key    // 行的主键值, 按主键字段顺序
+}
+
+// IColumnCodec 自定义类型实现该接口后, 读写时自动转换, 无需声明 tag 选项
+type IColumnCodec interface {
+	EncodeColumn() (interface{}, error)
+	DecodeColumn(src interface{}) error
+}
+
+const (
+	converter_json  = "json"
+	converter_time  = "time"
+	converter_codec = "codec"
+)
+
+// 表结构使用的 tag 选项, 其余选项均视为转换器名称; 其他功能的选项通过 register_tag_option 注册
+var spec_tag_options = map[string]bool{
+	"pk":         true,
+	"softdelete": true,
+	"autoupdate": true,
+}
+
+// register_tag_option 注册功能使用的 tag 选项, 只在 init 中调用
+func register_tag_option(option string) {
+	spec_tag_options[option] = true
+}
+
+// check_tag_options 检查 tag 选项是表结构选项或已注册的转换器
+func check_tag_options(t reflect.Type, dbTag string, options map[string]bool) error {
+	for option := range options {
+		if !spec_tag_options[option] && GetConverter(option) == nil {
+			return fmt.Errorf("unknown option %s of db tag %s in %s", option, dbTag, t.Name())
+		}
+	}
+	return nil
+}
+
+var (
+	converters = sync.Map{} // key: converter name, value: IConverter
+	timeLayout = "2006-01-02 15:04:05.999999999-07:00"
+	timeMutex  = sync.RWMutex{}
+	codecType  = reflect.TypeOf((*IColumnCodec)(nil)).Elem()
+	timeType   = reflect.TypeOf(time.Time{})
+)
+
+func init() {
+	RegisterConverter(converter_json, &jsonConverter{})
+	RegisterConverter(converter_time, &timeConverter{})
+	RegisterConverter(converter_codec, &codecConverter{})
+}
+
+// RegisterConverter 注册转换器, 同名的转换器会被覆盖
+func RegisterConverter(name string, converter IConverter) {
+	converters.Store(name, converter)
+}
+
+func GetConverter(name string) IConverter {
+	if c, ok := converters.Load(name); ok {
+		return c.(IConverter)
+	}
+	return nil
+}
+
+// SetTimeLayout 设置 time.Time 字段的存储格式, 支持 time.Parse 的格式以及 unix、unixmilli
+func SetTimeLayout(layout string) {
+	timeMutex.Lock()
+	defer timeMutex.Unlock()
+	timeLayout = layout
+}
+
+func get_time_layout() string {
+	timeMutex.RLock()
+	defer timeMutex.RUnlock()
+	return timeLayout
+}
+
+// field_converters 返回字段使用的转换器名称; 未声明时, time.Time 和实现了 IColumnCodec 的类型自动转换
+func field_converters(t reflect.Type, tag string) []string {
+	names := []string{}
+	for _, option := range strings.Split(tag, ",")[1:] {
+		option = strings.TrimSpace(option)
+		if option != "" && !spec_tag_options[option] {
+			names = append(names, option)
+		}
+	}
+	if len(names) > 0 {
+		return names
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(codecType) {
+		return []string{converter_codec}
+	}
+	if t == timeType {
+		return []string{converter_time}
+	}
+	return nil
+}
+
+// encode_field 依次使用转换器编码字段值, column 不为空时传给 IColumnConverter
+func encode_field(field reflect.Value, names []string, column *ColumnContext) (interface{}, error) {
+	if field.Kind() == reflect.Ptr && field.IsNil() {
+		return nil, nil
+	}
+	var value interface{} = field.Interface()
+	for _, name := range names {
+		converter := GetConverter(name)
+		if converter == nil {
+			return nil, fmt.Errorf("converter %s not registered", name)
+		}
+		// 自定义编码需要取址调用
+		if name == converter_codec && field.CanAddr() && field.Kind() != reflect.Ptr {
+			value = field.Addr().Interface()
+		}
+		var encoded interface{}
+		var err error
+		if c, ok := converter.(IColumnConverter); ok && column != nil {
+			encoded, err = c.EncodeColumnValue(*column, value)
+		} else {
+			encoded, err = converter.Encode(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("converter %s encode failed: %w", name, err)
+		}
+		value = encoded
+	}
+	return value, nil
+}
+
+// snapshot_field 变更跟踪快照使用的字段值; 列级转换器(例如加密)每次编码的结果不同, 只编码到它之前的转换器
+func snapshot_field(field reflect.Value, names []string) (interface{}, error) {
+	for i, name := range names {
+		if _, ok := GetConverter(name).(IColumnConverter); ok {
+			names = names[:i]
+			break
+		}
+	}
+	return encode_field(field, names, nil)
+}
+
+// decode_field 逆序使用转换器解码, 中间结果暂存为 interface{}, 最后一个转换器写入字段
+func decode_field(src interface{}, field reflect.Value, names []string, column *ColumnContext) error {
+	if src == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	value := src
+	for i := len(names) - 1; i >= 0; i-- {
+		converter := GetConverter(names[i])
+		if converter == nil {
+			return fmt.Errorf("converter %s not registered", names[i])
+		}
+		decode := converter.Decode
+		if c, ok := converter.(IColumnConverter); ok && column != nil {
+			decode = func(src interface{}, dest reflect.Value) error {
+				return c.DecodeColumnValue(*column, src, dest)
+			}
+		}
+		if i == 0 {
+			if err := decode(value, field); err != nil {
+				return fmt.Errorf("converter %s decode failed: %w", names[i], err)
+			}
+			return nil
+		}
+		temp := reflect.New(reflect.TypeOf((*interface{})(nil)).Elem()).Elem()
+		if err := decode(value, temp); err != nil {
+			return fmt.Errorf("converter %s decode failed: %w", names[i], err)
+		}
+		value = temp.Interface()
+	}
+	return nil
+}
+
+// indirect_field 指针字段分配内存后返回指向的值
+func indirect_field(field reflect.Value) reflect.Value {
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		return field.Elem()
+	}
+	return field
+}
+
+func src_to_string(src interface{}) (string, error) {
+	switch v := src.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	default:
+		return "", fmt.Errorf("unsupported source type %T", src)
+	}
+}
+
+type jsonConverter struct{}
+
+func (c *jsonConverter) Encode(value interface{}) (interface{}, error) {
+	return basic.StructToJson(value)
+}
+
+func (c *jsonConverter) Decode(src interface{}, dest reflect.Value) error {
+	text, err := src_to_string(src)
+	if err != nil {
+		return err
+	}
+	if dest.Kind() == reflect.Interface {
+		var value interface{}
+		if err := json.Unmarshal([]byte(text), &value); err != nil {
+			return err
+		}
+		dest.Set(reflect.ValueOf(value))
+		return nil
+	}
+	return json.Unmarshal([]byte(text), dest.Addr().Interface())
+}
+
+type timeConverter struct{}
+
+func (c *timeConverter) Encode(value interface{}) (interface{}, error) {
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case *time.Time:
+		t = *v
+	default:
+		return nil, fmt.Errorf("unsupported time type %T", value)
+	}
+	switch layout := get_time_layout(); layout {
+	case "unix":
+		return t.Unix(), nil
+	case "unixmilli":
+		return t.UnixMilli(), nil
+	default:
+		return t.Format(layout), nil
+	}
+}
+
+func (c *timeConverter) Decode(src interface{}, dest reflect.Value) error {
+	var t time.Time
+	switch v := src.(type) {
+	case time.Time:
+		t = v
+	case int64:
+		if get_time_layout() == "unixmilli" {
+			t = time.UnixMilli(v)
+		} else {
+			t = time.Unix(v, 0)
+		}
+	case string, []byte:
+		text, _ := src_to_string(v)
+		layout := get_time_layout()
+		if layout == "unix" || layout == "unixmilli" {
+			n, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				return err
+			}
+			return c.Decode(n, dest)
+		}
+		parsed, err := time.Parse(layout, text)
+		if err != nil {
+			return err
+		}
+		t = parsed
+	default:
+		return fmt.Errorf("unsupported time source %T", src)
+	}
+	if dest.Kind() == reflect.Interface {
+		dest.Set(reflect.ValueOf(t))
+		return nil
+	}
+	indirect_field(dest).Set(reflect.ValueOf(t))
+	return nil
+}
+
+type codecConverter struct{}
+
+func (c *codecConverter) Encode(value interface{}) (interface{}, error) {
+	codec, ok := value.(IColumnCodec)
+	if !ok {
+		return nil, fmt.Errorf("%T does not implement IColumnCodec", value)
+	}
+	return codec.EncodeColumn()
+}
+
+func (c *codecConverter) Decode(src interface{}, dest reflect.Value) error {
+	field := indirect_field(dest)
+	codec, ok := field.Addr().Interface().(IColumnCodec)
+	if !ok {
+		return fmt.Errorf("%s does not implement IColumnCodec", field.Type())
+	}
+	return codec.DecodeColumn(src)
+}
@@ -0,0 +1,355 @@
+package rdbms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sssxyd/go-lts-core/basic"
+)
+
+// 字段加密: 使用 SetEncryptionKey 注册密钥后, 声明 encrypted 选项的字段写入时加密, 读取时解密, 例如:
+//
+//	Token   string  `db:"token,encrypted"`
+//	Profile Profile `db:"profile,json,encrypted"`
+//
+// 字符串和 []byte 直接加密, 其他类型先编码为 JSON; 表名、字段名和主键作为附加认证数据,
+// 密文被篡改或挪到其他行、字段时读取返回 basic.ErrCiphertextTampered;
+// 自增主键为零时在写协程中先分配主键再加密; 加密字段只能按主键读取, 不能用于查询条件、索引和全文检索
+
+const converter_encrypted = "encrypted"
+
+var errEncryptionKeyNotSet = errors.New("encryption key not set, call SetEncryptionKey first")
+
+var errEncryptionNeedsColumn = errors.New("encrypted converter binds table, column and primary key, use it on table models only")
+
+func init() {
+	// 未设置密钥时 encrypted 选项也是有效的, 读写加密字段返回错误
+	RegisterConverter(converter_encrypted, &encryptedConverter{})
+}
+
+// SetEncryptionKey 注册 encrypted 转换器, previous 为轮换前使用过的密钥, 轮换后调用 IDataSource.ReEncryptColumns 重新加密
+func SetEncryptionKey(current basic.IKeyProvider, previous ...basic.IKeyProvider) error {
+	cipher, err := basic.NewCipher(current, previous...)
+	if err != nil {
+		return err
+	}
+	RegisterConverter(converter_encrypted, NewEncryptedConverter(cipher))
+	return nil
+}
+
+// NewEncryptedConverter 使用指定的加解密器创建转换器, 可以注册为其他名称, 用于不同字段使用不同的密钥
+func NewEncryptedConverter(cipher *basic.Cipher) IConverter {
+	return &encryptedConverter{cipher: cipher}
+}
+
+type encryptedConverter struct {
+	cipher *basic.Cipher
+}
+
+func (c *encryptedConverter) Encode(value interface{}) (interface{}, error) {
+	return nil, errEncryptionNeedsColumn
+}
+
+func (c *encryptedConverter) Decode(src interface{}, dest reflect.Value) error {
+	return errEncryptionNeedsColumn
+}
+
+// column_aad 附加认证数据: 表名、字段名和主键
+func column_aad(column ColumnContext) ([]byte, error) {
+	key, err := json.Marshal(column.Key)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(column.Table + "\x00" + column.Column + "\x00" + string(key)), nil
+}
+
+func (c *encryptedConverter) EncodeColumnValue(column ColumnContext, value interface{}) (interface{}, error) {
+	if c.cipher == nil {
+		return nil, errEncryptionKeyNotSet
+	}
+	if len(column.Key) == 1 && reflect.ValueOf(column.Key[0]).IsZero() {
+		return nil, fmt.Errorf("primary key of table[%s] is required to encrypt column %s", column.Table, column.Column)
+	}
+	aad, err := column_aad(column)
+	if err != nil {
+		return nil, err
+	}
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	var plaintext []byte
+	switch {
+	case v.Kind() == reflect.String:
+		plaintext = []byte(v.String())
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		plaintext = v.Bytes()
+	default:
+		encoded, err := json.Marshal(v.Interface())
+		if err != nil {
+			return nil, err
+		}
+		plaintext = encoded
+	}
+	return c.cipher.Encrypt(plaintext, aad)
+}
+
+func (c *encryptedConverter) DecodeColumnValue(column ColumnContext, src interface{}, dest reflect.Value) error {
+	if c.cipher == nil {
+		return errEncryptionKeyNotSet
+	}
+	text, err := src_to_string(src)
+	if err != nil {
+		return err
+	}
+	aad, err := column_aad(column)
+	if err != nil {
+		return err
+	}
+	plaintext, err := c.cipher.Decrypt(text, aad)
+	if err != nil {
+		return err
+	}
+	if dest.Kind() == reflect.Interface {
+		dest.Set(reflect.ValueOf(string(plaintext)))
+		return nil
+	}
+	field := indirect_field(dest)
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString(string(plaintext))
+		return nil
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8:
+		field.SetBytes(plaintext)
+		return nil
+	default:
+		return json.Unmarshal(plaintext, field.Addr().Interface())
+	}
+}
+
+// encrypted_columns 返回声明了 encrypted 选项的字段
+func encrypted_columns(ts *TableSpec) []string {
+	columns := []string{}
+	for _, dbTag := range ts.dbTags {
+		if contains_string(ts.dbTagConverters[dbTag], converter_encrypted) {
+			columns = append(columns, dbTag)
+		}
+	}
+	return columns
+}
+
+// ReEncryptColumns 使用当前密钥重新加密模型的 encrypted 字段, 包括旧密钥的密文和加密前写入的明文, 返回更新的行数;
+// 每个表在一个事务中完成, 中断后重新调用即可继续
+func (ds *SqliteDataSource) ReEncryptColumns(models ...ITable) (int64, error) {
+	converter, ok := GetConverter(converter_encrypted).(*encryptedConverter)
+	if !ok || converter.cipher == nil {
+		return 0, errEncryptionKeyNotSet
+	}
+	var count int64
+	for _, model := range models {
+		ts, err := ds.getModelSpec(model)
+		if err != nil {
+			return count, err
+		}
+		columns := encrypted_columns(ts)
+		if len(columns) == 0 {
+			continue
+		}
+		if len(ts.primaryKeys) == 0 {
+			return count, fmt.Errorf("table[%s] has no primary key", ts.tableName)
+		}
+		var updated int64
+		task := SqlTask{
+			Do: func(writer *sqlx.DB) error {
+				tx, err := writer.Beginx()
+				if err != nil {
+					return err
+				}
+				updated, err = re_encrypt_table(tx, ts, columns, converter.cipher)
+				if err != nil {
+					tx.Rollback()
+					return err
+				}
+				return tx.Commit()
+			},
+			Result: make(chan SqlResult, 1),
+		}
+		ds.enqueue(task)
+		result := <-task.Result
+		task.Close()
+		if result.Err != nil {
+			return count, result.Err
+		}
+		count += updated
+	}
+	return count, nil
+}
+
+// re_encrypt_table 读取需要轮换的字段值, 重新加密后按主键更新
+func re_encrypt_table(tx *sqlx.Tx, ts *TableSpec, columns []string, cipher *basic.Cipher) (int64, error) {
+	selects := append(append([]string{}, ts.primaryKeys...), columns...)
+	rows, err := tx.Queryx(fmt.Sprintf("SELECT %s FROM %s", strings.Join(selects, ", "), ts.tableName))
+	if err != nil {
+		return 0, err
+	}
+	type rotation struct {
+		key    []interface{}
+		values map[string]string
+	}
+	rotations := []rotation{}
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		r := rotation{key: values[:len(ts.primaryKeys)], values: map[string]string{}}
+		for i, column := range columns {
+			text, err := src_to_string(values[len(ts.primaryKeys)+i])
+			if err != nil || !cipher.NeedsRotation(text) {
+				continue
+			}
+			aad, err := column_aad(ColumnContext{Table: ts.modelTable, Column: column, Key: r.key})
+			if err != nil {
+				rows.Close()
+				return 0, err
+			}
+			if r.values[column], err = cipher.Rotate(text, aad); err != nil {
+				rows.Close()
+				return 0, fmt.Errorf("re-encrypt %s.%s of key %v failed: %w", ts.tableName, column, r.key, err)
+			}
+		}
+		if len(r.values) > 0 {
+			rotations = append(rotations, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	conditions := make([]string, 0, len(ts.primaryKeys))
+	for _, key := range ts.primaryKeys {
+		conditions = append(conditions, key+" = ?")
+	}
+	for _, r := range rotations {
+		sets := make([]string, 0, len(r.values))
+		args := make([]interface{}, 0, len(r.values)+len(r.key))
+		for _, column := range columns {
+			if value, ok := r.values[column]; ok {
+				sets = append(sets, column+" = ?")
+				args = append(args, value)
+			}
+		}
+		args = append(args, r.key...)
+		statement := fmt.Sprintf("UPDATE %s SET %s WHERE %s", ts.tableName, strings.Join(sets, ", "), strings.Join(conditions, " AND "))
+		if _, err := tx.Exec(statement, args...); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(rotations)), nil
+}
+
+// needs_assigned_id 加密字段以主键作为附加认证数据, 自增主键为零时需要先分配主键
+func needs_assigned_id(ts *TableSpec, model ITable) bool {
+	return ts.autoIncrement && has_encrypted_column(ts) && !ts.hasModelKey(model)
+}
+
+// has_encrypted_column 是否有字段使用加密转换器, 包括以其他名称注册的转换器
+func has_encrypted_column(ts *TableSpec) bool {
+	for _, names := range ts.dbTagConverters {
+		for _, name := range names {
+			if _, ok := GetConverter(name).(*encryptedConverter); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// idAssigner 记录插入任务中需要分配主键的模型, specs 与 task.models 顺序一致
+type idAssigner struct {
+	specs   []*TableSpec
+	pending []int
+}
+
+// assign_ids_in_writer 插入任务中有需要分配主键的模型时, 改为在写协程的事务中分配主键后再生成参数
+func assign_ids_in_writer(task *SqlTask, specs []*TableSpec) {
+	pending := []int{}
+	for i, model := range task.models {
+		if needs_assigned_id(specs[i], model) {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	if len(task.BatchArgs) == 0 {
+		task.BatchArgs = [][]interface{}{task.Args}
+		task.Args = nil
+	}
+	task.assigner = &idAssigner{specs: specs, pending: pending}
+}
+
+// assign 在事务中分配主键并生成插入参数
+func (a *idAssigner) assign(tx *sqlx.Tx, task *SqlTask) error {
+	next := make(map[string]int64)
+	for _, i := range a.pending {
+		ts, model := a.specs[i], task.models[i]
+		id, ok := next[ts.tableName]
+		if !ok {
+			var err error
+			if id, err = next_row_id(tx, ts.tableName); err != nil {
+				return err
+			}
+		}
+		ts.setModelId(model, id)
+		next[ts.tableName] = id + 1
+		args, err := ts.extractInsertUpdateValues(model, false)
+		if err != nil {
+			return err
+		}
+		task.BatchArgs[i] = args
+	}
+	return nil
+}
+
+// reset 插入失败时清除分配的主键
+func (a *idAssigner) reset(models []ITable) {
+	for _, i := range a.pending {
+		a.specs[i].setModelId(models[i], 0)
+	}
+}
+
+// next_row_id 返回表的下一个自增主键, 不小于 sqlite_sequence 中的序号, 分区和分片预设的主键区间因此保持不变
+func next_row_id(tx *sqlx.Tx, table string) (int64, error) {
+	schema, name := "", table
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		schema, name = table[:i+1], table[i+1:]
+	}
+	var next int64
+	if err := tx.Get(&next, "SELECT COALESCE(MAX(rowid), 0) + 1 FROM "+table); err != nil {
+		return 0, err
+	}
+	var sequences int
+	if err := tx.Get(&sequences, "SELECT COUNT(*) FROM "+schema+"sqlite_master WHERE type = 'table' AND name = 'sqlite_sequence'"); err != nil {
+		return 0, err
+	}
+	if sequences > 0 {
+		var seq int64
+		if err := tx.Get(&seq, "SELECT COALESCE(MAX(seq), 0) + 1 FROM "+schema+"sqlite_sequence WHERE name = ?", name); err != nil {
+			return 0, err
+		}
+		if seq > next {
+			next = seq
+		}
+	}
+	return next, nil
+}
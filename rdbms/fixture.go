@@ -0,0 +1,696 @@
+package rdbms
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/yaml.v3"
+)
+
+// 种子数据: YAML/JSON 文件按表名组织数据行, 行可以带标签, 其他行通过 $表名.标签 引用它的主键, 例如:
+//
+//	users:
+//	  alice: {name: Alice, email: alice@example.com}
+//	  bob: {name: Bob}
+//	orders:
+//	  - {user_id: $users.alice, amount: 100}
+//	  - {user_id: $users.bob, amount: 50, note: $users.bob.name}
+//
+// 字段名使用 db tag, 值按模型字段的 Go 类型解析, json 字段可以直接写对象, 加密字段写明文;
+// 列表形式的行以序号作为标签; $表名.标签.字段 引用其他字段的值, $$ 开头表示以 $ 开头的字符串;
+// 被引用的行先写入, 自增主键写入后回填, 引用可以跨文件, 循环引用返回错误;
+// 测试用例使用 WithFixtureReset 在加载前清空涉及的表, 启动时加载的种子数据使用 WithFixtureOnce 只写入一次
+
+// 已写入的种子数据, 用于 WithFixtureOnce
+const fixtureTableName = "_lts_fixture"
+
+const fixtureTableQuery = `CREATE TABLE IF NOT EXISTS ` + fixtureTableName + ` (
+	table_name TEXT NOT NULL,
+	label TEXT NOT NULL,
+	pk TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	PRIMARY KEY (table_name, label)
+)`
+
+// Fixtures 解析后的种子数据, 可以重复加载到多个数据源
+type Fixtures struct {
+	tables []*fixtureTable // 按文件中出现的顺序
+}
+
+type fixtureTable struct {
+	name string
+	rows []*fixtureRow
+}
+
+type fixtureRow struct {
+	table  string
+	label  string
+	source string                 // 所在文件, 用于错误信息
+	values map[string]interface{} // key: db tag
+}
+
+func (row *fixtureRow) ref() string {
+	return row.table + "." + row.label
+}
+
+// FixtureRows 加载后的模型, key: 表名.标签
+type FixtureRows map[string]ITable
+
+// 加载选项
+type FixtureOption func(options *FixtureOptions)
+
+type FixtureOptions struct {
+	Reset bool        // 加载前清空涉及的表, 并重置自增序号
+	Once  bool        // 每个标签只写入一次, 已写入的行不再写入, 用于启动时加载的种子数据
+	Dao   []DaoOption // 写入使用的 DAO 选项, 例如 WithActor
+}
+
+// WithFixtureReset 加载前清空涉及的表, 用于测试用例之间隔离数据
+func WithFixtureReset() FixtureOption {
+	return func(options *FixtureOptions) {
+		options.Reset = true
+	}
+}
+
+// WithFixtureOnce 已写入的标签记录在 _lts_fixture 表中, 再次加载时跳过;
+// 写入数据行和记录标签不在同一事务中, 写入中途失败时重新加载, 未记录标签的行会再次写入
+func WithFixtureOnce() FixtureOption {
+	return func(options *FixtureOptions) {
+		options.Once = true
+	}
+}
+
+// WithFixtureDao 写入使用的 DAO 选项
+func WithFixtureDao(opts ...DaoOption) FixtureOption {
+	return func(options *FixtureOptions) {
+		options.Dao = append(options.Dao, opts...)
+	}
+}
+
+// ReadFixtures 读取种子数据文件, 目录读取其中的 .yaml/.yml/.json 文件, 按文件名排序
+func ReadFixtures(paths ...string) (*Fixtures, error) {
+	fixtures := &Fixtures{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		files := []string{path}
+		if info.IsDir() {
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+			files = files[:0]
+			for _, entry := range entries {
+				switch strings.ToLower(filepath.Ext(entry.Name())) {
+				case ".yaml", ".yml", ".json":
+					if !entry.IsDir() {
+						files = append(files, filepath.Join(path, entry.Name()))
+					}
+				}
+			}
+			sort.Strings(files)
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if err := fixtures.parse(file, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return fixtures, nil
+}
+
+// ParseFixtures 解析 YAML 或 JSON 格式的种子数据, source 用于错误信息
+func ParseFixtures(source string, data []byte) (*Fixtures, error) {
+	fixtures := &Fixtures{}
+	if err := fixtures.parse(source, data); err != nil {
+		return nil, err
+	}
+	return fixtures, nil
+}
+
+// parse JSON 是 YAML 的子集, 统一按 YAML 解析, 保留表和行的顺序
+func (f *Fixtures) parse(source string, data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("fixture %s: %w", source, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("fixture %s: rows must be keyed by table name", source)
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		name, node := root.Content[i].Value, root.Content[i+1]
+		table := f.table(name)
+		add := func(label string, rowNode *yaml.Node) error {
+			row := &fixtureRow{table: name, label: label, source: source}
+			if err := rowNode.Decode(&row.values); err != nil {
+				return fmt.Errorf("fixture %s: row %s: %w", source, row.ref(), err)
+			}
+			if strings.Contains(label, ".") || label == "" {
+				return fmt.Errorf("fixture %s: invalid label %q of table %s", source, label, name)
+			}
+			for _, exists := range table.rows {
+				if exists.label == label {
+					return fmt.Errorf("fixture %s: duplicate row %s, also in %s", source, row.ref(), exists.source)
+				}
+			}
+			table.rows = append(table.rows, row)
+			return nil
+		}
+		switch node.Kind {
+		case yaml.MappingNode:
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if err := add(node.Content[j].Value, node.Content[j+1]); err != nil {
+					return err
+				}
+			}
+		case yaml.SequenceNode:
+			// 列表形式的行以表内序号作为标签, 多个文件中的同一个表接续编号
+			offset := len(table.rows)
+			for j, rowNode := range node.Content {
+				if err := add(strconv.Itoa(offset+j), rowNode); err != nil {
+					return err
+				}
+			}
+		default:
+			if node.Tag != "!!null" {
+				return fmt.Errorf("fixture %s: rows of table %s must be a mapping or a list", source, name)
+			}
+		}
+	}
+	return nil
+}
+
+func (f *Fixtures) table(name string) *fixtureTable {
+	for _, table := range f.tables {
+		if table.name == name {
+			return table
+		}
+	}
+	table := &fixtureTable{name: name}
+	f.tables = append(f.tables, table)
+	return table
+}
+
+// LoadFixtures 读取并加载种子数据文件
+func LoadFixtures(ds IDataSource, paths []string, opts ...FixtureOption) (FixtureRows, error) {
+	fixtures, err := ReadFixtures(paths...)
+	if err != nil {
+		return nil, err
+	}
+	return fixtures.Load(ds, opts...)
+}
+
+// fixtureLoader 一次加载过程的状态
+type fixtureLoader struct {
+	ds       IDataSource
+	dao      IDao
+	options  FixtureOptions
+	fixtures *Fixtures
+	specs    map[string]*TableSpec // key: 表名
+	loaded   FixtureRows           // 已写入或已存在的行
+	recorded map[string]string     // WithFixtureOnce 已记录的标签, key: 表名.标签, value: 主键 JSON
+}
+
+// Load 按引用顺序写入所有行, 返回写入的模型; WithFixtureOnce 跳过的行从数据库读取
+func (f *Fixtures) Load(ds IDataSource, opts ...FixtureOption) (FixtureRows, error) {
+	loader := &fixtureLoader{
+		ds:       ds,
+		fixtures: f,
+		specs:    make(map[string]*TableSpec),
+		loaded:   make(FixtureRows),
+		recorded: make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(&loader.options)
+	}
+	loader.dao = ds.NewDao(loader.options.Dao...)
+	for _, table := range f.tables {
+		ts := ds.GetTableSpec(table.name)
+		if ts == nil {
+			return nil, fmt.Errorf("fixture table[%s] is not registered", table.name)
+		}
+		loader.specs[table.name] = ts
+	}
+	if loader.options.Once || loader.options.Reset {
+		if err := loader.dao.Create(fixtureTableQuery); err != nil {
+			return nil, err
+		}
+	}
+	if loader.options.Reset {
+		if err := loader.reset(); err != nil {
+			return nil, err
+		}
+	}
+	if loader.options.Once {
+		if err := loader.load_recorded(); err != nil {
+			return nil, err
+		}
+	}
+	if err := loader.insert(); err != nil {
+		return nil, err
+	}
+	return loader.loaded, nil
+}
+
+// table_order 按引用关系排序, 被引用的表在前, 循环引用时保持文件中的顺序
+func (loader *fixtureLoader) table_order() []*fixtureTable {
+	deps := make(map[string]map[string]bool)
+	var collect func(table string, value interface{})
+	collect = func(table string, value interface{}) {
+		switch v := value.(type) {
+		case string:
+			if strings.HasPrefix(v, "$") && !strings.HasPrefix(v, "$$") {
+				if target, _, err := loader.parse_ref(v[1:]); err == nil && target.table != table {
+					deps[table][target.table] = true
+				}
+			}
+		case map[string]interface{}:
+			for _, item := range v {
+				collect(table, item)
+			}
+		case []interface{}:
+			for _, item := range v {
+				collect(table, item)
+			}
+		}
+	}
+	for _, table := range loader.fixtures.tables {
+		deps[table.name] = make(map[string]bool)
+		for _, row := range table.rows {
+			collect(table.name, row.values)
+		}
+	}
+	order := make([]*fixtureTable, 0, len(loader.fixtures.tables))
+	visited := make(map[string]bool)
+	var visit func(table *fixtureTable)
+	visit = func(table *fixtureTable) {
+		if visited[table.name] {
+			return
+		}
+		visited[table.name] = true
+		for _, dep := range loader.fixtures.tables {
+			if deps[table.name][dep.name] {
+				visit(dep)
+			}
+		}
+		order = append(order, table)
+	}
+	for _, table := range loader.fixtures.tables {
+		visit(table)
+	}
+	return order
+}
+
+// reset 按引用关系逆序清空涉及的表, 先删除引用其他表的行, 满足外键约束
+func (loader *fixtureLoader) reset() error {
+	return loader.dao.Write(func(conn sqlx.Ext) error {
+		var sequence int
+		if err := sqlx.Get(conn, &sequence, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'sqlite_sequence'"); err != nil {
+			return err
+		}
+		tables := loader.table_order()
+		for i := len(tables) - 1; i >= 0; i-- {
+			name := tables[i].name
+			if _, err := conn.Exec("DELETE FROM " + name); err != nil {
+				return fmt.Errorf("reset table[%s] failed: %w", name, err)
+			}
+			if _, err := conn.Exec("DELETE FROM "+fixtureTableName+" WHERE table_name = ?", name); err != nil {
+				return err
+			}
+			if schema, table := split_table_name(name); schema == "" && sequence > 0 {
+				if _, err := conn.Exec("DELETE FROM sqlite_sequence WHERE name = ?", table); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// load_recorded 读取已记录的标签, 并从数据库读取对应的行用于解析引用
+func (loader *fixtureLoader) load_recorded() error {
+	type record struct {
+		Table string `db:"table_name"`
+		Label string `db:"label"`
+		Key   string `db:"pk"`
+	}
+	records := []record{}
+	err := loader.dao.Read(func(conn sqlx.Queryer) error {
+		return sqlx.Select(conn, &records, "SELECT table_name, label, pk FROM "+fixtureTableName)
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		loader.recorded[r.Table+"."+r.Label] = r.Key
+	}
+	for _, table := range loader.fixtures.tables {
+		ts := loader.specs[table.name]
+		for _, row := range table.rows {
+			text, ok := loader.recorded[row.ref()]
+			if !ok {
+				continue
+			}
+			key, err := decode_change_key(text)
+			if err != nil {
+				return fmt.Errorf("fixture row %s: %w", row.ref(), err)
+			}
+			model := reflect.New(ts.modelType).Interface().(ITable) // 注册的模型都实现了 ITable
+			var arg interface{} = Key(key)
+			if len(key) == 1 {
+				arg = key[0]
+			}
+			// 已删除的行不再写入, 引用它时返回错误
+			if err := loader.dao.TableGet(model, arg); err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("fixture row %s: %w", row.ref(), err)
+			} else if err == nil {
+				loader.loaded[row.ref()] = model
+			}
+		}
+	}
+	return nil
+}
+
+// insert 每轮写入引用都已就绪的行, 同一个表的行批量写入, 直到全部写入或者无法继续
+func (loader *fixtureLoader) insert() error {
+	pending := []*fixtureRow{}
+	for _, table := range loader.fixtures.tables {
+		for _, row := range table.rows {
+			if _, ok := loader.recorded[row.ref()]; !ok {
+				pending = append(pending, row)
+			}
+		}
+	}
+	for len(pending) > 0 {
+		ready := make(map[string][]*fixtureRow)
+		waiting := []*fixtureRow{}
+		var blocked error
+		for _, row := range pending {
+			values, err := loader.resolve(row, row.values)
+			if err != nil {
+				return err
+			}
+			if values == fixturePendingRef {
+				waiting = append(waiting, row)
+				blocked = fmt.Errorf("fixture row %s: %w", row.ref(), loader.unresolved(row, row.values))
+				continue
+			}
+			ready[row.table] = append(ready[row.table], row)
+		}
+		if len(waiting) == len(pending) {
+			return blocked
+		}
+		for _, table := range loader.fixtures.tables {
+			if err := loader.insert_rows(loader.specs[table.name], ready[table.name]); err != nil {
+				return err
+			}
+		}
+		pending = waiting
+	}
+	return nil
+}
+
+// insert_rows 写入同一个表的行, WithFixtureOnce 时记录标签
+func (loader *fixtureLoader) insert_rows(ts *TableSpec, rows []*fixtureRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	models := make([]ITable, 0, len(rows))
+	for _, row := range rows {
+		values, err := loader.resolve(row, row.values)
+		if err != nil {
+			return err
+		}
+		model := reflect.New(ts.modelType)
+		for column, value := range values.(map[string]interface{}) {
+			field, ok := ts.fieldValue(model.Elem(), column, true)
+			if !ok {
+				return fmt.Errorf("fixture row %s: unknown column %s", row.ref(), column)
+			}
+			if err := assign_fixture_value(field, value); err != nil {
+				return fmt.Errorf("fixture row %s: column %s: %w", row.ref(), column, err)
+			}
+		}
+		models = append(models, model.Interface().(ITable))
+	}
+	if _, err := loader.dao.TableInsert(models...); err != nil {
+		return fmt.Errorf("insert fixture table[%s] failed: %w", ts.tableName, err)
+	}
+	for i, row := range rows {
+		loader.loaded[row.ref()] = models[i]
+	}
+	if !loader.options.Once {
+		return nil
+	}
+	now := time.Now().UnixMilli()
+	return loader.dao.Write(func(conn sqlx.Ext) error {
+		for i, row := range rows {
+			key, err := json.Marshal(ts.getModelKey(models[i]))
+			if err != nil {
+				return err
+			}
+			if _, err := conn.Exec("INSERT OR REPLACE INTO "+fixtureTableName+" (table_name, label, pk, created_at) VALUES (?, ?, ?, ?)",
+				row.table, row.label, string(key), now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// fixturePending 被引用的行尚未写入, 与引用字段为 NULL 区分
+type fixturePending struct{}
+
+var fixturePendingRef interface{} = fixturePending{}
+
+// resolve 替换值中的引用, 被引用的行尚未写入时返回 fixturePendingRef
+func (loader *fixtureLoader) resolve(row *fixtureRow, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if strings.HasPrefix(v, "$$") {
+			return v[1:], nil
+		}
+		if !strings.HasPrefix(v, "$") {
+			return v, nil
+		}
+		target, column, err := loader.parse_ref(v[1:])
+		if err != nil {
+			return nil, fmt.Errorf("fixture row %s: %w", row.ref(), err)
+		}
+		model, ok := loader.loaded[target.ref()]
+		if !ok {
+			if _, recorded := loader.recorded[target.ref()]; recorded {
+				return nil, fmt.Errorf("fixture row %s: referenced row %s has been deleted", row.ref(), target.ref())
+			}
+			return fixturePendingRef, nil
+		}
+		return loader.ref_value(target, model, column)
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			value, err := loader.resolve(row, item)
+			if err != nil {
+				return nil, err
+			}
+			if value == fixturePendingRef {
+				return value, nil
+			}
+			resolved[key] = value
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			value, err := loader.resolve(row, item)
+			if err != nil {
+				return nil, err
+			}
+			if value == fixturePendingRef {
+				return value, nil
+			}
+			resolved = append(resolved, value)
+		}
+		return resolved, nil
+	default:
+		return value, nil
+	}
+}
+
+// unresolved 返回第一个尚未写入的引用, 用于无法继续写入时的错误信息
+func (loader *fixtureLoader) unresolved(row *fixtureRow, value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		if strings.HasPrefix(v, "$") && !strings.HasPrefix(v, "$$") {
+			if target, _, err := loader.parse_ref(v[1:]); err == nil {
+				if _, ok := loader.loaded[target.ref()]; !ok {
+					return fmt.Errorf("reference %s can not be resolved, check for circular references", v)
+				}
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			if err := loader.unresolved(row, item); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := loader.unresolved(row, item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parse_ref 解析 表名.标签[.字段], 表名可以包含 schema, 匹配最长的表名
+func (loader *fixtureLoader) parse_ref(ref string) (*fixtureRow, string, error) {
+	var table *fixtureTable
+	for _, t := range loader.fixtures.tables {
+		if strings.HasPrefix(ref, t.name+".") && (table == nil || len(t.name) > len(table.name)) {
+			table = t
+		}
+	}
+	if table == nil {
+		return nil, "", fmt.Errorf("unknown reference $%s", ref)
+	}
+	label, column, _ := strings.Cut(ref[len(table.name)+1:], ".")
+	for _, row := range table.rows {
+		if row.label == label {
+			return row, column, nil
+		}
+	}
+	return nil, "", fmt.Errorf("unknown reference $%s", ref)
+}
+
+// ref_value 引用的值, 未指定字段时为主键
+func (loader *fixtureLoader) ref_value(target *fixtureRow, model ITable, column string) (interface{}, error) {
+	ts := loader.specs[target.table]
+	if column == "" {
+		if len(ts.primaryKeys) != 1 {
+			return nil, fmt.Errorf("table[%s] has no single primary key, use $%s.<column>", ts.tableName, target.ref())
+		}
+		column = ts.primaryKeys[0]
+	}
+	v, err := model_value(model)
+	if err != nil {
+		return nil, err
+	}
+	field, ok := ts.fieldValue(v, column, false)
+	if !ok {
+		return nil, fmt.Errorf("unknown column $%s.%s", target.ref(), column)
+	}
+	for field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return nil, nil
+		}
+		field = field.Elem()
+	}
+	return field.Interface(), nil
+}
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// assign_fixture_value 按字段的 Go 类型赋值, 不经过转换器
+func assign_fixture_value(field reflect.Value, value interface{}) error {
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		return assign_fixture_value(field.Elem(), value)
+	}
+	if field.Type() == timeType {
+		t, err := fixture_time(value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if v := reflect.ValueOf(value); v.Type().AssignableTo(field.Type()) && v.Kind() != reflect.Map && v.Kind() != reflect.Slice {
+		field.Set(v)
+		return nil
+	}
+	// 标量值交给 Scanner 和 IColumnCodec, 对象和列表转换为 JSON 文本
+	src := fixture_driver_value(value)
+	if field.Addr().Type().Implements(scannerType) {
+		return field.Addr().Interface().(sql.Scanner).Scan(src)
+	}
+	if field.Addr().Type().Implements(codecType) {
+		return field.Addr().Interface().(IColumnCodec).DecodeColumn(src)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(field.Addr().Interface())
+}
+
+// fixture_driver_value 转换为数据库驱动的值类型
+func fixture_driver_value(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	switch {
+	case v.CanInt():
+		return v.Int()
+	case v.CanUint():
+		return int64(v.Uint())
+	case v.CanFloat():
+		return v.Float()
+	case v.Kind() == reflect.Map, v.Kind() == reflect.Slice:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return value
+		}
+		return string(data)
+	default:
+		return value
+	}
+}
+
+// fixture_time 支持 RFC3339、当前时间格式、日期时间、日期和 Unix 秒
+func fixture_time(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case int:
+		return time.Unix(int64(v), 0), nil
+	case int64:
+		return time.Unix(v, 0), nil
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, get_time_layout(), time.DateTime, time.DateOnly} {
+			if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid time %q", v)
+	default:
+		return time.Time{}, fmt.Errorf("unsupported time value %T", value)
+	}
+}
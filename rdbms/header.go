@@ -1,7 +1,24 @@
 package rdbms
 
-import "github.com/jmoiron/sqlx"
+import (
+	"context"
+	"time"
 
+	"github.com/jmoiron/sqlx"
+)
+
+// ITable 表模型, 可以嵌入 Table 后通过 db tag 声明表结构, 例如:
+//
+//	type Article struct {
+//		rdbms.Table
+//		ID        int64  `db:"id,pk"`
+//		DeletedAt int64  `db:"deleted_at,softdelete"`
+//		UpdatedAt int64  `db:"updated_at,autoupdate"`
+//		Title     string `db:"title,search"`  // 全文检索字段, 见 IDao.Search
+//		UserID    int64  `db:"user_id,shard"` // 分片字段, 见 NewShardedDataSource
+//	}
+//
+// 方法返回空值时由 db tag 推导, 表名默认为类型名的蛇形命名; 模型自己实现的方法优先于 Table 的默认实现
 type ITable interface {
 	TableName() string
 	PrimaryInt64Key() string
@@ -9,6 +26,27 @@ type ITable interface {
 	AutoUpdateKeys() []string
 }
 
+// Table 嵌入到模型中提供 ITable 的默认实现, 表结构全部由 db tag 推导
+type Table struct{}
+
+func (Table) TableName() string        { return "" }
+func (Table) PrimaryInt64Key() string  { return "" }
+func (Table) DeleteInt64Key() string   { return "" }
+func (Table) AutoUpdateKeys() []string { return nil }
+
+// ISearchModel 声明全文检索的分词器, 例如 "trigram" 支持中文子串检索(检索词至少 3 个字符)
+type ISearchModel interface {
+	SearchTokenizer() string
+}
+
+// ICompositeKeyModel 声明复合主键, 优先于 PrimaryInt64Key
+type ICompositeKeyModel interface {
+	PrimaryKeys() []string
+}
+
+// Key 复合主键的值, 按主键字段顺序排列; 单个主键直接传值即可, 例如 int64、string
+type Key []interface{}
+
 type PageData struct {
 	CurrentPage int64         `json:"page"`
 	PageSize    int64         `json:"size"`
@@ -19,10 +57,17 @@ type PageData struct {
 
 // 通用任务结构
 type SqlTask struct {
-	SQL       string          // SQL 语句
-	Args      []interface{}   // SQL 参数
-	BatchArgs [][]interface{} // 批量参数
-	Result    chan SqlResult  // 返回结果通道
+	SQL       string                      // SQL 语句
+	Args      []interface{}               // SQL 参数
+	BatchArgs [][]interface{}             // 批量参数
+	Do        func(writer *sqlx.DB) error // 自定义写操作, SQL 为空时执行
+	Result    chan SqlResult              // 返回结果通道
+	spec      *TableSpec                  // 表结构, 表操作任务时有值
+	models    []ITable                    // 任务对应的模型, 与 BatchArgs 顺序一致
+	batchSQL  []string                    // 批量任务中每组参数各自的语句, 为空时都使用 SQL
+	enqueued  time.Time                   // 入队时间, 用于统计等待时间
+	actor     string                      // 审计记录的操作人
+	assigner  *idAssigner                 // 在写协程的事务中分配自增主键的插入任务, 见 assign_ids_in_writer
 }
 
 func (task *SqlTask) Close() {
@@ -45,10 +90,55 @@ type IDataSource interface {
 	Username() string
 	Password() string
 
-	ScanTable(models ...ITable)
+	ScanTable(models ...ITable) error
 	GetTableSpec(tableName string) *TableSpec
 
-	NewDao() IDao
+	// Settings 返回数据源当前生效的连接设置, 例如 pragma 和连接池参数
+	Settings() (map[string]string, error)
+
+	// Backup 在线备份数据库到 destPath, 备份期间不阻塞写入
+	Backup(ctx context.Context, destPath string) error
+	// Restore 使用备份文件的内容原子地覆盖当前数据库, 读写连接池保持不变
+	Restore(srcPath string) error
+
+	// MaintenanceStatus 返回各个定期维护任务的最近执行情况
+	MaintenanceStatus() []MaintenanceStatus
+	// RunMaintenance 立即执行一次维护任务, 例如 MaintenanceCheckpoint
+	RunMaintenance(task string) (MaintenanceStatus, error)
+
+	// AddQueryObserver 添加查询观察者, 接收语句、脱敏后的参数、耗时、影响行数和错误, 直接使用 Conn() 执行的语句同样会被观察
+	AddQueryObserver(observer IQueryObserver)
+	// SetArgRedactor 设置交给观察者的参数的脱敏方式
+	SetArgRedactor(redactor ArgRedactor)
+
+	// Collect 收集数据源的运行时指标, 可通过 MetricsHandler 以 Prometheus 文本格式输出
+	Collect() []MetricFamily
+
+	// EnableChangeCapture 为模型对应的表开启变更记录, 变更在写入的同一事务中写入变更记录表
+	EnableChangeCapture(models ...ITable) error
+	// DisableChangeCapture 关闭模型对应表的变更记录
+	DisableChangeCapture(models ...ITable) error
+	// Changes 读取游标之后的变更
+	Changes(cursor int64, limit int) ([]ChangeEvent, error)
+	// Subscribe 订阅游标之后的变更, 读取失败时通过 WithSubscribeError 通知
+	Subscribe(ctx context.Context, cursor int64, opts ...SubscribeOption) <-chan ChangeEvent
+	// PurgeChanges 删除已处理的变更
+	PurgeChanges(cursor int64) (int64, error)
+
+	// EnableAudit 为模型对应的表开启审计, 写入的前后镜像和操作人记录到 <table>_audit 表
+	EnableAudit(models ...ITable) error
+	// DisableAudit 关闭模型对应表的审计
+	DisableAudit(models ...ITable) error
+
+	// ReEncryptColumns 密钥轮换后使用当前密钥重新加密模型的 encrypted 字段
+	ReEncryptColumns(models ...ITable) (int64, error)
+
+	// EnablePartition 为模型开启时间分区, 行按时间字段写入 <table>_YYYYMM 分区表
+	EnablePartition(model ITable, config PartitionConfig) error
+	// Partitions 返回模型已创建的分区表名
+	Partitions(model ITable) ([]string, error)
+
+	NewDao(opts ...DaoOption) IDao
 	Close() error
 }
 
@@ -61,13 +151,93 @@ type IDao interface {
 
 	TableInsert(models ...ITable) ([]int64, error)
 	TableUpdate(models ...ITable) (int64, error)
-	TableDelete(tableName string, ids ...int64) (int64, error)
-	TableGet(emptyTableModel interface{}, id int64) error
-	TableSelect(emptyTableSlice interface{}, ids ...int64) error
+	TableUpdateColumns(model ITable, columns ...string) (int64, error)
+	TableDelete(tableName string, keys ...interface{}) (int64, error)
+	TableGet(emptyTableModel interface{}, key interface{}) error
+	TableSelect(emptyTableSlice interface{}, keys ...interface{}) error
+	TableFind(emptyTableSlice interface{}, criteria *Criteria) error
+	TablePreload(models interface{}, relations ...string) error
+	// Search 全文检索, emptyTableSlice 按相关度填充模型, 返回与之对应的相关度和摘要
+	Search(emptyTableSlice interface{}, query string, opts *SearchOptions) ([]SearchHit, error)
+	// AuditHistory 返回记录的审计历史, 需要先开启审计
+	AuditHistory(emptyTableModel interface{}, key interface{}) ([]AuditRecord, error)
+	// AuditAsOf 还原记录在指定时间的值
+	AuditAsOf(emptyTableModel interface{}, key interface{}, at time.Time) error
+
+	// Read 在读连接池中执行查询; 一致性选项要求读到已完成的写入时在写协程中执行,
+	// 此时 fn 内不能再通过 DAO 写入或调用 Write, 否则会等待自己而死锁, 只能使用传入的 conn
+	Read(fn func(conn sqlx.Queryer) error) error
+	// Write 在写协程中执行, 与其他写任务串行; fn 内只能使用传入的 conn, 通过 DAO 写入或再次调用 Write 会死锁
+	Write(fn func(conn sqlx.Ext) error) error
+	Exec(statement string, args ...interface{}) (SqlResult, error)
+	QueryMap(statement string, args ...interface{}) ([]map[string]interface{}, error)
+
+	// Consistent 返回指定读一致性的 DAO, 用于单次调用
+	Consistent(consistency Consistency) IDao
 
 	Conn() *sqlx.DB
 }
 
+// Dao 选项
+type DaoOption func(options *DaoOptions)
+
+type DaoOptions struct {
+	ChangeTracking bool        // 变更跟踪: TableGet/TableSelect 时记录快照, TableUpdate 只更新发生变化的字段
+	Consistency    Consistency // 读一致性
+	Actor          string      // 审计记录的操作人
+}
+
+func WithChangeTracking() DaoOption {
+	return func(options *DaoOptions) {
+		options.ChangeTracking = true
+	}
+}
+
+func WithConsistency(consistency Consistency) DaoOption {
+	return func(options *DaoOptions) {
+		options.Consistency = consistency
+	}
+}
+
+// WithActor 指定审计记录的操作人
+func WithActor(actor string) DaoOption {
+	return func(options *DaoOptions) {
+		options.Actor = actor
+	}
+}
+
+// WithContext 使用 ContextWithActor 记录在 context 中的操作人, context 中没有操作人时保留 WithActor 指定的值
+func WithContext(ctx context.Context) DaoOption {
+	return func(options *DaoOptions) {
+		if actor := ActorFromContext(ctx); actor != "" {
+			options.Actor = actor
+		}
+	}
+}
+
+// Subscribe 选项
+type SubscribeOption func(options *SubscribeOptions)
+
+type SubscribeOptions struct {
+	OnError func(err error) // 读取变更失败时回调, 订阅稍后重试; 未指定时记录日志
+}
+
+// WithSubscribeError 读取变更失败时回调 fn, 在订阅协程中调用
+func WithSubscribeError(fn func(err error)) SubscribeOption {
+	return func(options *SubscribeOptions) {
+		options.OnError = fn
+	}
+}
+
+// Consistency 读一致性, 写操作都在写协程中串行执行, 读操作默认走只读连接池
+type Consistency int
+
+const (
+	ConsistencyEventual Consistency = iota // 读连接池, 不保证读到刚完成的写入
+	ConsistencySession                     // 读自己的写: DAO 写入成功后, 后续读操作都在写连接上执行
+	ConsistencyStrong                      // 所有读操作都在写连接上执行, 可以读到调用前已完成的全部写入
+)
+
 type DBUrl struct {
 	Driver   string            // JDBC driver name (e.g., sqlite, mysql)
 	Host     string            // Hostname or file path (for sqlite)
@@ -2,62 +2,52 @@ package rdbms
 
 import (
 	"fmt"
-	"strings"
-)
-
-var (
-	mainDataSourceId = ""
-	dataSourceMap    = make(map[string]IDataSource)
 )
 
+// NewDataSource 在默认注册表中打开数据源, ID 已存在时替换旧数据源
 func NewDataSource(id string, db_url string, statements []string, tables []ITable) (IDataSource, error) {
-	if id == "" || db_url == "" {
-		return nil, fmt.Errorf("id or url is empty")
-	}
-	dbUrl, err := parse_db_url(db_url)
-	if err != nil {
-		return nil, err
-	}
-
-	if dataSourceMap[id] != nil {
-		ds := dataSourceMap[id]
-		ds.Close()
-		ds = nil
-		delete(dataSourceMap, id)
-	}
+	return defaultRegistry.NewDataSource(id, db_url, statements, tables)
+}
 
+// open_data_source 打开数据源, 执行建表语句并扫描表结构, 不注册到注册表
+func open_data_source(id string, dbUrl *DBUrl, statements []string, tables []ITable) (IDataSource, error) {
 	var ds IDataSource
 	switch dbUrl.Driver {
 	case "sqlite":
-		ds, err = newSqliteDataSource(id, dbUrl.Host, statements)
+		config, err := parse_sqlite_config(dbUrl.Params)
+		if err != nil {
+			return nil, err
+		}
+		ds, err = newSqliteDataSource(id, dbUrl.Host, config, statements)
 		if err != nil {
 			return nil, err
 		}
-		dataSourceMap[id] = ds
-		break
 	default:
 		return nil, fmt.Errorf("unsupported driver: %s", dbUrl.Driver)
 	}
 
 	if len(tables) > 0 {
-		ds.ScanTable(tables...)
-	}
-	if mainDataSourceId == "" && !strings.HasPrefix(id, "_") {
-		mainDataSourceId = id
+		if err := ds.ScanTable(tables...); err != nil {
+			ds.Close()
+			return nil, err
+		}
 	}
 	return ds, nil
 }
 
+// GetDataSource 从默认注册表获取数据源, id 为空时返回主数据源; 返回的数据源不受热替换保护, 见 Registry.Get
 func GetDataSource(id string) IDataSource {
-	if id == "" {
-		id = mainDataSourceId
-	}
-	return dataSourceMap[id]
+	return defaultRegistry.Get(id)
+}
+
+// NewDao 从默认注册表创建 DAO, 数据源被替换后自动使用新数据源
+func NewDao(id string, opts ...DaoOption) IDao {
+	return defaultRegistry.NewDao(id, opts...)
 }
 
+// Close 关闭多租户路由、分片数据源和默认注册表中的所有数据源
 func Close() {
-	for _, ds := range dataSourceMap {
-		ds.Close()
-	}
-	dataSourceMap = make(map[string]IDataSource)
+	close_tenant_routers()
+	close_sharded_sources()
+	defaultRegistry.Close()
 }
@@ -0,0 +1,250 @@
+package rdbms
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sssxyd/go-lts-core/basic"
+)
+
+// JSON 字段查询, 基于 SQLite JSON1, 路径可以省略 $ 前缀, 例如:
+//
+//	NewCriteria().JsonWhere("profile", "address.city", "=", "Shanghai").JsonContains("tags", "", "vip")
+//	QueryAll[string](dao, "SELECT "+JsonText("profile", "name")+" FROM user WHERE "+JsonExtract("profile", "age")+" > ?", 18)
+//
+// 路径以字面量写入语句, 与 CreateJsonIndex 创建的表达式索引一致时才能使用索引
+
+// json_path 规范化 JSON 路径并转为 SQL 字面量
+func json_path(path string) string {
+	path = strings.TrimSpace(path)
+	if path == "" || path == "$" {
+		path = "$"
+	} else if !strings.HasPrefix(path, "$") {
+		if strings.HasPrefix(path, "[") {
+			path = "$" + path
+		} else {
+			path = "$." + path
+		}
+	}
+	return "'" + strings.ReplaceAll(path, "'", "''") + "'"
+}
+
+// check_json_column 校验字段名, 防止拼接到语句中的字段名包含其他语句
+func check_json_column(column string) error {
+	if column == "" {
+		return fmt.Errorf("json column name is empty")
+	}
+	for i := 0; i < len(column); i++ {
+		if !is_ident_char(column[i]) && column[i] != '.' {
+			return fmt.Errorf("invalid json column name: %s", column)
+		}
+	}
+	return nil
+}
+
+func check_json_columns(columns ...string) error {
+	for _, column := range columns {
+		if err := check_json_column(column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// json_column 校验字段名, 用于导出的表达式函数, 字段名由调用方写死, 非法时 panic;
+// Criteria 的 Json 条件不会 panic, 错误在查询时返回
+func json_column(column string) string {
+	if err := check_json_column(column); err != nil {
+		panic(err.Error())
+	}
+	return column
+}
+
+// JsonExtract 返回 json_extract(column, path) 表达式, 结果为 SQL 类型, JSON 对象和数组为 JSON 文本
+func JsonExtract(column string, path string) string {
+	return fmt.Sprintf("json_extract(%s, %s)", json_column(column), json_path(path))
+}
+
+// JsonText 返回 column ->> path 表达式, 结果为 SQL 的文本、数字或 NULL
+func JsonText(column string, path string) string {
+	return fmt.Sprintf("%s ->> %s", json_column(column), json_path(path))
+}
+
+// JsonValue 返回 column -> path 表达式, 结果为 JSON 文本
+func JsonValue(column string, path string) string {
+	return fmt.Sprintf("%s -> %s", json_column(column), json_path(path))
+}
+
+// JsonWhere 追加 json_extract(column, path) op ? 条件, op 例如 =、!=、>、<、LIKE
+func (c *Criteria) JsonWhere(column string, path string, op string, value interface{}) *Criteria {
+	op = strings.ToUpper(strings.TrimSpace(op))
+	switch op {
+	case "=", "==", "!=", "<>", "<", ">", "<=", ">=", "LIKE", "GLOB", "IS", "IS NOT":
+	default:
+		return c.fail(fmt.Errorf("invalid json operator: %s", op))
+	}
+	if err := check_json_column(column); err != nil {
+		return c.fail(err)
+	}
+	return c.Where(fmt.Sprintf("%s %s ?", JsonExtract(column, path), op), value)
+}
+
+// JsonIn 追加 json_extract(column, path) IN (...) 条件
+func (c *Criteria) JsonIn(column string, path string, values ...interface{}) *Criteria {
+	if err := check_json_column(column); err != nil {
+		return c.fail(err)
+	}
+	if len(values) == 0 {
+		return c.Where("1 = 0")
+	}
+	return c.Where(fmt.Sprintf("%s IN %s", JsonExtract(column, path), SqlInValues(len(values))), values...)
+}
+
+// JsonContains 追加 JSON 数组包含 value 的条件, path 为空时表示字段本身是数组
+func (c *Criteria) JsonContains(column string, path string, value interface{}) *Criteria {
+	if err := check_json_column(column); err != nil {
+		return c.fail(err)
+	}
+	return c.Where(fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s, %s) WHERE json_each.value = ?)", column, json_path(path)), value)
+}
+
+// JsonHas 追加路径存在的条件
+func (c *Criteria) JsonHas(column string, path string) *Criteria {
+	if err := check_json_column(column); err != nil {
+		return c.fail(err)
+	}
+	return c.Where(fmt.Sprintf("json_type(%s, %s) IS NOT NULL", column, json_path(path)))
+}
+
+// JsonOrderBy 按 JSON 路径排序, desc 为 true 时降序
+func (c *Criteria) JsonOrderBy(column string, path string, desc bool) *Criteria {
+	if err := check_json_column(column); err != nil {
+		return c.fail(err)
+	}
+	orderBy := JsonExtract(column, path)
+	if desc {
+		orderBy += " DESC"
+	}
+	return c.OrderBy(orderBy)
+}
+
+// json_index_name 索引名, 例如 idx_user_profile_address_city
+func json_index_name(table string, column string, path string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < 128 && is_ident_char(byte(r)) {
+			return r
+		}
+		return '_'
+	}, strings.TrimPrefix(strings.TrimPrefix(path, "$"), "."))
+	return strings.Trim(fmt.Sprintf("idx_%s_%s_%s", table, column, name), "_")
+}
+
+// CreateJsonIndex 为热点 JSON 路径创建表达式索引, 查询时使用 JsonExtract 或 JsonWhere 生成相同的表达式即可命中
+func CreateJsonIndex(dao IDao, table string, column string, path string) error {
+	if err := check_json_columns(table, column); err != nil {
+		return err
+	}
+	statement := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s(%s)", json_index_name(table, column, path), json_column(table), JsonExtract(column, path))
+	_, err := dao.Exec(statement)
+	return err
+}
+
+// AddJsonColumn 添加 JSON 路径的虚拟生成列并创建索引, 已存在时忽略;
+// 生成列不能写入, 不要映射到表模型中, 可以通过 QueryAll 等查询读取
+func AddJsonColumn(dao IDao, table string, name string, column string, path string) error {
+	// 在写协程外校验, 避免在写任务中 panic
+	if err := check_json_columns(table, name, column); err != nil {
+		return err
+	}
+	return dao.Write(func(conn sqlx.Ext) error {
+		var count int
+		err := sqlx.Get(conn, &count, "SELECT COUNT(*) FROM pragma_table_xinfo(?) WHERE name = ?", table, name)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			statement := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s AS (%s) VIRTUAL", json_column(table), json_column(name), JsonExtract(column, path))
+			if _, err := conn.Exec(statement); err != nil {
+				return err
+			}
+		}
+		_, err = conn.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_%s ON %s(%s)", json_column(table), json_column(name), table, name))
+		return err
+	})
+}
+
+// Json 将 JSON 文本解码为 T, 用于 QueryAll 等查询结果的字段或 json_extract 的投影; 写入时编码为 JSON 文本
+//
+//	type Row struct {
+//		Tags rdbms.Json[[]string] `db:"tags"`
+//	}
+type Json[T any] struct {
+	Val   T
+	Valid bool // 数据库中的值不为 NULL
+}
+
+func NewJson[T any](value T) Json[T] {
+	return Json[T]{Val: value, Valid: true}
+}
+
+func (j *Json[T]) Scan(src interface{}) error {
+	var zero T
+	j.Val, j.Valid = zero, false
+	if src == nil {
+		return nil
+	}
+	var data []byte
+	switch v := src.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		// json_extract 返回的数字、布尔等标量
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		data = encoded
+	}
+	if err := json.Unmarshal(data, &j.Val); err != nil {
+		// ->> 返回的字符串不带引号
+		if text, ok := src.(string); ok {
+			if s, ok := any(&j.Val).(*string); ok {
+				*s = text
+				j.Valid = true
+				return nil
+			}
+		}
+		return fmt.Errorf("decode json failed: %w", err)
+	}
+	j.Valid = true
+	return nil
+}
+
+func (j Json[T]) Value() (driver.Value, error) {
+	if !j.Valid {
+		return nil, nil
+	}
+	return basic.StructToJson(j.Val)
+}
+
+func (j Json[T]) MarshalJSON() ([]byte, error) {
+	if !j.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(j.Val)
+}
+
+func (j *Json[T]) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		var zero T
+		j.Val, j.Valid = zero, false
+		return nil
+	}
+	j.Valid = true
+	return json.Unmarshal(data, &j.Val)
+}
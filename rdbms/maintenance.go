@@ -0,0 +1,257 @@
+package rdbms
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 定期维护任务, 间隔通过 DB URL 参数配置, 例如 checkpoint_interval=10m
+const (
+	MaintenanceCheckpoint     = "checkpoint"          // PRAGMA wal_checkpoint(TRUNCATE), 截断 WAL 文件
+	MaintenanceVacuum         = "vacuum"              // PRAGMA incremental_vacuum, auto_vacuum=INCREMENTAL 时回收空闲页
+	MaintenanceOptimize       = "optimize"            // PRAGMA optimize, 按需执行 ANALYZE
+	MaintenanceIntegrityCheck = "integrity_check"     // PRAGMA integrity_check, 检查数据库是否损坏, 在读连接上执行, 默认不定期执行
+	MaintenanceRetention      = "partition_retention" // 删除超过保留时长的分区, 见 EnablePartition
+)
+
+// MaintenanceStatus 维护任务的执行情况
+type MaintenanceStatus struct {
+	Task     string        `json:"task"`
+	Interval time.Duration `json:"interval"` // 0 表示未启用定期执行
+	Runs     int64         `json:"runs"`     // 执行次数
+	Failures int64         `json:"failures"` // 失败次数
+	LastRun  time.Time     `json:"last_run"`
+	Duration time.Duration `json:"duration"` // 最近一次执行耗时
+	Result   string        `json:"result"`   // 最近一次执行结果
+	Error    string        `json:"error"`    // 最近一次执行的错误
+	NextRun  time.Time     `json:"next_run"`
+}
+
+type maintenanceJob struct {
+	task     string
+	interval time.Duration
+	read     bool // 只读的任务在读连接池执行, 不占用写协程
+	run      func(conn *sqlx.DB) (string, error)
+}
+
+type maintainer struct {
+	ds     *SqliteDataSource
+	jobs   []*maintenanceJob
+	lock   sync.Mutex
+	status map[string]*MaintenanceStatus
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newMaintainer(ds *SqliteDataSource) *maintainer {
+	m := &maintainer{
+		ds:     ds,
+		status: make(map[string]*MaintenanceStatus),
+		done:   make(chan struct{}),
+	}
+	m.jobs = []*maintenanceJob{
+		{task: MaintenanceCheckpoint, interval: ds.config.CheckpointInterval, run: run_checkpoint},
+		{task: MaintenanceVacuum, interval: ds.config.VacuumInterval, run: run_incremental_vacuum},
+		{task: MaintenanceOptimize, interval: ds.config.OptimizeInterval, run: run_optimize},
+		{task: MaintenanceIntegrityCheck, interval: ds.config.IntegrityCheckInterval, read: true, run: run_integrity_check},
+		{task: MaintenanceRetention, interval: ds.config.PartitionRetention, run: ds.drop_expired_partitions},
+	}
+	for _, job := range m.jobs {
+		m.status[job.task] = &MaintenanceStatus{Task: job.task, Interval: job.interval}
+	}
+	return m
+}
+
+// start 为每个启用的任务启动定时器
+func (m *maintainer) start() {
+	for _, job := range m.jobs {
+		if job.interval <= 0 {
+			continue
+		}
+		m.set_next_run(job.task, time.Now().Add(job.interval))
+		m.wg.Add(1)
+		go func(job *maintenanceJob) {
+			defer m.wg.Done()
+			ticker := time.NewTicker(job.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-m.done:
+					return
+				case <-ticker.C:
+					m.run(job)
+					m.set_next_run(job.task, time.Now().Add(job.interval))
+				}
+			}
+		}(job)
+	}
+}
+
+// stop 停止定时器并等待正在执行的任务结束, 之后 RunMaintenance 返回错误
+func (m *maintainer) stop() {
+	m.lock.Lock()
+	select {
+	case <-m.done:
+		m.lock.Unlock()
+		return
+	default:
+	}
+	close(m.done)
+	m.lock.Unlock()
+	m.wg.Wait()
+}
+
+// acquire 登记一次手动执行, 数据源已关闭时返回 false
+func (m *maintainer) acquire() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	select {
+	case <-m.done:
+		return false
+	default:
+	}
+	m.wg.Add(1)
+	return true
+}
+
+// run 执行维护任务, 写任务通过写协程与写操作串行, 只读任务在读连接池执行
+func (m *maintainer) run(job *maintenanceJob) (MaintenanceStatus, error) {
+	start := time.Now()
+	var output string
+	var result SqlResult
+	if job.read {
+		result.Err = m.ds.read(func(reader *sqlx.DB) error {
+			var err error
+			output, err = job.run(reader)
+			return err
+		})
+	} else {
+		task := SqlTask{
+			Do: func(writer *sqlx.DB) error {
+				var err error
+				output, err = job.run(writer)
+				return err
+			},
+			Result: make(chan SqlResult, 1),
+		}
+		defer task.Close()
+		m.ds.enqueue(task)
+		result = <-task.Result
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	status := m.status[job.task]
+	status.Runs++
+	status.LastRun = start
+	status.Duration = time.Since(start)
+	status.Result = output
+	status.Error = ""
+	if result.Err != nil {
+		status.Failures++
+		status.Error = result.Err.Error()
+		log.Printf("sqlite data source[%s] maintenance %s failed: %v\n", m.ds.id, job.task, result.Err)
+	} else {
+		log.Printf("sqlite data source[%s] maintenance %s done in %v: %s\n", m.ds.id, job.task, status.Duration, output)
+	}
+	return *status, result.Err
+}
+
+func (m *maintainer) set_next_run(task string, next time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.status[task].NextRun = next
+}
+
+func (m *maintainer) snapshot() []MaintenanceStatus {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	result := make([]MaintenanceStatus, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		result = append(result, *m.status[job.task])
+	}
+	return result
+}
+
+func run_checkpoint(writer *sqlx.DB) (string, error) {
+	var busy, logFrames, checkpointed int64
+	err := writer.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logFrames, &checkpointed)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("busy=%d log=%d checkpointed=%d", busy, logFrames, checkpointed), nil
+}
+
+func run_incremental_vacuum(writer *sqlx.DB) (string, error) {
+	var mode int
+	if err := writer.Get(&mode, "PRAGMA auto_vacuum"); err != nil {
+		return "", err
+	}
+	// 2 = INCREMENTAL, 其余模式下 incremental_vacuum 不生效
+	if mode != 2 {
+		return "skipped: auto_vacuum is not INCREMENTAL", nil
+	}
+	var before, after int64
+	if err := writer.Get(&before, "PRAGMA freelist_count"); err != nil {
+		return "", err
+	}
+	// incremental_vacuum 每一步回收一页, 需要读完所有结果
+	rows, err := writer.Query("PRAGMA incremental_vacuum")
+	if err != nil {
+		return "", err
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if err := writer.Get(&after, "PRAGMA freelist_count"); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("freed_pages=%d", before-after), nil
+}
+
+func run_optimize(writer *sqlx.DB) (string, error) {
+	if _, err := writer.Exec("PRAGMA optimize"); err != nil {
+		return "", err
+	}
+	return "ok", nil
+}
+
+func run_integrity_check(reader *sqlx.DB) (string, error) {
+	var messages []string
+	if err := reader.Select(&messages, "PRAGMA integrity_check"); err != nil {
+		return "", err
+	}
+	output := strings.Join(messages, "; ")
+	if output != "ok" {
+		return output, fmt.Errorf("database is corrupted: %s", output)
+	}
+	return output, nil
+}
+
+// MaintenanceStatus 返回各个维护任务的最近执行情况
+func (ds *SqliteDataSource) MaintenanceStatus() []MaintenanceStatus {
+	return ds.maintainer.snapshot()
+}
+
+// RunMaintenance 立即执行一次维护任务, 数据源关闭后返回错误
+func (ds *SqliteDataSource) RunMaintenance(task string) (MaintenanceStatus, error) {
+	for _, job := range ds.maintainer.jobs {
+		if job.task != task {
+			continue
+		}
+		if !ds.maintainer.acquire() {
+			return MaintenanceStatus{}, fmt.Errorf("sqlite data source[%s] is closed", ds.id)
+		}
+		defer ds.maintainer.wg.Done()
+		return ds.maintainer.run(job)
+	}
+	return MaintenanceStatus{}, fmt.Errorf("unknown maintenance task: %s", task)
+}
@@ -0,0 +1,320 @@
+package rdbms
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 指标类型
+const (
+	MetricCounter   = "counter"
+	MetricGauge     = "gauge"
+	MetricHistogram = "histogram"
+)
+
+// MetricLabel 指标标签
+type MetricLabel struct {
+	Name  string
+	Value string
+}
+
+// MetricSample 指标的一个取值, Name 为完整名称, 例如直方图的 xxx_bucket、xxx_sum、xxx_count
+type MetricSample struct {
+	Name   string
+	Labels []MetricLabel
+	Value  float64
+}
+
+// MetricFamily 同名指标, 输出时 HELP 和 TYPE 只输出一次
+type MetricFamily struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []MetricSample
+}
+
+// Collector 指标收集器, 数据源都实现了该接口
+type Collector interface {
+	Collect() []MetricFamily
+}
+
+// 耗时直方图的分桶, 单位秒
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// 批量大小直方图的分桶
+var batchBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+
+// histogram 累计直方图
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bucket := range h.buckets {
+		if value <= bucket {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (h *histogram) samples(name string, labels []MetricLabel) []MetricSample {
+	samples := make([]MetricSample, 0, len(h.buckets)+3)
+	for i, bucket := range h.buckets {
+		samples = append(samples, MetricSample{Name: name + "_bucket", Labels: with_label(labels, "le", format_float(bucket)), Value: float64(h.counts[i])})
+	}
+	samples = append(samples,
+		MetricSample{Name: name + "_bucket", Labels: with_label(labels, "le", "+Inf"), Value: float64(h.count)},
+		MetricSample{Name: name + "_sum", Labels: labels, Value: h.sum},
+		MetricSample{Name: name + "_count", Labels: labels, Value: float64(h.count)},
+	)
+	return samples
+}
+
+// dataSourceMetrics 数据源运行时累计的指标
+type dataSourceMetrics struct {
+	lock      sync.Mutex
+	tasks     map[string]float64    // 写任务数, 按任务类型
+	errors    map[string]float64    // 失败的写任务数, 按任务类型
+	latency   map[string]*histogram // 执行耗时, 按语句类型
+	wait      *histogram            // 写任务在队列中等待的时间
+	batch     *histogram            // 批量执行的参数组数
+	commits   float64
+	rollbacks float64
+}
+
+func newDataSourceMetrics() *dataSourceMetrics {
+	return &dataSourceMetrics{
+		tasks:   make(map[string]float64),
+		errors:  make(map[string]float64),
+		latency: make(map[string]*histogram),
+		wait:    newHistogram(latencyBuckets),
+		batch:   newHistogram(batchBuckets),
+	}
+}
+
+// observe_task 记录写任务, kind 为 exec、batch 或 do
+func (m *dataSourceMetrics) observe_task(kind string, wait time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.tasks[kind]++
+	if err != nil {
+		m.errors[kind]++
+	}
+	if wait >= 0 {
+		m.wait.observe(wait.Seconds())
+	}
+}
+
+// observe_latency 记录执行耗时, statement 为语句类型, 例如 insert、select、read
+func (m *dataSourceMetrics) observe_latency(statement string, duration time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, ok := m.latency[statement]
+	if !ok {
+		h = newHistogram(latencyBuckets)
+		m.latency[statement] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+func (m *dataSourceMetrics) observe_batch(size int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.batch.observe(float64(size))
+}
+
+func (m *dataSourceMetrics) observe_tx(committed bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if committed {
+		m.commits++
+	} else {
+		m.rollbacks++
+	}
+}
+
+// statement_type 语句类型, 取第一个关键字
+func statement_type(statement string) string {
+	keyword := strings.ToLower(first_word(strings.TrimSpace(strip_sql_comments(statement))))
+	if keyword == "" {
+		return "other"
+	}
+	return keyword
+}
+
+// Collect 收集数据源的指标
+func (ds *SqliteDataSource) Collect() []MetricFamily {
+	labels := []MetricLabel{{Name: "datasource", Value: ds.id}}
+	families := []MetricFamily{}
+	gauge := func(name string, help string, value float64) {
+		families = append(families, MetricFamily{Name: name, Help: help, Type: MetricGauge, Samples: []MetricSample{{Name: name, Labels: labels, Value: value}}})
+	}
+	counter := func(name string, help string, value float64) {
+		families = append(families, MetricFamily{Name: name, Help: help, Type: MetricCounter, Samples: []MetricSample{{Name: name, Labels: labels, Value: value}}})
+	}
+	by_label := func(name string, help string, label string, values map[string]float64) {
+		family := MetricFamily{Name: name, Help: help, Type: MetricCounter}
+		for _, key := range sorted_keys(values) {
+			family.Samples = append(family.Samples, MetricSample{Name: name, Labels: with_label(labels, label, key), Value: values[key]})
+		}
+		families = append(families, family)
+	}
+
+	gauge("lts_rdbms_writer_queue_depth", "Number of tasks waiting in the writer queue.", float64(len(ds.doTasks)))
+
+	m := ds.metrics
+	m.lock.Lock()
+	by_label("lts_rdbms_writer_tasks_total", "Writer tasks executed, by task kind.", "kind", m.tasks)
+	by_label("lts_rdbms_writer_task_errors_total", "Writer tasks failed, by task kind.", "kind", m.errors)
+	families = append(families, MetricFamily{Name: "lts_rdbms_writer_task_wait_seconds", Help: "Time writer tasks spent waiting in the queue.", Type: MetricHistogram, Samples: m.wait.samples("lts_rdbms_writer_task_wait_seconds", labels)})
+	latency := MetricFamily{Name: "lts_rdbms_exec_duration_seconds", Help: "Statement execution latency, by statement type.", Type: MetricHistogram}
+	for _, statement := range sorted_keys(m.latency) {
+		latency.Samples = append(latency.Samples, m.latency[statement].samples(latency.Name, with_label(labels, "statement", statement))...)
+	}
+	families = append(families, latency)
+	families = append(families, MetricFamily{Name: "lts_rdbms_batch_size", Help: "Number of argument sets per batch task.", Type: MetricHistogram, Samples: m.batch.samples("lts_rdbms_batch_size", labels)})
+	counter("lts_rdbms_tx_commits_total", "Transactions committed by the writer.", m.commits)
+	counter("lts_rdbms_tx_rollbacks_total", "Transactions rolled back by the writer.", m.rollbacks)
+	m.lock.Unlock()
+
+	stats := ds.reader_stats()
+	gauge("lts_rdbms_reader_open_connections", "Open connections in the reader pool.", float64(stats.OpenConnections))
+	gauge("lts_rdbms_reader_in_use_connections", "Reader connections currently in use.", float64(stats.InUse))
+	gauge("lts_rdbms_reader_idle_connections", "Idle reader connections.", float64(stats.Idle))
+	gauge("lts_rdbms_reader_max_open_connections", "Maximum open connections of the reader pool.", float64(stats.MaxOpenConnections))
+	counter("lts_rdbms_reader_wait_count_total", "Reader connection requests that had to wait.", float64(stats.WaitCount))
+	counter("lts_rdbms_reader_wait_seconds_total", "Total time spent waiting for reader connections.", stats.WaitDuration.Seconds())
+	counter("lts_rdbms_reader_max_idle_closed_total", "Reader connections closed due to max idle.", float64(stats.MaxIdleClosed))
+	counter("lts_rdbms_reader_max_idle_time_closed_total", "Reader connections closed due to max idle time.", float64(stats.MaxIdleTimeClosed))
+	counter("lts_rdbms_reader_max_lifetime_closed_total", "Reader connections closed due to max lifetime.", float64(stats.MaxLifetimeClosed))
+
+	gauge("lts_rdbms_db_file_bytes", "Size of the database file.", float64(file_size(ds.db_path)))
+	gauge("lts_rdbms_wal_file_bytes", "Size of the WAL file.", float64(file_size(ds.db_path+"-wal")))
+	return families
+}
+
+// reader_stats 读连接池的统计
+func (ds *SqliteDataSource) reader_stats() sql.DBStats {
+	return ds.reader.Stats()
+}
+
+func file_size(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// WriteMetrics 以 Prometheus 文本格式输出指标, 同名指标合并输出
+func WriteMetrics(w io.Writer, collectors ...Collector) error {
+	names := []string{}
+	merged := make(map[string]*MetricFamily)
+	for _, collector := range collectors {
+		for _, family := range collector.Collect() {
+			if existing, ok := merged[family.Name]; ok {
+				existing.Samples = append(existing.Samples, family.Samples...)
+				continue
+			}
+			family := family
+			merged[family.Name] = &family
+			names = append(names, family.Name)
+		}
+	}
+
+	writer := bufio.NewWriter(w)
+	for _, name := range names {
+		family := merged[name]
+		fmt.Fprintf(writer, "# HELP %s %s\n", family.Name, escape_help(family.Help))
+		fmt.Fprintf(writer, "# TYPE %s %s\n", family.Name, family.Type)
+		for _, sample := range family.Samples {
+			writer.WriteString(sample.Name)
+			if len(sample.Labels) > 0 {
+				writer.WriteByte('{')
+				for i, label := range sample.Labels {
+					if i > 0 {
+						writer.WriteByte(',')
+					}
+					fmt.Fprintf(writer, "%s=\"%s\"", label.Name, escape_label(label.Value))
+				}
+				writer.WriteByte('}')
+			}
+			writer.WriteByte(' ')
+			writer.WriteString(format_float(sample.Value))
+			writer.WriteByte('\n')
+		}
+	}
+	return writer.Flush()
+}
+
+// MetricsHandler 输出指标的 http.Handler, 未指定 collectors 时输出所有已创建的数据源
+func MetricsHandler(collectors ...Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets := collectors
+		if len(targets) == 0 {
+			targets = data_source_collectors()
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := WriteMetrics(w, targets...); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+func data_source_collectors() []Collector {
+	return defaultRegistry.collectors()
+}
+
+func with_label(labels []MetricLabel, name string, value string) []MetricLabel {
+	result := make([]MetricLabel, 0, len(labels)+1)
+	result = append(result, labels...)
+	return append(result, MetricLabel{Name: name, Value: value})
+}
+
+func sorted_keys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func format_float(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escape_help(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escape_label(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
@@ -0,0 +1,334 @@
+package rdbms
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// QueryEvent 一次 SQL 执行的记录
+type QueryEvent struct {
+	DataSource   string        // 数据源 id
+	SQL          string        // 语句
+	Args         []interface{} // 参数, 已脱敏
+	Batch        int           // 批量执行的参数组数, 非批量为 0
+	Reader       bool          // 是否在读连接池执行
+	Start        time.Time     // 开始时间
+	Duration     time.Duration // 耗时, 查询语句不包含读取结果的时间
+	RowsAffected int64         // 影响行数, 查询语句为 0
+	Err          error
+	Plan         string // 慢查询的执行计划, 超过 slow_query_threshold 时记录
+}
+
+// IQueryObserver 查询观察者, 在执行语句的协程中同步调用, 实现应尽快返回
+type IQueryObserver interface {
+	OnQuery(event *QueryEvent)
+}
+
+// QueryObserverFunc 函数形式的查询观察者
+type QueryObserverFunc func(event *QueryEvent)
+
+func (f QueryObserverFunc) OnQuery(event *QueryEvent) {
+	f(event)
+}
+
+// ArgRedactor 参数脱敏, 返回交给观察者的参数, 不能修改传入的切片
+type ArgRedactor func(statement string, args []interface{}) []interface{}
+
+// 默认脱敏的字段, 字段名包含其中任意一个时脱敏
+var DefaultSensitiveColumns = []string{"password", "passwd", "secret", "token", "api_key", "credential"}
+
+const redacted = "******"
+
+// RedactColumns 按字段名脱敏: 识别 col = ?、col IN (?) 和 INSERT 字段列表中的参数, 字段名包含 columns 中任意一个时替换;
+// []byte 参数只保留长度
+func RedactColumns(columns ...string) ArgRedactor {
+	sensitive := make([]string, 0, len(columns))
+	for _, column := range columns {
+		sensitive = append(sensitive, strings.ToLower(column))
+	}
+	return func(statement string, args []interface{}) []interface{} {
+		names := placeholder_columns(statement)
+		result := make([]interface{}, len(args))
+		for i, arg := range args {
+			result[i] = arg
+			if b, ok := arg.([]byte); ok {
+				result[i] = fmt.Sprintf("[%d bytes]", len(b))
+			}
+			if i >= len(names) || names[i] == "" {
+				continue
+			}
+			for _, s := range sensitive {
+				if strings.Contains(names[i], s) {
+					result[i] = redacted
+					break
+				}
+			}
+		}
+		return result
+	}
+}
+
+// NewSlowQueryLogger 记录耗时超过 threshold 的语句, 包含执行计划
+func NewSlowQueryLogger(threshold time.Duration) IQueryObserver {
+	return QueryObserverFunc(func(event *QueryEvent) {
+		if event.Duration < threshold {
+			return
+		}
+		message := fmt.Sprintf("slow query on [%s] took %v: %s; args=%v", event.DataSource, event.Duration, event.SQL, event.Args)
+		if event.Batch > 0 {
+			message += fmt.Sprintf("; batch=%d", event.Batch)
+		}
+		if event.Err != nil {
+			message += fmt.Sprintf("; err=%v", event.Err)
+		}
+		if event.Plan != "" {
+			message += "\n" + event.Plan
+		}
+		log.Println(message)
+	})
+}
+
+// queryObservers 数据源的观察者列表
+type queryObservers struct {
+	lock      sync.RWMutex
+	observers []IQueryObserver
+	redactor  ArgRedactor
+	slow      time.Duration                                     // 超过该耗时时记录执行计划
+	explain   func(statement string, args []interface{}) string // 获取执行计划
+}
+
+func (o *queryObservers) add(observer IQueryObserver) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.observers = append(o.observers, observer)
+}
+
+func (o *queryObservers) set_redactor(redactor ArgRedactor) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.redactor = redactor
+}
+
+func (o *queryObservers) enabled() bool {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return len(o.observers) > 0
+}
+
+// notify 脱敏后通知观察者; 慢查询在读连接池获取执行计划后异步通知,
+// 避免执行语句的连接还未释放时再占用连接
+func (o *queryObservers) notify(event *QueryEvent) {
+	o.lock.RLock()
+	observers, redactor, slow, explain := o.observers, o.redactor, o.slow, o.explain
+	o.lock.RUnlock()
+	if len(observers) == 0 {
+		return
+	}
+	args := event.Args
+	if redactor != nil && len(event.Args) > 0 {
+		event.Args = redactor(event.SQL, event.Args)
+	}
+	deliver := func() {
+		for _, observer := range observers {
+			observer.OnQuery(event)
+		}
+	}
+	if slow > 0 && event.Duration >= slow && explain != nil {
+		go func() {
+			event.Plan = explain(event.SQL, args)
+			deliver()
+		}()
+		return
+	}
+	deliver()
+}
+
+// explain_query_plan 获取语句的执行计划, 失败时返回空字符串; 获取执行计划的语句不通知观察者
+func explain_query_plan(conn sqlx.QueryerContext, statement string, args []interface{}) string {
+	rows, err := conn.QueryContext(without_observe(context.Background()), "EXPLAIN QUERY PLAN "+statement, args...)
+	if err != nil {
+		return ""
+	}
+	defer rows.Close()
+	var lines []string
+	for rows.Next() {
+		var id, parent, notused int64
+		var detail string
+		if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			return ""
+		}
+		lines = append(lines, detail)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// explain 在读连接池获取语句的执行计划
+func (ds *SqliteDataSource) explain(statement string, args []interface{}) string {
+	plan := ""
+	ds.read(func(reader *sqlx.DB) error {
+		plan = explain_query_plan(reader, statement, args)
+		return nil
+	})
+	return plan
+}
+
+// AddQueryObserver 添加查询观察者, 观察读写连接上执行的所有语句, 包括直接使用 Conn() 的查询
+func (ds *SqliteDataSource) AddQueryObserver(observer IQueryObserver) {
+	ds.observers.add(observer)
+}
+
+// SetArgRedactor 设置参数脱敏方式, nil 表示不脱敏, 默认为 RedactColumns(DefaultSensitiveColumns...)
+func (ds *SqliteDataSource) SetArgRedactor(redactor ArgRedactor) {
+	ds.observers.set_redactor(redactor)
+}
+
+// placeholder_columns 返回每个 ? 参数对应的字段名, 无法识别时为空字符串
+func placeholder_columns(statement string) []string {
+	tokens := sql_tokens(statement)
+	columns := []string{}
+	if len(tokens) > 0 && (strings.EqualFold(tokens[0], "INSERT") || strings.EqualFold(tokens[0], "REPLACE")) {
+		return insert_placeholder_columns(tokens)
+	}
+	inColumn := ""
+	depth := 0
+	for i, token := range tokens {
+		switch token {
+		case "(":
+			if inColumn != "" {
+				depth++
+			}
+		case ")":
+			if inColumn != "" {
+				depth--
+				if depth == 0 {
+					inColumn = ""
+				}
+			}
+		case "?":
+			if inColumn != "" {
+				columns = append(columns, inColumn)
+				continue
+			}
+			name := ""
+			if i >= 2 && is_compare_operator(tokens[i-1]) {
+				name = column_name(tokens[i-2])
+			}
+			columns = append(columns, name)
+		default:
+			if strings.EqualFold(token, "IN") && i >= 1 && i+1 < len(tokens) && tokens[i+1] == "(" {
+				prev := i - 1
+				if strings.EqualFold(tokens[prev], "NOT") && prev >= 1 {
+					prev--
+				}
+				inColumn = column_name(tokens[prev])
+			}
+		}
+	}
+	return columns
+}
+
+func insert_placeholder_columns(tokens []string) []string {
+	columns := []string{}
+	names := []string{}
+	i := 0
+	// 字段列表
+	for ; i < len(tokens) && tokens[i] != "("; i++ {
+		if strings.EqualFold(tokens[i], "VALUES") || strings.EqualFold(tokens[i], "SELECT") {
+			break
+		}
+	}
+	if i < len(tokens) && tokens[i] == "(" {
+		for i++; i < len(tokens) && tokens[i] != ")"; i++ {
+			if tokens[i] != "," {
+				names = append(names, column_name(tokens[i]))
+			}
+		}
+		i++
+	}
+	// VALUES 中按位置对应字段
+	depth, position := 0, 0
+	for ; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "(":
+			depth++
+			if depth == 1 {
+				position = 0
+			}
+		case ")":
+			depth--
+		case ",":
+			if depth == 1 {
+				position++
+			}
+		case "?":
+			name := ""
+			if depth >= 1 && position < len(names) {
+				name = names[position]
+			}
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
+func is_compare_operator(token string) bool {
+	switch strings.ToUpper(token) {
+	case "=", "==", "!=", "<>", "<", ">", "<=", ">=", "LIKE", "GLOB":
+		return true
+	}
+	return false
+}
+
+// column_name 去掉表别名和引号, 并转为小写
+func column_name(token string) string {
+	if index := strings.LastIndex(token, "."); index >= 0 {
+		token = token[index+1:]
+	}
+	return strings.ToLower(strings.Trim(token, "`\"[]"))
+}
+
+// sql_tokens 将语句拆分为标识符、参数和符号, 跳过字符串和注释
+func sql_tokens(statement string) []string {
+	statement = strip_sql_comments(statement)
+	tokens := []string{}
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '\'':
+			// 跳过字符串
+			i++
+			for i < len(statement) && statement[i] != '\'' {
+				i++
+			}
+			i++
+		case is_ident_char(c) || c == '"' || c == '`' || c == '[':
+			start := i
+			for i < len(statement) && (is_ident_char(statement[i]) || strings.IndexByte("\"`[].", statement[i]) >= 0) {
+				i++
+			}
+			tokens = append(tokens, statement[start:i])
+		case strings.IndexByte("<>!=", c) >= 0:
+			start := i
+			for i < len(statement) && strings.IndexByte("<>!=", statement[i]) >= 0 {
+				i++
+			}
+			tokens = append(tokens, statement[start:i])
+		default:
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens
+}
+
+func is_ident_char(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
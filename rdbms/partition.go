@@ -0,0 +1,707 @@
+package rdbms
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 时间分区: 模型的行按时间字段写入 <table>_YYYYMM 分区表, 分区在首次写入时按模型的表结构创建, 例如:
+//
+//	type Event struct {
+//		rdbms.Table
+//		ID        int64     `db:"id,pk"`
+//		Kind      string    `db:"kind"`
+//		CreatedAt time.Time `db:"created_at"`
+//	}
+//	ds.EnablePartition(&Event{}, rdbms.PartitionConfig{Column: "created_at", Retention: 180 * 24 * time.Hour, Indexes: []string{"kind"}})
+//	dao.TableInsert(&Event{Kind: "login", CreatedAt: time.Now()})
+//	dao.TableFind(&events, rdbms.NewCriteria().Between(from, to).Where("kind = ?", "login"))
+//
+// TableInsert、TableUpdate 按时间字段路由到分区, 分区字段的值不能修改; TableGet、TableSelect、TableFind 合并多个分区查询,
+// TableFind 使用 Criteria.Between 时只查询范围内的分区; TableDelete 使用模型的表名时删除所有分区中的数据;
+// 自增主键从分区序号左移 32 位开始, 各分区的主键不会重复, 按主键读写时直接定位分区;
+// 超过保留时长的分区由定期维护任务 MaintenanceRetention 删除;
+// 分区表不支持全文检索、审计和变更记录, 也不能作为关联预加载的子表;
+// Files 为 true 时每个分区一个数据库文件, 以附加数据库的方式访问, SQLite 最多同时附加 10 个数据库,
+// 因此必须设置 Retention, 且按保留时长估算的分区文件数加上其他附加数据库不能超过 10 个
+
+type PartitionPeriod string
+
+// sqliteMaxAttached SQLite 默认最多同时附加的数据库个数
+const sqliteMaxAttached = 10
+
+const (
+	PartitionMonthly PartitionPeriod = "monthly" // 按月分区, 表名后缀 YYYYMM
+	PartitionDaily   PartitionPeriod = "daily"   // 按天分区, 表名后缀 YYYYMMDD
+)
+
+// PartitionConfig 时间分区配置
+type PartitionConfig struct {
+	Column    string          // 分区字段, time.Time 或 unix 秒的整数
+	Period    PartitionPeriod // 分区周期, 默认按月
+	Retention time.Duration   // 保留时长, 分区的结束时间早于当前时间减去保留时长时删除, 0 表示不删除
+	Indexes   []string        // 分区表的索引, 每项为逗号分隔的字段, 例如 "user_id,created_at"
+	Files     bool            // 每个分区使用单独的数据库文件
+	Dir       string          // 分区文件所在目录, 默认与数据库文件相同
+	Location  *time.Location  // 计算分区边界的时区, 默认 time.Local
+}
+
+type partitioner struct {
+	ds     *SqliteDataSource
+	spec   *TableSpec // 模型的表结构, 表名为分区表名的前缀
+	config PartitionConfig
+	layout string // 分区后缀的时间格式
+	unix   bool   // 分区字段是 unix 秒的整数
+	lock   sync.RWMutex
+	specs  map[int64]*TableSpec // key: 分区序号, 按月为 年*12+月-1, 按天为 unix 天数
+}
+
+func newPartitioner(ds *SqliteDataSource, ts *TableSpec, config PartitionConfig) (*partitioner, error) {
+	p := &partitioner{ds: ds, spec: ts, config: config, specs: make(map[int64]*TableSpec)}
+	switch p.config.Period {
+	case "", PartitionMonthly:
+		p.config.Period, p.layout = PartitionMonthly, "200601"
+	case PartitionDaily:
+		p.layout = "20060102"
+	default:
+		return nil, fmt.Errorf("unknown partition period: %s", config.Period)
+	}
+	if p.config.Location == nil {
+		p.config.Location = time.Local
+	}
+	if p.config.Dir == "" {
+		p.config.Dir = filepath.Dir(ds.db_path)
+	}
+	if p.config.Files {
+		if err := p.check_attach_limit(); err != nil {
+			return nil, err
+		}
+	}
+
+	index, ok := ts.dbTagFieldIndexes[config.Column]
+	if !ok {
+		return nil, fmt.Errorf("partition column %s not found in table[%s]", config.Column, ts.tableName)
+	}
+	t := ts.modelType.FieldByIndex(index).Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		p.unix = true
+	default:
+		if t != timeType {
+			return nil, fmt.Errorf("partition column %s must be time.Time or unix seconds", config.Column)
+		}
+	}
+	for _, columns := range config.Indexes {
+		for _, column := range strings.Split(columns, ",") {
+			if _, ok := ts.dbTagFieldIndexes[strings.TrimSpace(column)]; !ok {
+				return nil, fmt.Errorf("index column %s not found in table[%s]", column, ts.tableName)
+			}
+		}
+	}
+	return p, nil
+}
+
+// check_attach_limit 分区文件模式下检查需要同时附加的数据库是否超过 SQLite 的限制
+func (p *partitioner) check_attach_limit() error {
+	if p.config.Retention <= 0 {
+		return fmt.Errorf("partition files of table[%s] require Retention, sqlite attaches at most %d databases", p.spec.tableName, sqliteMaxAttached)
+	}
+	files := []*partitioner{p}
+	p.ds.partitions.Range(func(_, value any) bool {
+		if other := value.(*partitioner); other.config.Files {
+			files = append(files, other)
+		}
+		return true
+	})
+	count := 0
+	for _, q := range files {
+		count += q.max_files()
+	}
+	// 数据源自身配置的附加数据库
+	p.ds.lock.RLock()
+	for schema := range p.ds.config.Attach {
+		owned := false
+		for _, q := range files[1:] {
+			owned = owned || q.owns(schema)
+		}
+		if !owned {
+			count++
+		}
+	}
+	p.ds.lock.RUnlock()
+	if count > sqliteMaxAttached {
+		return fmt.Errorf("partition files of table[%s] need up to %d attached databases, sqlite attaches at most %d, shorten Retention or use a longer Period",
+			p.spec.tableName, count, sqliteMaxAttached)
+	}
+	return nil
+}
+
+// max_files 保留时长内最多同时存在的分区文件数, 包括当前分区, 并为维护任务的执行间隔多留一个
+func (p *partitioner) max_files() int {
+	period := 24 * time.Hour
+	if p.config.Period == PartitionMonthly {
+		period = 28 * 24 * time.Hour
+	}
+	return int((p.config.Retention+period-1)/period) + 2
+}
+
+// owns 附加数据库的 schema 是否为该表的分区
+func (p *partitioner) owns(schema string) bool {
+	prefix := p.spec.tableName + "_"
+	if !strings.HasPrefix(schema, prefix) {
+		return false
+	}
+	index, ok := p.parse_suffix(strings.TrimPrefix(schema, prefix))
+	return ok && p.name(index) == schema
+}
+
+// EnablePartition 为模型开启时间分区, 并加载已有的分区
+func (ds *SqliteDataSource) EnablePartition(model ITable, config PartitionConfig) error {
+	ts, err := ds.getModelSpec(model)
+	if err != nil {
+		return err
+	}
+	if err := check_main_table(ts.tableName, "partition"); err != nil {
+		return err
+	}
+	// 分片和分区都按序号分段自增主键, 同时使用时主键会重复
+	if ds.sharded && ts.autoIncrement {
+		return fmt.Errorf("table[%s] is sharded with auto-increment keys and cannot be partitioned", ts.tableName)
+	}
+	p, err := newPartitioner(ds, ts, config)
+	if err != nil {
+		return err
+	}
+	if _, loaded := ds.partitions.LoadOrStore(ts, p); loaded {
+		return fmt.Errorf("table[%s] is already partitioned", ts.tableName)
+	}
+	if err := ds.run_writer(p.load); err != nil {
+		ds.partitions.Delete(ts)
+		return err
+	}
+	return nil
+}
+
+// Partitions 返回模型已创建的分区表名, 按时间排序; 分区文件模式下为 schema.table
+func (ds *SqliteDataSource) Partitions(model ITable) ([]string, error) {
+	ts, err := ds.getModelSpec(model)
+	if err != nil {
+		return nil, err
+	}
+	p := ds.partitioner(ts)
+	if p == nil {
+		return nil, fmt.Errorf("table[%s] is not partitioned", ts.tableName)
+	}
+	tables := []string{}
+	for _, index := range p.indexes() {
+		tables = append(tables, p.table(index))
+	}
+	return tables, nil
+}
+
+// run_writer 在写协程中执行 fn 并等待结果
+func (ds *SqliteDataSource) run_writer(fn func(writer *sqlx.DB) error) error {
+	task := SqlTask{Do: fn, Result: make(chan SqlResult, 1)}
+	defer task.Close()
+	ds.enqueue(task)
+	return (<-task.Result).Err
+}
+
+func (ds *SqliteDataSource) partitioner(ts *TableSpec) *partitioner {
+	if p, ok := ds.partitions.Load(ts); ok {
+		return p.(*partitioner)
+	}
+	return nil
+}
+
+// partition_spec 分区表返回模型所在分区的表结构, create 为 true 时分区不存在则创建; 其他表返回原表结构
+func (ds *SqliteDataSource) partition_spec(ts *TableSpec, model ITable, create bool) (*TableSpec, error) {
+	p := ds.partitioner(ts)
+	if p == nil {
+		return ts, nil
+	}
+	t, err := p.model_time(model)
+	if err != nil {
+		return nil, err
+	}
+	index := p.index_of(t)
+	if create {
+		return p.ensure(index)
+	}
+	if spec := p.spec_of(index); spec != nil {
+		return spec, nil
+	}
+	return nil, fmt.Errorf("partition %s of table[%s] not found", p.name(index), ts.tableName)
+}
+
+// drop_expired_partitions 维护任务, 删除所有分区表中超过保留时长的分区
+func (ds *SqliteDataSource) drop_expired_partitions(writer *sqlx.DB) (string, error) {
+	dropped := []string{}
+	var err error
+	ds.partitions.Range(func(_, value any) bool {
+		var tables []string
+		tables, err = value.(*partitioner).drop_expired(writer)
+		dropped = append(dropped, tables...)
+		return err == nil
+	})
+	if len(dropped) == 0 {
+		return "dropped: none", err
+	}
+	return "dropped: " + strings.Join(dropped, ", "), err
+}
+
+func (p *partitioner) index_of(t time.Time) int64 {
+	t = t.In(p.config.Location)
+	if p.config.Period == PartitionDaily {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+	}
+	return int64(t.Year())*12 + int64(t.Month()) - 1
+}
+
+// start_of 分区的开始时间, 下一个分区的开始时间即为结束时间
+func (p *partitioner) start_of(index int64) time.Time {
+	if p.config.Period == PartitionDaily {
+		day := time.Unix(index*86400, 0).UTC()
+		return time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, p.config.Location)
+	}
+	return time.Date(int(index/12), time.Month(index%12+1), 1, 0, 0, 0, 0, p.config.Location)
+}
+
+// parse_suffix 解析分区表名的时间后缀
+func (p *partitioner) parse_suffix(suffix string) (int64, bool) {
+	if len(suffix) != len(p.layout) {
+		return 0, false
+	}
+	t, err := time.ParseInLocation(p.layout, suffix, p.config.Location)
+	if err != nil {
+		return 0, false
+	}
+	return p.index_of(t), true
+}
+
+// name 分区名, 分区文件模式下同时是附加库的 schema 和库中的表名
+func (p *partitioner) name(index int64) string {
+	return p.spec.tableName + "_" + p.start_of(index).Format(p.layout)
+}
+
+// table SQL 中使用的分区表名
+func (p *partitioner) table(index int64) string {
+	if p.config.Files {
+		return p.name(index) + "." + p.name(index)
+	}
+	return p.name(index)
+}
+
+// schema 分区文件模式下为附加库的 schema 前缀, 用于索引和 sqlite_sequence
+func (p *partitioner) schema(index int64) string {
+	if p.config.Files {
+		return p.name(index) + "."
+	}
+	return ""
+}
+
+func (p *partitioner) file(index int64) string {
+	return filepath.Join(p.config.Dir, p.name(index)+".db")
+}
+
+func (p *partitioner) spec_of(index int64) *TableSpec {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.specs[index]
+}
+
+// indexes 已创建的分区序号, 升序
+func (p *partitioner) indexes() []int64 {
+	p.lock.RLock()
+	indexes := make([]int64, 0, len(p.specs))
+	for index := range p.specs {
+		indexes = append(indexes, index)
+	}
+	p.lock.RUnlock()
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	return indexes
+}
+
+func (p *partitioner) register(index int64) {
+	spec := p.spec.withTableName(p.table(index))
+	p.lock.Lock()
+	p.specs[index] = spec
+	p.lock.Unlock()
+	p.ds.tableSpecs.Store(spec.tableName, spec)
+}
+
+func (p *partitioner) unregister(index int64) {
+	p.lock.Lock()
+	delete(p.specs, index)
+	p.lock.Unlock()
+	p.ds.tableSpecs.Delete(p.table(index))
+}
+
+// load 在写协程中执行, 加载已有的分区表或分区文件
+func (p *partitioner) load(writer *sqlx.DB) error {
+	indexes := []int64{}
+	prefix := p.spec.tableName + "_"
+	if p.config.Files {
+		files, err := filepath.Glob(filepath.Join(p.config.Dir, prefix+"*.db"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			name := strings.TrimSuffix(filepath.Base(file), ".db")
+			if index, ok := p.parse_suffix(strings.TrimPrefix(name, prefix)); ok && p.name(index) == name {
+				indexes = append(indexes, index)
+			}
+		}
+		if err := p.attach(writer, indexes...); err != nil {
+			return err
+		}
+	} else {
+		var names []string
+		err := writer.Select(&names, "SELECT name FROM sqlite_master WHERE type = 'table' AND substr(name, 1, ?) = ?", len(prefix), prefix)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if index, ok := p.parse_suffix(strings.TrimPrefix(name, prefix)); ok && p.name(index) == name {
+				indexes = append(indexes, index)
+			}
+		}
+	}
+	for _, index := range indexes {
+		p.register(index)
+	}
+	return nil
+}
+
+// attach 在写连接上附加分区文件, 并更新读连接池的 DSN, 之后建立的读连接也附加分区文件
+func (p *partitioner) attach(writer *sqlx.DB, indexes ...int64) error {
+	attached := []int64{}
+	for _, index := range indexes {
+		name := p.name(index)
+		if _, ok := p.ds.config.Attach[name]; ok {
+			continue
+		}
+		if _, err := writer.Exec("ATTACH DATABASE ? AS "+name, "file:"+sqlite_uri_path(p.file(index))); err != nil {
+			return fmt.Errorf("attach partition %s failed: %w", name, err)
+		}
+		attached = append(attached, index)
+	}
+	if len(attached) == 0 {
+		return nil
+	}
+	ds := p.ds
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	if ds.config.Attach == nil {
+		ds.config.Attach = make(map[string]string)
+	}
+	for _, index := range attached {
+		ds.config.Attach[p.name(index)] = p.file(index)
+	}
+	ds.renew_reader(ds.mmapSize, ds.cacheSize)
+	return nil
+}
+
+// ensure 返回分区的表结构, 分区不存在时在写协程中创建
+func (p *partitioner) ensure(index int64) (*TableSpec, error) {
+	if spec := p.spec_of(index); spec != nil {
+		return spec, nil
+	}
+	err := p.ds.run_writer(func(writer *sqlx.DB) error {
+		return p.create(writer, index)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p.spec_of(index), nil
+}
+
+// create 在写协程中执行, 按模型的表结构创建分区表和索引
+func (p *partitioner) create(writer *sqlx.DB, index int64) error {
+	if p.spec_of(index) != nil {
+		return nil
+	}
+	name := p.name(index)
+	if p.config.Files {
+		if err := p.attach(writer, index); err != nil {
+			return err
+		}
+		if _, err := writer.Exec(fmt.Sprintf("PRAGMA %s.journal_mode = %s", name, p.ds.config.JournalMode)); err != nil {
+			return err
+		}
+	}
+	statements := []string{generateCreateTableQuery(p.spec, p.table(index))}
+	for _, columns := range p.config.Indexes {
+		fields := strings.Split(columns, ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		statements = append(statements, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %sidx_%s_%s ON %s (%s)",
+			p.schema(index), name, strings.Join(fields, "_"), name, strings.Join(fields, ", ")))
+	}
+
+	tx, err := writer.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("create partition %s failed: %w", name, err)
+		}
+	}
+	if p.spec.autoIncrement {
+		// 自增主键从分区序号左移 32 位开始, 各分区的主键不重复, 也可以由主键反查分区
+		sequence := p.schema(index) + "sqlite_sequence"
+		statement := fmt.Sprintf("INSERT INTO %s (name, seq) SELECT ?, ? WHERE NOT EXISTS (SELECT 1 FROM %s WHERE name = ?)", sequence, sequence)
+		if _, err := tx.Exec(statement, name, index<<32, name); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("create partition %s failed: %w", name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	p.register(index)
+	log.Printf("partition %s of table[%s] created\n", name, p.spec.tableName)
+	return nil
+}
+
+// drop_expired 在写协程中执行, 删除结束时间早于保留时长的分区
+func (p *partitioner) drop_expired(writer *sqlx.DB) ([]string, error) {
+	if p.config.Retention <= 0 {
+		return nil, nil
+	}
+	cutoff := time.Now().Add(-p.config.Retention)
+	expired := []int64{}
+	for _, index := range p.indexes() {
+		if !p.start_of(index + 1).After(cutoff) {
+			expired = append(expired, index)
+		}
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+
+	dropped := []string{}
+	if !p.config.Files {
+		for _, index := range expired {
+			if _, err := writer.Exec("DROP TABLE IF EXISTS " + p.name(index)); err != nil {
+				return dropped, err
+			}
+			p.unregister(index)
+			dropped = append(dropped, p.name(index))
+		}
+		return dropped, nil
+	}
+
+	// 分区文件: 写连接分离后更新读连接池的 DSN, 之后再删除文件; 使用中的旧读连接归还时关闭
+	for _, index := range expired {
+		if _, err := writer.Exec("DETACH DATABASE " + p.name(index)); err != nil {
+			return dropped, err
+		}
+		p.unregister(index)
+		dropped = append(dropped, p.table(index))
+	}
+	ds := p.ds
+	ds.lock.Lock()
+	for _, index := range expired {
+		delete(ds.config.Attach, p.name(index))
+	}
+	ds.renew_reader(ds.mmapSize, ds.cacheSize)
+	ds.lock.Unlock()
+	for _, index := range expired {
+		if err := os.Remove(p.file(index)); err != nil && !os.IsNotExist(err) {
+			return dropped, err
+		}
+		remove_sqlite_sidecars(p.file(index))
+	}
+	return dropped, nil
+}
+
+// model_time 读取模型的分区字段
+func (p *partitioner) model_time(model ITable) (time.Time, error) {
+	v, err := model_value(model)
+	if err != nil {
+		return time.Time{}, err
+	}
+	field, ok := p.spec.fieldValue(v, p.config.Column, false)
+	for ok && field.Kind() == reflect.Ptr {
+		ok = !field.IsNil()
+		if ok {
+			field = field.Elem()
+		}
+	}
+	var t time.Time
+	switch {
+	case !ok:
+	case p.unix && field.CanInt():
+		if n := field.Int(); n != 0 {
+			t = time.Unix(n, 0)
+		}
+	case p.unix:
+		if n := field.Uint(); n != 0 {
+			t = time.Unix(int64(n), 0)
+		}
+	default:
+		t = field.Interface().(time.Time)
+	}
+	if t.IsZero() {
+		return t, fmt.Errorf("partition column %s of table[%s] is zero", p.config.Column, p.spec.tableName)
+	}
+	return t, nil
+}
+
+// time_arg 将时间编码为分区字段的查询参数
+func (p *partitioner) time_arg(t time.Time) (interface{}, error) {
+	if p.unix {
+		return t.Unix(), nil
+	}
+	return encode_field(reflect.ValueOf(t), p.spec.dbTagConverters[p.config.Column], nil)
+}
+
+// key_indexes 自增主键的高 32 位为分区序号, 返回主键所在的已有分区; 不能由主键定位时返回 false
+func (p *partitioner) key_indexes(keys []interface{}) ([]int64, bool) {
+	if !p.spec.autoIncrement {
+		return nil, false
+	}
+	seen := make(map[int64]bool)
+	indexes := []int64{}
+	for _, key := range keys {
+		id, ok := int_key(key)
+		if !ok {
+			return nil, false
+		}
+		index := id >> 32
+		if !seen[index] && p.spec_of(index) != nil {
+			seen[index] = true
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes, true
+}
+
+// key_view 按主键读取时使用的表结构
+func (p *partitioner) key_view(keys []interface{}) *TableSpec {
+	indexes, ok := p.key_indexes(keys)
+	if !ok {
+		indexes = p.indexes()
+	}
+	return p.view(indexes)
+}
+
+// find 返回时间范围内的分区合并后的表结构, 以及追加了时间条件的查询条件
+func (p *partitioner) find(criteria *Criteria) (*TableSpec, *Criteria, error) {
+	indexes := []int64{}
+	for _, index := range p.indexes() {
+		if !criteria.from.IsZero() && !p.start_of(index+1).After(criteria.from) {
+			continue
+		}
+		if !criteria.to.IsZero() && !p.start_of(index).Before(criteria.to) {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	ranged := *criteria
+	ranged.conditions = append([]string{}, criteria.conditions...)
+	ranged.args = append([]interface{}{}, criteria.args...)
+	if !criteria.from.IsZero() {
+		arg, err := p.time_arg(criteria.from)
+		if err != nil {
+			return nil, nil, err
+		}
+		ranged.Where(p.config.Column+" >= ?", arg)
+	}
+	if !criteria.to.IsZero() {
+		arg, err := p.time_arg(criteria.to)
+		if err != nil {
+			return nil, nil, err
+		}
+		ranged.Where(p.config.Column+" < ?", arg)
+	}
+	return p.view(indexes), &ranged, nil
+}
+
+// view 返回合并分区查询的表结构, 表名为 UNION ALL 子查询, 别名为模型的表名; 没有分区时查询结果为空
+func (p *partitioner) view(indexes []int64) *TableSpec {
+	columns := strings.Join(p.spec.dbTags, ",")
+	selects := make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		selects = append(selects, fmt.Sprintf("SELECT %s FROM %s", columns, p.table(index)))
+	}
+	if len(selects) == 0 {
+		nulls := make([]string, 0, len(p.spec.dbTags))
+		for _, dbTag := range p.spec.dbTags {
+			nulls = append(nulls, "NULL AS "+dbTag)
+		}
+		selects = append(selects, fmt.Sprintf("SELECT %s WHERE 0", strings.Join(nulls, ",")))
+	}
+	return p.spec.withTableName(fmt.Sprintf("(%s) AS %s", strings.Join(selects, " UNION ALL "), p.spec.tableName))
+}
+
+// delete 按主键删除分区中的数据, 不能由主键定位分区时在所有分区中删除
+func (p *partitioner) delete(dao *SqliteDao, keys []interface{}) (int64, error) {
+	args, err := p.spec.keyArgs(keys)
+	if err != nil {
+		return 0, err
+	}
+	indexes, ok := p.key_indexes(keys)
+	if !ok {
+		indexes = p.indexes()
+	}
+	var count int64
+	task := SqlTask{
+		Do: func(writer *sqlx.DB) error {
+			count = 0
+			tx, err := writer.Beginx()
+			if err != nil {
+				return err
+			}
+			for _, index := range indexes {
+				spec := p.spec_of(index)
+				if spec == nil {
+					continue
+				}
+				result, err := tx.Exec(spec.getDeleteSql(len(keys)), args...)
+				if err != nil {
+					tx.Rollback()
+					return err
+				}
+				affected, _ := result.RowsAffected()
+				count += affected
+			}
+			return tx.Commit()
+		},
+		Result: make(chan SqlResult, 1),
+	}
+	defer task.Close()
+	if result := dao.submit(task); result.Err != nil {
+		return 0, result.Err
+	}
+	return count, nil
+}
+
+// int_key 整数主键的值, 其他类型返回 false
+func int_key(key interface{}) (int64, bool) {
+	v := reflect.ValueOf(key)
+	switch {
+	case v.CanInt():
+		return v.Int(), true
+	case v.CanUint():
+		return int64(v.Uint()), true
+	default:
+		return 0, false
+	}
+}
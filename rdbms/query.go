@@ -0,0 +1,259 @@
+package rdbms
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// QueryAll 查询多行, T 可以是结构体、结构体指针或标量; 读语句走读连接池, 写语句(例如 RETURNING)走写协程
+func QueryAll[T any](dao IDao, statement string, args ...interface{}) ([]T, error) {
+	statement, args = expand_sql_args(statement, args)
+	result := []T{}
+	err := route_query_each(dao, statement, func(conn sqlx.Queryer) error {
+		if ts := dao_model_spec(dao, &result); ts != nil {
+			rows, err := conn.Query(statement, args...)
+			if err != nil {
+				return err
+			}
+			return ts.scanAll(rows, &result)
+		}
+		return sqlx.Select(conn, &result, statement, args...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// QueryOne 查询单行, 没有数据时返回 sql.ErrNoRows
+func QueryOne[T any](dao IDao, statement string, args ...interface{}) (T, error) {
+	statement, args = expand_sql_args(statement, args)
+	var result T
+	err := route_query(dao, statement, func(conn sqlx.Queryer) error {
+		if ts := dao_model_spec(dao, &result); ts != nil {
+			rows, err := conn.Query(statement, args...)
+			if err != nil {
+				return err
+			}
+			// T 为结构体指针时先分配
+			target := reflect.ValueOf(&result).Elem()
+			if target.Kind() == reflect.Ptr {
+				target.Set(reflect.New(target.Type().Elem()))
+				return ts.scanOne(rows, target.Interface())
+			}
+			return ts.scanOne(rows, &result)
+		}
+		return sqlx.Get(conn, &result, statement, args...)
+	})
+	return result, err
+}
+
+// QueryScalar 查询单个值, 例如 COUNT(*)
+func QueryScalar[T any](dao IDao, statement string, args ...interface{}) (T, error) {
+	statement, args = expand_sql_args(statement, args)
+	var result T
+	err := route_query(dao, statement, func(conn sqlx.Queryer) error {
+		return conn.QueryRowx(statement, args...).Scan(&result)
+	})
+	return result, err
+}
+
+// route_query 读语句在读连接池执行, 其余语句交给写协程执行; 分片 DAO 多于一个分片时结果无法合并, 返回错误
+func route_query(dao IDao, statement string, fn func(conn sqlx.Queryer) error) error {
+	if sharded, ok := dao.(*shardedDao); ok {
+		if err := sharded.single_shard(); err != nil {
+			return err
+		}
+		return route_query(sharded.daos[0], statement, fn)
+	}
+	if is_read_statement(statement) {
+		return dao.Read(fn)
+	}
+	return dao.Write(func(conn sqlx.Ext) error {
+		return fn(conn)
+	})
+}
+
+// route_query_each 分片 DAO 在每个分片上依次执行 fn, 供逐行追加结果的查询合并各分片
+func route_query_each(dao IDao, statement string, fn func(conn sqlx.Queryer) error) error {
+	sharded, ok := dao.(*shardedDao)
+	if !ok {
+		return route_query(dao, statement, fn)
+	}
+	for _, d := range sharded.daos {
+		if err := route_query(d, statement, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dao_model_spec 查找已注册的模型表结构, 已注册的模型使用表结构读取以支持字段转换器
+func dao_model_spec(dao IDao, model interface{}) *TableSpec {
+	finder, ok := dao.(interface {
+		getModelSpec(model interface{}) (*TableSpec, error)
+	})
+	if !ok {
+		return nil
+	}
+	t := model_type(model)
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	ts, err := finder.getModelSpec(model)
+	if err != nil {
+		return nil
+	}
+	return ts
+}
+
+// is_read_statement 根据语句的第一个关键字判断是否为只读语句, 字符串和注释中的关键字不参与判断
+func is_read_statement(statement string) bool {
+	tokens := sql_tokens(statement)
+	for len(tokens) > 0 && tokens[0] == "(" {
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 {
+		return false
+	}
+	switch strings.ToUpper(tokens[0]) {
+	case "SELECT", "VALUES", "EXPLAIN":
+		return true
+	case "WITH":
+		return cte_main_keyword(tokens) == "SELECT"
+	case "PRAGMA":
+		return !contains_string(tokens, "=")
+	default:
+		return false
+	}
+}
+
+// cte_main_keyword 返回 WITH 语句中 CTE 定义之后的主语句关键字, CTE 定义都在括号内, 主语句是括号外第一个语句关键字
+func cte_main_keyword(tokens []string) string {
+	depth := 0
+	for _, token := range tokens[1:] {
+		switch token {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		default:
+			if depth != 0 {
+				continue
+			}
+			switch keyword := strings.ToUpper(token); keyword {
+			case "SELECT", "VALUES":
+				return "SELECT"
+			case "INSERT", "UPDATE", "DELETE", "REPLACE":
+				return keyword
+			}
+		}
+	}
+	return ""
+}
+
+func first_word(text string) string {
+	for i, r := range text {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return text[:i]
+		}
+	}
+	return text
+}
+
+func strip_sql_comments(statement string) string {
+	var builder strings.Builder
+	for i := 0; i < len(statement); i++ {
+		if strings.HasPrefix(statement[i:], "--") {
+			for i < len(statement) && statement[i] != '\n' {
+				i++
+			}
+			continue
+		}
+		if strings.HasPrefix(statement[i:], "/*") {
+			end := strings.Index(statement[i+2:], "*/")
+			if end < 0 {
+				break
+			}
+			i += end + 3
+			continue
+		}
+		builder.WriteByte(statement[i])
+	}
+	return builder.String()
+}
+
+// expand_sql_args 将切片参数展开为 IN 列表, 例如 "id IN (?)" + []int64{1,2} => "id IN (?,?)";
+// 空切片展开为 NULL, []byte 不展开
+func expand_sql_args(statement string, args []interface{}) (string, []interface{}) {
+	expand := false
+	for _, arg := range args {
+		if is_expandable_arg(arg) {
+			expand = true
+			break
+		}
+	}
+	if !expand {
+		return statement, args
+	}
+
+	var builder strings.Builder
+	params := make([]interface{}, 0, len(args))
+	index := 0
+	var quote byte
+	for i := 0; i < len(statement); i++ {
+		c := statement[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?' && index < len(args):
+			arg := args[index]
+			index++
+			if is_expandable_arg(arg) {
+				values := SqlToParams(arg)
+				if len(values) == 0 {
+					builder.WriteString("NULL")
+				} else {
+					builder.WriteString(strings.Trim(SqlInValues(len(values)), "()"))
+					params = append(params, values...)
+				}
+				continue
+			}
+			params = append(params, arg)
+		}
+		builder.WriteByte(c)
+	}
+	params = append(params, args[index:]...)
+	return builder.String(), params
+}
+
+func is_expandable_arg(arg interface{}) bool {
+	if arg == nil {
+		return false
+	}
+	if _, ok := arg.([]byte); ok {
+		return false
+	}
+	kind := reflect.TypeOf(arg).Kind()
+	return kind == reflect.Slice
+}
+
+// rows_to_maps 将查询结果转为 map 切片, key 为字段名
+func rows_to_maps(rows *sqlx.Rows) ([]map[string]interface{}, error) {
+	defer rows.Close()
+	result := []map[string]interface{}{}
+	for rows.Next() {
+		row := make(map[string]interface{})
+		if err := rows.MapScan(row); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,269 @@
+package rdbms
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 关联类型, 通过 rel tag 声明, 例如:
+//
+//	Items    []OrderItem `rel:"has_many,fk=order_id"`    // order_item.order_id 引用 order 的主键
+//	Invoice  *Invoice    `rel:"has_one,fk=order_id"`     // invoice.order_id 引用 order 的主键
+//	Customer *Customer   `rel:"belongs_to,fk=customer_id"` // order.customer_id 引用 customer 的主键
+const (
+	RelationHasOne    = "has_one"
+	RelationHasMany   = "has_many"
+	RelationBelongsTo = "belongs_to"
+)
+
+type relation struct {
+	name       string       // 字段名
+	kind       string       // 关联类型
+	foreignKey string       // 外键字段
+	index      []int        // 字段路径
+	target     reflect.Type // 关联模型的结构体类型
+}
+
+// scan_table_relations 扫描结构体中带 rel tag 的字段
+func scan_table_relations(t reflect.Type) (map[string]*relation, error) {
+	relations := make(map[string]*relation)
+	var walk func(t reflect.Type, prefix []int) error
+	walk = func(t reflect.Type, prefix []int) error {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			index := append(append([]int{}, prefix...), i)
+			tag := field.Tag.Get("rel")
+			if field.Anonymous && tag == "" {
+				// 匿名嵌入的结构体中声明的关联, 同名时取层级最浅的
+				ft := field.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct && !is_scalar_struct(ft) {
+					if err := walk(ft, index); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if tag == "" || !field.IsExported() {
+				continue
+			}
+			rel, err := parse_relation(t, field, tag)
+			if err != nil {
+				return err
+			}
+			rel.index = index
+			if existing, ok := relations[field.Name]; ok {
+				if len(existing.index) < len(index) {
+					continue
+				}
+				if len(existing.index) == len(index) {
+					return fmt.Errorf("relation %s is declared more than once at the same embedding depth", field.Name)
+				}
+			}
+			relations[field.Name] = rel
+		}
+		return nil
+	}
+	if err := walk(t, nil); err != nil {
+		return nil, err
+	}
+	return relations, nil
+}
+
+// parse_relation 解析字段的 rel tag
+func parse_relation(t reflect.Type, field reflect.StructField, tag string) (*relation, error) {
+	rel := &relation{name: field.Name, target: model_type(reflect.Zero(field.Type).Interface())}
+	for i, part := range strings.Split(tag, ",") {
+		part = strings.TrimSpace(part)
+		if i == 0 {
+			rel.kind = part
+		} else if strings.HasPrefix(part, "fk=") {
+			rel.foreignKey = strings.TrimPrefix(part, "fk=")
+		}
+	}
+	switch rel.kind {
+	case RelationHasOne, RelationBelongsTo:
+		if field.Type.Kind() == reflect.Slice {
+			return nil, fmt.Errorf("relation %s.%s must not be a slice", t.Name(), field.Name)
+		}
+	case RelationHasMany:
+		if field.Type.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("relation %s.%s must be a slice", t.Name(), field.Name)
+		}
+	default:
+		return nil, fmt.Errorf("unknown relation %s of %s.%s", rel.kind, t.Name(), field.Name)
+	}
+	if rel.foreignKey == "" {
+		return nil, fmt.Errorf("relation %s.%s missing fk", t.Name(), field.Name)
+	}
+	if rel.target == nil || rel.target.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("relation %s.%s target is not a struct", t.Name(), field.Name)
+	}
+	return rel, nil
+}
+
+// model_elems 将模型指针或切片指针展开为可寻址的结构体值
+func model_elems(models interface{}) ([]reflect.Value, error) {
+	v := reflect.ValueOf(models)
+	if v.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("models must be a pointer to a struct or a slice")
+	}
+	v = v.Elem()
+	if v.Kind() == reflect.Struct {
+		return []reflect.Value{v}, nil
+	}
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("models must be a pointer to a struct or a slice")
+	}
+	elems := make([]reflect.Value, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		elem := v.Index(i)
+		if elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				continue
+			}
+			elem = elem.Elem()
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
+
+// key_string 统一不同整数类型的键值, 用于分组
+func key_string(value interface{}) string {
+	return fmt.Sprint(value)
+}
+
+// preload 加载模型的关联, 每个关联只执行一条 IN 查询
+func (dao *SqliteDao) preload(ts *TableSpec, elems []reflect.Value, path string) error {
+	if len(elems) == 0 {
+		return nil
+	}
+	name, rest, _ := strings.Cut(path, ".")
+	rel, ok := ts.relations[name]
+	if !ok {
+		return fmt.Errorf("relation %s not found in table[%s]", name, ts.tableName)
+	}
+	target, err := dao.ds.getModelSpec(reflect.New(rel.target).Interface())
+	if err != nil {
+		return err
+	}
+
+	// 本表用于匹配的字段和关联表中对应的字段
+	localKey, targetKey := ts.PrimaryInt64Key(), rel.foreignKey
+	if rel.kind == RelationBelongsTo {
+		localKey, targetKey = rel.foreignKey, target.PrimaryInt64Key()
+	}
+	if _, ok := ts.GetFieldIndex(localKey); !ok {
+		return fmt.Errorf("column %s not found in table[%s]", localKey, ts.tableName)
+	}
+	if _, ok := target.GetFieldIndex(targetKey); !ok {
+		return fmt.Errorf("column %s not found in table[%s]", targetKey, target.tableName)
+	}
+
+	keys := []interface{}{}
+	seen := make(map[string]bool)
+	for _, elem := range elems {
+		field, ok := ts.fieldValue(elem, localKey, false)
+		if !ok || field.IsZero() {
+			continue
+		}
+		if !seen[key_string(field.Interface())] {
+			seen[key_string(field.Interface())] = true
+			keys = append(keys, field.Interface())
+		}
+	}
+
+	children := reflect.New(reflect.SliceOf(reflect.PointerTo(rel.target)))
+	if len(keys) > 0 {
+		criteria := NewCriteria().Where(targetKey+" IN "+SqlInValues(len(keys)), keys...)
+		statement, err := criteria.toSql(target)
+		if err != nil {
+			return err
+		}
+		err = dao.Read(func(conn sqlx.Queryer) error {
+			rows, err := conn.Query(statement, criteria.Args()...)
+			if err != nil {
+				return err
+			}
+			return target.scanAll(rows, children.Interface())
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// 按关联键分组
+	groups := make(map[string][]reflect.Value)
+	for i := 0; i < children.Elem().Len(); i++ {
+		child := children.Elem().Index(i)
+		field, ok := target.fieldValue(child.Elem(), targetKey, false)
+		if !ok {
+			continue
+		}
+		k := key_string(field.Interface())
+		groups[k] = append(groups[k], child)
+	}
+
+	for _, elem := range elems {
+		field, ok := ts.fieldValue(elem, localKey, false)
+		if !ok {
+			continue
+		}
+		if target, ok := field_by_index(elem, rel.index, true); ok {
+			assign_relation(target, groups[key_string(field.Interface())])
+		}
+	}
+
+	if rest != "" {
+		loaded := make([]reflect.Value, 0, children.Elem().Len())
+		for i := 0; i < children.Elem().Len(); i++ {
+			loaded = append(loaded, children.Elem().Index(i).Elem())
+		}
+		if err := dao.preload(target, loaded, rest); err != nil {
+			return err
+		}
+		// 结构体值类型的关联字段是复制的, 需要重新赋值
+		for _, elem := range elems {
+			field, ok := ts.fieldValue(elem, localKey, false)
+			if !ok {
+				continue
+			}
+			if target, ok := field_by_index(elem, rel.index, true); ok {
+				assign_relation(target, groups[key_string(field.Interface())])
+			}
+		}
+	}
+	return nil
+}
+
+// assign_relation 将关联模型指针赋值给字段, 字段可以是 T、*T、[]T、[]*T
+func assign_relation(field reflect.Value, children []reflect.Value) {
+	ft := field.Type()
+	if ft.Kind() == reflect.Slice {
+		slice := reflect.MakeSlice(ft, 0, len(children))
+		for _, child := range children {
+			if ft.Elem().Kind() == reflect.Ptr {
+				slice = reflect.Append(slice, child)
+			} else {
+				slice = reflect.Append(slice, child.Elem())
+			}
+		}
+		field.Set(slice)
+		return
+	}
+	if len(children) == 0 {
+		field.Set(reflect.Zero(ft))
+		return
+	}
+	if ft.Kind() == reflect.Ptr {
+		field.Set(children[0])
+	} else {
+		field.Set(children[0].Elem())
+	}
+}
@@ -0,0 +1,298 @@
+package rdbms
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	register_tag_option("search")
+}
+
+// SearchOptions 全文检索选项
+type SearchOptions struct {
+	Columns        []string  // 限定检索的字段, 为空时检索所有 search 字段
+	Criteria       *Criteria // 主表的过滤条件、分页和关联加载, 未指定排序时按相关度排序
+	HighlightOpen  string    // 高亮开始标记, 默认 <b>
+	HighlightClose string    // 高亮结束标记, 默认 </b>
+	SnippetTokens  int       // 摘要的最大词数, 默认 16
+}
+
+// SearchHit 检索结果的相关度和摘要, 与填充的模型顺序一致
+type SearchHit struct {
+	Rank       float64           // bm25 相关度, 越小越相关
+	Snippets   map[string]string // key: 检索字段, value: 摘要
+	Highlights map[string]string // key: 检索字段, value: 高亮后的全文
+}
+
+// search_table_name 全文检索表名
+func search_table_name(ts *TableSpec) string {
+	return ts.tableName + "_fts"
+}
+
+// generateSearchTableQuery 生成外部内容的 FTS5 表, 使用主表的 rowid 关联
+func generateSearchTableQuery(ts *TableSpec) string {
+	options := strings.Join(ts.searchColumns, ", ")
+	options += fmt.Sprintf(", content='%s'", ts.tableName)
+	if ts.searchTokenizer != "" {
+		options += fmt.Sprintf(", tokenize='%s'", strings.ReplaceAll(ts.searchTokenizer, "'", "''"))
+	}
+	return fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts5(%s)", search_table_name(ts), options)
+}
+
+// generateSearchTriggerQueries 生成同步主表变更的触发器
+func generateSearchTriggerQueries(ts *TableSpec) []string {
+	fts := search_table_name(ts)
+	columns := strings.Join(ts.searchColumns, ", ")
+	values := func(prefix string) string {
+		result := make([]string, 0, len(ts.searchColumns))
+		for _, column := range ts.searchColumns {
+			result = append(result, prefix+"."+column)
+		}
+		return strings.Join(result, ", ")
+	}
+	insert := fmt.Sprintf("INSERT INTO %s(rowid, %s) VALUES (new.rowid, %s);", fts, columns, values("new"))
+	remove := fmt.Sprintf("INSERT INTO %s(%s, rowid, %s) VALUES ('delete', old.rowid, %s);", fts, fts, columns, values("old"))
+	return []string{
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %s_ai AFTER INSERT ON %s BEGIN %s END", fts, ts.tableName, insert),
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %s_ad AFTER DELETE ON %s BEGIN %s END", fts, ts.tableName, remove),
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %s_au AFTER UPDATE ON %s BEGIN %s %s END", fts, ts.tableName, remove, insert),
+	}
+}
+
+// ensure_search_index 创建或更新全文检索表和触发器, 新建时从主表重建索引; 检索字段变化时重新创建
+func ensure_search_index(writer *sqlx.DB, ts *TableSpec) error {
+	if err := check_main_table(ts.tableName, "search"); err != nil {
+		return err
+	}
+	fts := search_table_name(ts)
+	createSql := generateSearchTableQuery(ts)
+	var existing string
+	err := writer.Get(&existing, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", fts)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if existing == createSql {
+		for _, statement := range generateSearchTriggerQueries(ts) {
+			if _, err := writer.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tx, err := writer.Beginx()
+	if err != nil {
+		return err
+	}
+	statements := []string{}
+	if existing != "" {
+		log.Printf("search columns of table[%s] changed, rebuild %s\n", ts.tableName, fts)
+		statements = append(statements,
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s_ai", fts),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s_ad", fts),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s_au", fts),
+			fmt.Sprintf("DROP TABLE %s", fts),
+		)
+	}
+	statements = append(statements, createSql)
+	statements = append(statements, generateSearchTriggerQueries(ts)...)
+	statements = append(statements, fmt.Sprintf("INSERT INTO %s(%s) VALUES ('rebuild')", fts, fts))
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("create search index of table[%s] failed: %w", ts.tableName, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ensure_search_indexes 在写协程中为带检索字段的表创建全文检索表
+func (ds *SqliteDataSource) ensure_search_indexes(models ...ITable) error {
+	for _, model := range models {
+		ts, err := ds.getModelSpec(model)
+		if err != nil || len(ts.searchColumns) == 0 {
+			continue
+		}
+		task := SqlTask{
+			Do: func(writer *sqlx.DB) error {
+				return ensure_search_index(writer, ts)
+			},
+			Result: make(chan SqlResult, 1),
+		}
+		ds.enqueue(task)
+		result := <-task.Result
+		task.Close()
+		if result.Err != nil {
+			return fmt.Errorf("failed to create search index of table[%s]: %w", ts.tableName, result.Err)
+		}
+	}
+	return nil
+}
+
+// Search 全文检索, query 为 FTS5 查询语法, 例如 "phone" 或 "phone AND case"
+func (dao *SqliteDao) Search(emptyTableSlice interface{}, query string, opts *SearchOptions) ([]SearchHit, error) {
+	if opts == nil {
+		opts = &SearchOptions{}
+	}
+	criteria := opts.Criteria
+	if criteria == nil {
+		criteria = NewCriteria()
+	}
+	if criteria.err != nil {
+		return nil, criteria.err
+	}
+	ts, err := dao.ds.getModelSpec(emptyTableSlice)
+	if err != nil {
+		return nil, err
+	}
+	if len(ts.searchColumns) == 0 {
+		return nil, fmt.Errorf("table[%s] has no search columns", ts.tableName)
+	}
+	for _, column := range opts.Columns {
+		if !contains_string(ts.searchColumns, column) {
+			return nil, fmt.Errorf("column %s of table[%s] is not searchable", column, ts.tableName)
+		}
+	}
+
+	statement, args := generateSearchQuery(ts, query, opts, criteria)
+	sliceValue := reflect.ValueOf(emptyTableSlice)
+	if sliceValue.Kind() != reflect.Ptr || sliceValue.Elem().Kind() != reflect.Slice {
+		return nil, fmt.Errorf("emptyTableSlice must be a pointer to a slice")
+	}
+	var hits []SearchHit
+	err = dao.Read(func(conn sqlx.Queryer) error {
+		rows, err := conn.Query(statement, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		slice := reflect.MakeSlice(sliceValue.Elem().Type(), 0, 0)
+		elemType := slice.Type().Elem()
+		isPtr := elemType.Kind() == reflect.Ptr
+		if isPtr {
+			elemType = elemType.Elem()
+		}
+		hits = []SearchHit{}
+		for rows.Next() {
+			hit := SearchHit{Snippets: make(map[string]string), Highlights: make(map[string]string)}
+			snippets := make([]string, len(ts.searchColumns))
+			highlights := make([]string, len(ts.searchColumns))
+			extras := map[string]interface{}{"__rank": &hit.Rank}
+			for i := range ts.searchColumns {
+				extras[fmt.Sprintf("__snippet_%d", i)] = &snippets[i]
+				extras[fmt.Sprintf("__highlight_%d", i)] = &highlights[i]
+			}
+			elem := reflect.New(elemType)
+			if err := ts.scanRowExtras(rows, columns, elem.Elem(), extras); err != nil {
+				return err
+			}
+			for i, column := range ts.searchColumns {
+				hit.Snippets[column] = snippets[i]
+				hit.Highlights[column] = highlights[i]
+			}
+			if isPtr {
+				slice = reflect.Append(slice, elem)
+			} else {
+				slice = reflect.Append(slice, elem.Elem())
+			}
+			hits = append(hits, hit)
+		}
+		sliceValue.Elem().Set(slice)
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(criteria.preloads) > 0 {
+		if err := dao.TablePreload(emptyTableSlice, criteria.preloads...); err != nil {
+			return nil, err
+		}
+	}
+	return hits, nil
+}
+
+// generateSearchQuery 在子查询中检索全文索引, 主表的过滤条件不会与检索表的字段冲突
+func generateSearchQuery(ts *TableSpec, query string, opts *SearchOptions, criteria *Criteria) (string, []interface{}) {
+	openTag, closeTag := opts.HighlightOpen, opts.HighlightClose
+	if openTag == "" && closeTag == "" {
+		openTag, closeTag = "<b>", "</b>"
+	}
+	tokens := opts.SnippetTokens
+	if tokens <= 0 {
+		tokens = 16
+	}
+	// 限定字段使用 FTS5 的字段过滤语法 {a b} : (query)
+	if len(opts.Columns) > 0 {
+		query = fmt.Sprintf("{%s} : (%s)", strings.Join(opts.Columns, " "), query)
+	}
+
+	fts := search_table_name(ts)
+	// 子查询的字段都以 __ 开头, 过滤条件中未限定表名的字段只会解析到主表; 检索字段为 NULL 时摘要为空字符串
+	selects := []string{"rowid AS __rowid", fmt.Sprintf("bm25(%s) AS __rank", fts)}
+	args := []interface{}{}
+	for i := range ts.searchColumns {
+		selects = append(selects,
+			fmt.Sprintf("coalesce(snippet(%s, %d, ?, ?, '...', %d), '') AS __snippet_%d", fts, i, tokens, i),
+			fmt.Sprintf("coalesce(highlight(%s, %d, ?, ?), '') AS __highlight_%d", fts, i, i),
+		)
+		args = append(args, openTag, closeTag, openTag, closeTag)
+	}
+	args = append(args, query)
+
+	columns := make([]string, 0, len(ts.dbTags))
+	for _, dbTag := range ts.dbTags {
+		columns = append(columns, "t."+dbTag)
+	}
+	outer := []string{"s.__rank"}
+	for i := range ts.searchColumns {
+		outer = append(outer, fmt.Sprintf("s.__snippet_%d", i), fmt.Sprintf("s.__highlight_%d", i))
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("SELECT %s, %s FROM %s AS t JOIN (SELECT %s FROM %s WHERE %s MATCH ?) AS s ON t.rowid = s.__rowid",
+		strings.Join(columns, ","), strings.Join(outer, ","), ts.tableName, strings.Join(selects, ", "), fts, fts))
+
+	conditions := make([]string, 0, len(criteria.conditions)+1)
+	for _, condition := range criteria.conditions {
+		conditions = append(conditions, "("+condition+")")
+	}
+	if ts.IsLogicDelete() {
+		conditions = append(conditions, fmt.Sprintf("t.%s = 0", ts.deleteInt64Key))
+	}
+	if len(conditions) > 0 {
+		builder.WriteString(" WHERE ")
+		builder.WriteString(strings.Join(conditions, " AND "))
+	}
+	args = append(args, criteria.args...)
+	if criteria.orderBy != "" {
+		builder.WriteString(" ORDER BY " + criteria.orderBy)
+	} else {
+		builder.WriteString(" ORDER BY s.__rank")
+	}
+	if criteria.limit > 0 {
+		builder.WriteString(fmt.Sprintf(" LIMIT %d", criteria.limit))
+		if criteria.offset > 0 {
+			builder.WriteString(fmt.Sprintf(" OFFSET %d", criteria.offset))
+		}
+	}
+	return builder.String(), args
+}
+
+func contains_string(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,913 @@
+package rdbms
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 分片: 同一组表分布在 N 个数据库文件中, 每个分片有自己的写协程, 写入吞吐随分片数增加, 例如:
+//
+//	sharded, _ := rdbms.NewShardedDataSource("orders", rdbms.ShardConfig{
+//		URLTemplate: "sqlite:./data/orders_{shard}.db",
+//		Shards:      4,
+//		Statements:  statements,
+//		Tables:      tables,
+//	})
+//	dao := sharded.NewDao()
+//
+// 模型按 shard 选项声明的分片字段分片, 没有分片字段时按主键分片; 分片数量确定后不能修改;
+// 自增主键的表没有分片字段时插入轮询分配分片, 各分片的自增主键从分片序号左移 40 位开始, 按主键读写时直接定位分片,
+// 因此自增主键的表必须使用 AUTOINCREMENT 建表;
+// 不能由主键定位分片的读写在所有分片上并发执行后合并, TableFind 和 Search 按排序字段合并后再分页,
+// 排序只支持字段名加 ASC/DESC; Write、Exec、QueryMap、QueryAll 在每个分片上各执行一次后合并;
+// Read、QueryOne、QueryScalar 的结果无法跨分片合并, 多于一个分片时返回错误, 聚合查询需要逐个分片执行;
+// 关联预加载在父模型所在的分片上执行, 关联的子表需要与父表使用相同的分片字段;
+// 自增主键的表不能同时时间分区, 分区的主键从分区序号左移 32 位开始, 会与其他分片的主键重复
+
+const (
+	shardPlaceholder = "{shard}"
+	shardIdBits      = 40 // 自增主键中分片内序号的位数
+)
+
+// ShardConfig 分片配置
+type ShardConfig struct {
+	URLTemplate string   // 数据库地址模板, {shard} 替换为分片序号, 从 0 开始
+	Shards      int      // 分片数量
+	Statements  []string // 每个分片打开时执行的建表语句
+	Tables      []ITable // 表模型
+}
+
+// ShardedDataSource 分片数据源, 通过 NewDao 创建的 DAO 按分片字段路由
+type ShardedDataSource struct {
+	id     string
+	shards []*SqliteDataSource
+	next   atomic.Uint64 // 轮询分配插入的分片
+}
+
+var (
+	shardedSources     = []*ShardedDataSource{}
+	shardedSourcesLock sync.Mutex
+)
+
+func init() {
+	register_tag_option("shard")
+}
+
+// NewShardedDataSource 打开所有分片, rdbms.Close 时一并关闭
+func NewShardedDataSource(id string, config ShardConfig) (*ShardedDataSource, error) {
+	if !strings.Contains(config.URLTemplate, shardPlaceholder) {
+		return nil, fmt.Errorf("url template must contain %s", shardPlaceholder)
+	}
+	if config.Shards < 1 {
+		return nil, fmt.Errorf("shards must be >= 1")
+	}
+	s := &ShardedDataSource{id: id}
+	for i := 0; i < config.Shards; i++ {
+		ds, err := s.open(i, config)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.shards = append(s.shards, ds)
+	}
+	shardedSourcesLock.Lock()
+	shardedSources = append(shardedSources, s)
+	shardedSourcesLock.Unlock()
+	return s, nil
+}
+
+func (s *ShardedDataSource) open(shard int, config ShardConfig) (*SqliteDataSource, error) {
+	dbUrl, err := parse_db_url(strings.ReplaceAll(config.URLTemplate, shardPlaceholder, strconv.Itoa(shard)))
+	if err != nil {
+		return nil, err
+	}
+	opened, err := open_data_source(fmt.Sprintf("%s_%d", s.id, shard), dbUrl, config.Statements, config.Tables)
+	if err != nil {
+		return nil, fmt.Errorf("open shard[%d] failed: %w", shard, err)
+	}
+	ds, ok := opened.(*SqliteDataSource)
+	if !ok {
+		opened.Close()
+		return nil, fmt.Errorf("shard[%d] is not a sqlite data source", shard)
+	}
+	ds.sharded = true
+	if err := ds.run_writer(func(writer *sqlx.DB) error {
+		return seed_shard_sequences(writer, ds, shard, config.Tables)
+	}); err != nil {
+		ds.Close()
+		return nil, fmt.Errorf("open shard[%d] failed: %w", shard, err)
+	}
+	return ds, nil
+}
+
+// seed_shard_sequences 自增主键从分片序号左移 40 位开始, 各分片的主键不重复, 也可以由主键反查分片
+func seed_shard_sequences(writer *sqlx.DB, ds *SqliteDataSource, shard int, tables []ITable) error {
+	base := int64(shard) << shardIdBits
+	for _, model := range tables {
+		ts, err := ds.getModelSpec(model)
+		if err != nil || !ts.autoIncrement {
+			continue
+		}
+		schema, name := split_table_name(ts.tableName)
+		if schema != "" {
+			schema += "."
+		}
+		var createSql string
+		if err := writer.Get(&createSql, fmt.Sprintf("SELECT sql FROM %ssqlite_master WHERE type = 'table' AND name = ?", schema), name); err != nil {
+			return fmt.Errorf("table[%s] not found: %w", ts.tableName, err)
+		}
+		if !strings.Contains(strings.ToUpper(createSql), "AUTOINCREMENT") {
+			return fmt.Errorf("table[%s] must be created with AUTOINCREMENT for sharding", ts.tableName)
+		}
+		sequence := schema + "sqlite_sequence"
+		statements := []string{
+			fmt.Sprintf("INSERT INTO %s (name, seq) SELECT ?, ? WHERE NOT EXISTS (SELECT 1 FROM %s WHERE name = ?)", sequence, sequence),
+			fmt.Sprintf("UPDATE %s SET seq = ? WHERE name = ? AND seq < ?", sequence),
+		}
+		if _, err := writer.Exec(statements[0], name, base, name); err != nil {
+			return err
+		}
+		if _, err := writer.Exec(statements[1], base, name, base); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ShardedDataSource) Id() string {
+	return s.id
+}
+
+// Shards 返回各个分片的数据源, 用于开启审计、维护、备份等按分片执行的操作
+func (s *ShardedDataSource) Shards() []IDataSource {
+	shards := make([]IDataSource, 0, len(s.shards))
+	for _, ds := range s.shards {
+		shards = append(shards, ds)
+	}
+	return shards
+}
+
+// NewDao 创建按分片路由的 DAO
+func (s *ShardedDataSource) NewDao(opts ...DaoOption) IDao {
+	daos := make([]IDao, 0, len(s.shards))
+	for _, ds := range s.shards {
+		daos = append(daos, ds.NewDao(opts...))
+	}
+	return &shardedDao{source: s, daos: daos}
+}
+
+// Close 关闭所有分片
+func (s *ShardedDataSource) Close() error {
+	var errs []error
+	for _, ds := range s.shards {
+		if err := ds.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.shards = nil
+	return errors.Join(errs...)
+}
+
+// first_shard 返回第一个分片, 用于读取各分片相同的表结构; 数据源关闭后返回错误
+func (s *ShardedDataSource) first_shard() (*SqliteDataSource, error) {
+	if len(s.shards) == 0 {
+		return nil, fmt.Errorf("sharded data source[%s] is closed", s.id)
+	}
+	return s.shards[0], nil
+}
+
+func close_sharded_sources() {
+	shardedSourcesLock.Lock()
+	sources := shardedSources
+	shardedSources = []*ShardedDataSource{}
+	shardedSourcesLock.Unlock()
+	for _, source := range sources {
+		source.Close()
+	}
+}
+
+func (s *ShardedDataSource) hash_shard(key string) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int(h.Sum64() % uint64(len(s.shards)))
+}
+
+// shard_key_string 分片使用的键值, 复合主键按顺序拼接
+func shard_key_string(key interface{}) string {
+	if parts, ok := key.(Key); ok {
+		values := make([]string, 0, len(parts))
+		for _, part := range parts {
+			values = append(values, key_string(part))
+		}
+		return strings.Join(values, "\x00")
+	}
+	return key_string(key)
+}
+
+// model_shard 返回模型所在的分片, 插入没有分片字段的自增主键模型时轮询分配
+func (s *ShardedDataSource) model_shard(ts *TableSpec, model ITable, insert bool) (int, error) {
+	if ts.shardKey != "" {
+		v, err := model_value(model)
+		if err != nil {
+			return 0, err
+		}
+		field, ok := ts.fieldValue(v, ts.shardKey, false)
+		for ok && field.Kind() == reflect.Ptr {
+			ok = !field.IsNil()
+			if ok {
+				field = field.Elem()
+			}
+		}
+		if !ok {
+			return 0, fmt.Errorf("shard key %s of table[%s] is nil", ts.shardKey, ts.tableName)
+		}
+		return s.hash_shard(key_string(field.Interface())), nil
+	}
+	if len(ts.primaryKeys) == 0 {
+		return 0, fmt.Errorf("table[%s] has no primary key or shard key", ts.tableName)
+	}
+	if ts.autoIncrement {
+		if insert {
+			return int((s.next.Add(1) - 1) % uint64(len(s.shards))), nil
+		}
+		groups, _ := s.key_shards(ts, ts.getModelKey(model)[:1])
+		for shard := range groups {
+			return shard, nil
+		}
+		return 0, fmt.Errorf("shard of model %v not found", model)
+	}
+	return s.hash_shard(shard_key_string(ts.getModelKey(model))), nil
+}
+
+// key_shards 按主键分组到分片, 不存在的分片忽略; 主键不能定位分片时返回 false
+func (s *ShardedDataSource) key_shards(ts *TableSpec, keys []interface{}) (map[int][]interface{}, bool) {
+	groups := make(map[int][]interface{})
+	for _, key := range keys {
+		var shard int
+		switch {
+		case ts.autoIncrement:
+			id, ok := int_key(key)
+			if !ok {
+				return nil, false
+			}
+			shard = int(id >> shardIdBits)
+			if shard < 0 || shard >= len(s.shards) {
+				continue
+			}
+		case ts.shardKey == "" || len(ts.primaryKeys) == 1 && ts.primaryKeys[0] == ts.shardKey:
+			shard = s.hash_shard(shard_key_string(key))
+		default:
+			return nil, false
+		}
+		groups[shard] = append(groups[shard], key)
+	}
+	return groups, true
+}
+
+// all_shards 所有分片使用同一组主键
+func (s *ShardedDataSource) all_shards(keys []interface{}) map[int][]interface{} {
+	groups := make(map[int][]interface{}, len(s.shards))
+	for i := range s.shards {
+		groups[i] = keys
+	}
+	return groups
+}
+
+// fan_out 在多个分片上并发执行 fn, 返回第一个错误
+func fan_out(shards []int, fn func(shard int) error) error {
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func(i, shard int) {
+			defer wg.Done()
+			errs[i] = fn(shard)
+		}(i, shard)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sorted_shards[V any](groups map[int]V) []int {
+	shards := make([]int, 0, len(groups))
+	for shard := range groups {
+		shards = append(shards, shard)
+	}
+	sort.Ints(shards)
+	return shards
+}
+
+// shardedDao 按分片路由的 DAO, daos 与分片一一对应
+type shardedDao struct {
+	source *ShardedDataSource
+	daos   []IDao
+}
+
+func (dao *shardedDao) spec(model interface{}) (*TableSpec, error) {
+	first, err := dao.source.first_shard()
+	if err != nil {
+		return nil, err
+	}
+	return first.getModelSpec(model)
+}
+
+func (dao *shardedDao) getModelSpec(model interface{}) (*TableSpec, error) {
+	return dao.spec(model)
+}
+
+func (dao *shardedDao) all() []int {
+	shards := make([]int, len(dao.daos))
+	for i := range shards {
+		shards[i] = i
+	}
+	return shards
+}
+
+func (dao *shardedDao) DataSourceId() string {
+	return dao.source.id
+}
+
+func (dao *shardedDao) Rollback() error {
+	return nil
+}
+
+func (dao *shardedDao) Commit() error {
+	return nil
+}
+
+func (dao *shardedDao) Close() error {
+	return nil
+}
+
+func (dao *shardedDao) Create(statement string) error {
+	for _, d := range dao.daos {
+		if err := d.Create(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// group_models 按分片分组模型, 保持模型的相对顺序
+func (dao *shardedDao) group_models(models []ITable, insert bool) (map[int][]ITable, error) {
+	groups := make(map[int][]ITable)
+	for _, model := range models {
+		ts, err := dao.spec(model)
+		if err != nil {
+			return nil, err
+		}
+		shard, err := dao.source.model_shard(ts, model, insert)
+		if err != nil {
+			return nil, err
+		}
+		groups[shard] = append(groups[shard], model)
+	}
+	return groups, nil
+}
+
+// TableInsert 返回的主键按分片顺序排列, 自增主键同时回填到模型
+func (dao *shardedDao) TableInsert(models ...ITable) ([]int64, error) {
+	groups, err := dao.group_models(models, true)
+	if err != nil {
+		return nil, err
+	}
+	shards := sorted_shards(groups)
+	results := make([][]int64, len(dao.daos))
+	err = fan_out(shards, func(shard int) (err error) {
+		results[shard], err = dao.daos[shard].TableInsert(groups[shard]...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(models))
+	for _, shard := range shards {
+		ids = append(ids, results[shard]...)
+	}
+	return ids, nil
+}
+
+func (dao *shardedDao) TableUpdate(models ...ITable) (int64, error) {
+	groups, err := dao.group_models(models, false)
+	if err != nil {
+		return 0, err
+	}
+	var count atomic.Int64
+	err = fan_out(sorted_shards(groups), func(shard int) error {
+		affected, err := dao.daos[shard].TableUpdate(groups[shard]...)
+		count.Add(affected)
+		return err
+	})
+	return count.Load(), err
+}
+
+func (dao *shardedDao) TableUpdateColumns(model ITable, columns ...string) (int64, error) {
+	ts, err := dao.spec(model)
+	if err != nil {
+		return 0, err
+	}
+	shard, err := dao.source.model_shard(ts, model, false)
+	if err != nil {
+		return 0, err
+	}
+	return dao.daos[shard].TableUpdateColumns(model, columns...)
+}
+
+func (dao *shardedDao) TableDelete(tableName string, keys ...interface{}) (int64, error) {
+	first, err := dao.source.first_shard()
+	if err != nil {
+		return 0, err
+	}
+	ts := first.GetTableSpec(tableName)
+	if ts == nil {
+		return 0, fmt.Errorf("table[%s] spec not found", tableName)
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	groups, ok := dao.source.key_shards(ts, keys)
+	if !ok {
+		groups = dao.source.all_shards(keys)
+	}
+	var count atomic.Int64
+	err = fan_out(sorted_shards(groups), func(shard int) error {
+		affected, err := dao.daos[shard].TableDelete(tableName, groups[shard]...)
+		count.Add(affected)
+		return err
+	})
+	return count.Load(), err
+}
+
+// TableGet 主键能定位分片时只查询一个分片, 否则查询所有分片, 都没有时返回 sql.ErrNoRows
+func (dao *shardedDao) TableGet(emptyTableModel interface{}, key interface{}) error {
+	ts, err := dao.spec(emptyTableModel)
+	if err != nil {
+		return err
+	}
+	groups, ok := dao.source.key_shards(ts, []interface{}{key})
+	if !ok {
+		groups = dao.source.all_shards([]interface{}{key})
+	}
+	shards := sorted_shards(groups)
+	if len(shards) == 0 {
+		return sql.ErrNoRows
+	}
+	if len(shards) == 1 {
+		return dao.daos[shards[0]].TableGet(emptyTableModel, key)
+	}
+	target := reflect.ValueOf(emptyTableModel)
+	if target.Kind() != reflect.Ptr {
+		return fmt.Errorf("emptyTableModel must be a pointer")
+	}
+	found := make([]reflect.Value, len(dao.daos))
+	err = fan_out(shards, func(shard int) error {
+		model := reflect.New(target.Elem().Type())
+		err := dao.daos[shard].TableGet(model.Interface(), key)
+		if err == nil {
+			found[shard] = model
+		} else if errors.Is(err, sql.ErrNoRows) {
+			err = nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, model := range found {
+		if model.IsValid() {
+			target.Elem().Set(model.Elem())
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// collect 在多个分片上填充各自的切片, 按分片顺序合并到 emptyTableSlice
+func (dao *shardedDao) collect(emptyTableSlice interface{}, shards []int, fn func(shard int, slice interface{}) error) error {
+	sliceValue := reflect.ValueOf(emptyTableSlice)
+	if sliceValue.Kind() != reflect.Ptr || sliceValue.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("emptyTableSlice must be a pointer to a slice")
+	}
+	results := make([]reflect.Value, len(dao.daos))
+	err := fan_out(shards, func(shard int) error {
+		results[shard] = reflect.New(sliceValue.Elem().Type())
+		return fn(shard, results[shard].Interface())
+	})
+	if err != nil {
+		return err
+	}
+	slice := sliceValue.Elem()
+	for _, shard := range shards {
+		slice = reflect.AppendSlice(slice, results[shard].Elem())
+	}
+	sliceValue.Elem().Set(slice)
+	return nil
+}
+
+func (dao *shardedDao) TableSelect(emptyTableSlice interface{}, keys ...interface{}) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("keys is empty")
+	}
+	ts, err := dao.spec(emptyTableSlice)
+	if err != nil {
+		return err
+	}
+	groups, ok := dao.source.key_shards(ts, keys)
+	if !ok {
+		groups = dao.source.all_shards(keys)
+	}
+	return dao.collect(emptyTableSlice, sorted_shards(groups), func(shard int, slice interface{}) error {
+		return dao.daos[shard].TableSelect(slice, groups[shard]...)
+	})
+}
+
+// shard_criteria 每个分片读取前 offset+limit 条, 合并排序后再分页
+func shard_criteria(criteria *Criteria) *Criteria {
+	shard := *criteria
+	if criteria.limit > 0 {
+		shard.limit = criteria.offset + criteria.limit
+	}
+	shard.offset = 0
+	return &shard
+}
+
+func (dao *shardedDao) TableFind(emptyTableSlice interface{}, criteria *Criteria) error {
+	if criteria == nil {
+		criteria = NewCriteria()
+	}
+	if criteria.err != nil {
+		return criteria.err
+	}
+	ts, err := dao.spec(emptyTableSlice)
+	if err != nil {
+		return err
+	}
+	orders, err := parse_order_by(ts, criteria.orderBy)
+	if err != nil {
+		return err
+	}
+	sliceValue := reflect.ValueOf(emptyTableSlice)
+	if sliceValue.Kind() != reflect.Ptr || sliceValue.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("emptyTableSlice must be a pointer to a slice")
+	}
+	merged := reflect.New(sliceValue.Elem().Type())
+	err = dao.collect(merged.Interface(), dao.all(), func(shard int, slice interface{}) error {
+		return dao.daos[shard].TableFind(slice, shard_criteria(criteria))
+	})
+	if err != nil {
+		return err
+	}
+	order := sort_merged(ts, merged.Elem(), orders, nil)
+	slice := sliceValue.Elem()
+	for _, i := range page(order, criteria) {
+		slice = reflect.Append(slice, merged.Elem().Index(i))
+	}
+	sliceValue.Elem().Set(slice)
+	return nil
+}
+
+func (dao *shardedDao) TablePreload(models interface{}, relations ...string) error {
+	ts, err := dao.spec(models)
+	if err != nil {
+		return err
+	}
+	elems, err := model_elems(models)
+	if err != nil {
+		return err
+	}
+	groups := make(map[int]reflect.Value)
+	for _, elem := range elems {
+		shard, err := dao.source.model_shard(ts, elem.Addr().Interface().(ITable), false)
+		if err != nil {
+			return err
+		}
+		group, ok := groups[shard]
+		if !ok {
+			group = reflect.MakeSlice(reflect.SliceOf(elem.Addr().Type()), 0, 0)
+		}
+		groups[shard] = reflect.Append(group, elem.Addr())
+	}
+	return fan_out(sorted_shards(groups), func(shard int) error {
+		group := reflect.New(groups[shard].Type())
+		group.Elem().Set(groups[shard])
+		return dao.daos[shard].TablePreload(group.Interface(), relations...)
+	})
+}
+
+// Search 在所有分片检索后合并, 未指定排序时按相关度排序
+func (dao *shardedDao) Search(emptyTableSlice interface{}, query string, opts *SearchOptions) ([]SearchHit, error) {
+	if opts == nil {
+		opts = &SearchOptions{}
+	}
+	criteria := opts.Criteria
+	if criteria == nil {
+		criteria = NewCriteria()
+	}
+	ts, err := dao.spec(emptyTableSlice)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := parse_order_by(ts, criteria.orderBy)
+	if err != nil {
+		return nil, err
+	}
+	sliceValue := reflect.ValueOf(emptyTableSlice)
+	if sliceValue.Kind() != reflect.Ptr || sliceValue.Elem().Kind() != reflect.Slice {
+		return nil, fmt.Errorf("emptyTableSlice must be a pointer to a slice")
+	}
+	shardOpts := *opts
+	shardOpts.Criteria = shard_criteria(criteria)
+	results := make([][]SearchHit, len(dao.daos))
+	merged := reflect.New(sliceValue.Elem().Type())
+	err = dao.collect(merged.Interface(), dao.all(), func(shard int, slice interface{}) (err error) {
+		results[shard], err = dao.daos[shard].Search(slice, query, &shardOpts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	hits := []SearchHit{}
+	for _, shardHits := range results {
+		hits = append(hits, shardHits...)
+	}
+	order := sort_merged(ts, merged.Elem(), orders, hits)
+	slice := sliceValue.Elem()
+	pageHits := []SearchHit{}
+	for _, i := range page(order, criteria) {
+		slice = reflect.Append(slice, merged.Elem().Index(i))
+		pageHits = append(pageHits, hits[i])
+	}
+	sliceValue.Elem().Set(slice)
+	return pageHits, nil
+}
+
+// AuditHistory 主键不能定位分片时合并所有分片的审计记录, 按时间排序
+func (dao *shardedDao) AuditHistory(emptyTableModel interface{}, key interface{}) ([]AuditRecord, error) {
+	ts, err := dao.spec(emptyTableModel)
+	if err != nil {
+		return nil, err
+	}
+	groups, ok := dao.source.key_shards(ts, []interface{}{key})
+	if !ok {
+		groups = dao.source.all_shards([]interface{}{key})
+	}
+	results := make([][]AuditRecord, len(dao.daos))
+	err = fan_out(sorted_shards(groups), func(shard int) (err error) {
+		results[shard], err = dao.daos[shard].AuditHistory(emptyTableModel, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	records := []AuditRecord{}
+	for _, shardRecords := range results {
+		records = append(records, shardRecords...)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, nil
+}
+
+func (dao *shardedDao) AuditAsOf(emptyTableModel interface{}, key interface{}, at time.Time) error {
+	ts, err := dao.spec(emptyTableModel)
+	if err != nil {
+		return err
+	}
+	groups, ok := dao.source.key_shards(ts, []interface{}{key})
+	if !ok {
+		groups = dao.source.all_shards([]interface{}{key})
+	}
+	for _, shard := range sorted_shards(groups) {
+		err := dao.daos[shard].AuditAsOf(emptyTableModel, key, at)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// Read 只有一个分片时直接执行; 跨多个分片时 fn 的结果无法合并, 返回错误,
+// 需要逐个分片读取时使用 ShardedDataSource.Shards()
+func (dao *shardedDao) Read(fn func(conn sqlx.Queryer) error) error {
+	if err := dao.single_shard(); err != nil {
+		return err
+	}
+	return dao.daos[0].Read(fn)
+}
+
+// single_shard 多于一个分片时返回错误, 用于结果无法合并的读取
+func (dao *shardedDao) single_shard() error {
+	if len(dao.daos) == 0 {
+		return fmt.Errorf("sharded data source[%s] is closed", dao.source.id)
+	}
+	if len(dao.daos) > 1 {
+		return fmt.Errorf("data source[%s] has %d shards, results cannot be merged across shards, use QueryAll/QueryMap or read each shard through ShardedDataSource.Shards()", dao.source.id, len(dao.daos))
+	}
+	return nil
+}
+
+// Write 在每个分片上依次执行 fn, 各分片分别提交
+func (dao *shardedDao) Write(fn func(conn sqlx.Ext) error) error {
+	for _, d := range dao.daos {
+		if err := d.Write(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Exec 在所有分片上执行, 影响行数相加
+func (dao *shardedDao) Exec(statement string, args ...interface{}) (SqlResult, error) {
+	results := make([]SqlResult, len(dao.daos))
+	err := fan_out(dao.all(), func(shard int) (err error) {
+		results[shard], err = dao.daos[shard].Exec(statement, args...)
+		return err
+	})
+	merged := SqlResult{}
+	for _, result := range results {
+		merged.RowsAffected += result.RowsAffected
+		merged.LastInsertID = append(merged.LastInsertID, result.LastInsertID...)
+	}
+	merged.Err = err
+	return merged, err
+}
+
+// QueryMap 在所有分片上查询, 按分片顺序合并
+func (dao *shardedDao) QueryMap(statement string, args ...interface{}) ([]map[string]interface{}, error) {
+	results := make([][]map[string]interface{}, len(dao.daos))
+	err := fan_out(dao.all(), func(shard int) (err error) {
+		results[shard], err = dao.daos[shard].QueryMap(statement, args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	merged := []map[string]interface{}{}
+	for _, result := range results {
+		merged = append(merged, result...)
+	}
+	return merged, nil
+}
+
+func (dao *shardedDao) Consistent(consistency Consistency) IDao {
+	daos := make([]IDao, 0, len(dao.daos))
+	for _, d := range dao.daos {
+		daos = append(daos, d.Consistent(consistency))
+	}
+	return &shardedDao{source: dao.source, daos: daos}
+}
+
+// Conn 返回第一个分片的读连接池, 数据源关闭后创建的 DAO 返回 nil
+func (dao *shardedDao) Conn() *sqlx.DB {
+	if len(dao.daos) == 0 {
+		return nil
+	}
+	return dao.daos[0].Conn()
+}
+
+type orderColumn struct {
+	column string
+	desc   bool
+}
+
+// parse_order_by 解析排序, 只支持逗号分隔的字段名加 ASC/DESC
+func parse_order_by(ts *TableSpec, orderBy string) ([]orderColumn, error) {
+	orders := []orderColumn{}
+	if strings.TrimSpace(orderBy) == "" {
+		return orders, nil
+	}
+	for _, part := range strings.Split(orderBy, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("order by %s is not supported across shards", orderBy)
+		}
+		order := orderColumn{column: fields[0]}
+		if _, column, ok := strings.Cut(order.column, "."); ok {
+			order.column = column
+		}
+		if _, ok := ts.dbTagFieldIndexes[order.column]; !ok {
+			return nil, fmt.Errorf("order by %s is not supported across shards", orderBy)
+		}
+		if len(fields) == 2 {
+			switch strings.ToUpper(fields[1]) {
+			case "ASC":
+			case "DESC":
+				order.desc = true
+			default:
+				return nil, fmt.Errorf("order by %s is not supported across shards", orderBy)
+			}
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// sort_merged 返回合并结果排序后的下标; 没有排序字段时按相关度排序, 没有相关度时保持分片顺序
+func sort_merged(ts *TableSpec, slice reflect.Value, orders []orderColumn, hits []SearchHit) []int {
+	order := make([]int, slice.Len())
+	for i := range order {
+		order[i] = i
+	}
+	elem := func(i int) reflect.Value {
+		v := slice.Index(i)
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		return v
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		if len(orders) == 0 {
+			return hits != nil && hits[order[a]].Rank < hits[order[b]].Rank
+		}
+		for _, o := range orders {
+			x, _ := ts.fieldValue(elem(order[a]), o.column, false)
+			y, _ := ts.fieldValue(elem(order[b]), o.column, false)
+			if c := compare_values(x, y); c != 0 {
+				return (c < 0) != o.desc
+			}
+		}
+		return false
+	})
+	return order
+}
+
+// page 按查询条件的 offset 和 limit 截取
+func page(order []int, criteria *Criteria) []int {
+	if criteria.offset >= len(order) {
+		return nil
+	}
+	order = order[criteria.offset:]
+	if criteria.limit > 0 && criteria.limit < len(order) {
+		order = order[:criteria.limit]
+	}
+	return order
+}
+
+// compare_values 比较字段值, 空指针最小, 不支持的类型按字符串比较
+func compare_values(x, y reflect.Value) int {
+	for x.IsValid() && x.Kind() == reflect.Ptr {
+		if x.IsNil() {
+			x = reflect.Value{}
+		} else {
+			x = x.Elem()
+		}
+	}
+	for y.IsValid() && y.Kind() == reflect.Ptr {
+		if y.IsNil() {
+			y = reflect.Value{}
+		} else {
+			y = y.Elem()
+		}
+	}
+	switch {
+	case !x.IsValid() || !y.IsValid():
+		return compare_ordered(boolean_int(x.IsValid()), boolean_int(y.IsValid()))
+	case x.CanInt():
+		return compare_ordered(x.Int(), y.Int())
+	case x.CanUint():
+		return compare_ordered(x.Uint(), y.Uint())
+	case x.CanFloat():
+		return compare_ordered(x.Float(), y.Float())
+	case x.Kind() == reflect.String:
+		return strings.Compare(x.String(), y.String())
+	case x.Kind() == reflect.Bool:
+		return compare_ordered(boolean_int(x.Bool()), boolean_int(y.Bool()))
+	case x.Type() == timeType:
+		return x.Interface().(time.Time).Compare(y.Interface().(time.Time))
+	default:
+		return strings.Compare(fmt.Sprint(x.Interface()), fmt.Sprint(y.Interface()))
+	}
+}
+
+func compare_ordered[T int | int64 | uint64 | float64](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func boolean_int(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,313 @@
+package rdbms
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// 多租户: 每个租户一个数据库文件, 按租户 ID 从地址模板打开, 例如:
+//
+//	router, _ := rdbms.NewTenantRouter(rdbms.TenantConfig{
+//		URLTemplate: "sqlite:./data/tenants/{tenant}.db",
+//		Statements:  statements,
+//		Tables:      tables,
+//	})
+//	dao, err := router.NewDao(rdbms.ContextWithTenant(ctx, "acme"))
+//	defer dao.Close()
+//
+// 租户库在首次使用时打开, 超过 MaxOpen 时关闭最久未使用且没有 DAO 在使用的租户库;
+// DAO 使用完毕后必须 Close, 否则租户库不会被关闭; 路由 Close 后仍在使用的租户库在最后一个 DAO Close 时关闭;
+// Statements 只在租户库文件首次创建时执行, 已有租户库的迁移在 OnOpen 中执行
+
+const tenantPlaceholder = "{tenant}"
+
+// TenantConfig 多租户路由配置
+type TenantConfig struct {
+	URLTemplate string                                    // 数据库地址模板, {tenant} 替换为租户 ID
+	Statements  []string                                  // 租户库文件首次创建时执行的建表语句
+	Tables      []ITable                                  // 租户库的表模型
+	MaxOpen     int                                       // 同时打开的租户库上限, 默认 64
+	OnOpen      func(tenant string, ds IDataSource) error // 租户库每次打开后执行, 例如迁移已有租户库、开启审计
+}
+
+// TenantRouter 按租户 ID 路由到租户自己的数据源
+type TenantRouter struct {
+	config  TenantConfig
+	lock    sync.Mutex
+	tenants map[string]*list.Element // key: 租户 ID, value: lru 中的 *tenantEntry
+	lru     *list.List               // 最近使用的在前
+	opening map[string]*tenantOpening
+	closed  bool
+}
+
+type tenantEntry struct {
+	tenant  string
+	ds      IDataSource
+	refs    int  // 使用中的 DAO 数量
+	retired bool // 路由已关闭, 引用计数归零时关闭
+}
+
+// tenantOpening 同一租户并发打开时, 只打开一次
+type tenantOpening struct {
+	done  chan struct{}
+	entry *tenantEntry
+	err   error
+}
+
+var (
+	tenantRouters     = []*TenantRouter{}
+	tenantRoutersLock sync.Mutex
+)
+
+type tenantKey struct{}
+
+// ContextWithTenant 在 context 中记录租户 ID
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext 读取 context 中的租户 ID
+func TenantFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// NewTenantRouter 创建多租户路由, rdbms.Close 时一并关闭
+func NewTenantRouter(config TenantConfig) (*TenantRouter, error) {
+	if !strings.Contains(config.URLTemplate, tenantPlaceholder) {
+		return nil, fmt.Errorf("url template must contain %s", tenantPlaceholder)
+	}
+	if _, err := parse_db_url(strings.ReplaceAll(config.URLTemplate, tenantPlaceholder, "tenant")); err != nil {
+		return nil, err
+	}
+	if config.MaxOpen <= 0 {
+		config.MaxOpen = 64
+	}
+	router := &TenantRouter{
+		config:  config,
+		tenants: make(map[string]*list.Element),
+		lru:     list.New(),
+		opening: make(map[string]*tenantOpening),
+	}
+	tenantRoutersLock.Lock()
+	tenantRouters = append(tenantRouters, router)
+	tenantRoutersLock.Unlock()
+	return router, nil
+}
+
+// check_tenant_id 租户 ID 会写入文件路径, 只允许小写字母、数字、下划线和中划线;
+// 不区分大小写的文件系统上 Acme 和 acme 是同一个文件, 会被两个数据源同时写入
+func check_tenant_id(tenant string) error {
+	if tenant == "" {
+		return fmt.Errorf("tenant id is empty")
+	}
+	for i := 0; i < len(tenant); i++ {
+		c := tenant[i]
+		if c >= 'A' && c <= 'Z' {
+			return fmt.Errorf("invalid tenant id: %s, upper case letters are not allowed", tenant)
+		}
+		if !is_ident_char(c) && c != '-' {
+			return fmt.Errorf("invalid tenant id: %s", tenant)
+		}
+	}
+	return nil
+}
+
+// NewDao 使用 context 中的租户 ID 创建 DAO, 使用完毕后必须 Close
+func (r *TenantRouter) NewDao(ctx context.Context, opts ...DaoOption) (IDao, error) {
+	tenant := TenantFromContext(ctx)
+	if tenant == "" {
+		return nil, fmt.Errorf("tenant id not found in context")
+	}
+	return r.NewTenantDao(tenant, append([]DaoOption{WithContext(ctx)}, opts...)...)
+}
+
+// NewTenantDao 使用指定的租户 ID 创建 DAO, 使用完毕后必须 Close
+func (r *TenantRouter) NewTenantDao(tenant string, opts ...DaoOption) (IDao, error) {
+	entry, err := r.acquire(tenant)
+	if err != nil {
+		return nil, err
+	}
+	return &tenantDao{IDao: entry.ds.NewDao(opts...), router: r, entry: entry}, nil
+}
+
+// Tenants 返回当前打开的租户, 最近使用的在前
+func (r *TenantRouter) Tenants() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	tenants := make([]string, 0, r.lru.Len())
+	for e := r.lru.Front(); e != nil; e = e.Next() {
+		tenants = append(tenants, e.Value.(*tenantEntry).tenant)
+	}
+	return tenants
+}
+
+// acquire 返回租户的数据源并增加引用计数, 未打开时打开, 并发打开同一租户时等待
+func (r *TenantRouter) acquire(tenant string) (*tenantEntry, error) {
+	if err := check_tenant_id(tenant); err != nil {
+		return nil, err
+	}
+	for {
+		r.lock.Lock()
+		if r.closed {
+			r.lock.Unlock()
+			return nil, fmt.Errorf("tenant router is closed")
+		}
+		if e, ok := r.tenants[tenant]; ok {
+			entry := e.Value.(*tenantEntry)
+			entry.refs++
+			r.lru.MoveToFront(e)
+			r.lock.Unlock()
+			return entry, nil
+		}
+		if opening, ok := r.opening[tenant]; ok {
+			r.lock.Unlock()
+			<-opening.done
+			if opening.err != nil {
+				return nil, opening.err
+			}
+			continue
+		}
+		opening := &tenantOpening{done: make(chan struct{})}
+		r.opening[tenant] = opening
+		r.lock.Unlock()
+
+		opening.entry, opening.err = r.open(tenant)
+
+		r.lock.Lock()
+		delete(r.opening, tenant)
+		if opening.err == nil {
+			if r.closed {
+				opening.err = fmt.Errorf("tenant router is closed")
+				defer opening.entry.ds.Close()
+			} else {
+				opening.entry.refs++
+				r.tenants[tenant] = r.lru.PushFront(opening.entry)
+			}
+		}
+		evicted := r.evictable()
+		r.lock.Unlock()
+		close(opening.done)
+		close_tenants(evicted)
+		return opening.entry, opening.err
+	}
+}
+
+func (r *TenantRouter) open(tenant string) (*tenantEntry, error) {
+	dbUrl, err := parse_db_url(strings.ReplaceAll(r.config.URLTemplate, tenantPlaceholder, tenant))
+	if err != nil {
+		return nil, err
+	}
+	ds, err := open_data_source("_tenant_"+tenant, dbUrl, r.config.Statements, r.config.Tables)
+	if err != nil {
+		return nil, fmt.Errorf("open tenant[%s] failed: %w", tenant, err)
+	}
+	if r.config.OnOpen != nil {
+		if err := r.config.OnOpen(tenant, ds); err != nil {
+			ds.Close()
+			return nil, fmt.Errorf("open tenant[%s] failed: %w", tenant, err)
+		}
+	}
+	return &tenantEntry{tenant: tenant, ds: ds}, nil
+}
+
+// release 减少引用计数, 打开的租户库超过上限时关闭空闲的租户库, 已退役的租户库引用计数归零时关闭
+func (r *TenantRouter) release(entry *tenantEntry) {
+	r.lock.Lock()
+	entry.refs--
+	evicted := r.evictable()
+	if entry.retired && entry.refs == 0 {
+		evicted = append(evicted, entry)
+	}
+	r.lock.Unlock()
+	close_tenants(evicted)
+}
+
+// evictable 从最久未使用的租户开始, 移除超过上限且空闲的租户库, 需要持有锁, 返回的数据源在锁外关闭
+func (r *TenantRouter) evictable() []*tenantEntry {
+	evicted := []*tenantEntry{}
+	for e := r.lru.Back(); e != nil && r.lru.Len() > r.config.MaxOpen; {
+		prev := e.Prev()
+		entry := e.Value.(*tenantEntry)
+		if entry.refs <= 0 {
+			r.lru.Remove(e)
+			delete(r.tenants, entry.tenant)
+			evicted = append(evicted, entry)
+		}
+		e = prev
+	}
+	if r.lru.Len() > r.config.MaxOpen {
+		log.Printf("open tenants %d exceed max %d, all tenants are in use\n", r.lru.Len(), r.config.MaxOpen)
+	}
+	return evicted
+}
+
+func close_tenants(entries []*tenantEntry) {
+	for _, entry := range entries {
+		if err := entry.ds.Close(); err != nil {
+			log.Printf("close tenant[%s] failed: %v\n", entry.tenant, err)
+		}
+	}
+}
+
+// Close 关闭空闲的租户库, 仍有 DAO 在使用的租户库在最后一个 DAO Close 时关闭; 之后不能再创建 DAO
+func (r *TenantRouter) Close() {
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		return
+	}
+	r.closed = true
+	entries := make([]*tenantEntry, 0, r.lru.Len())
+	for e := r.lru.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*tenantEntry)
+		entry.retired = true
+		if entry.refs <= 0 {
+			entries = append(entries, entry)
+		}
+	}
+	r.tenants = make(map[string]*list.Element)
+	r.lru.Init()
+	r.lock.Unlock()
+	close_tenants(entries)
+}
+
+func close_tenant_routers() {
+	tenantRoutersLock.Lock()
+	routers := tenantRouters
+	tenantRouters = []*TenantRouter{}
+	tenantRoutersLock.Unlock()
+	for _, router := range routers {
+		router.Close()
+	}
+}
+
+// tenantDao 租户的 DAO, Close 时释放租户库的引用
+type tenantDao struct {
+	IDao
+	router *TenantRouter
+	entry  *tenantEntry
+	once   sync.Once
+}
+
+func (dao *tenantDao) getModelSpec(model interface{}) (*TableSpec, error) {
+	ds, ok := dao.entry.ds.(*SqliteDataSource)
+	if !ok {
+		return nil, fmt.Errorf("tenant data source %s does not support table models", dao.entry.ds.Id())
+	}
+	return ds.getModelSpec(model)
+}
+
+func (dao *tenantDao) Close() error {
+	dao.once.Do(func() {
+		dao.router.release(dao.entry)
+	})
+	return nil
+}
@@ -1,11 +1,16 @@
 package rdbms
 
 import (
+	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"net/url"
+	"os"
 	"reflect"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
 )
 
 type memoryStatusEx struct {
@@ -20,48 +25,234 @@ type memoryStatusEx struct {
 	AvailExtendedVirtual uint64
 }
 
-func scan_table(tableSpecs *sync.Map, models ...ITable) {
+func scan_table(tableSpecs *sync.Map, models ...ITable) error {
 	for _, model := range models {
-		t := reflect.TypeOf(model)
-		if t.Kind() == reflect.Ptr {
-			t = t.Elem()
+		t := model_type(model)
+		if t == nil || t.Kind() != reflect.Struct {
+			return fmt.Errorf("scan table failed, model %T is not a struct", model)
 		}
-		tableName := model.TableName()
-		primaryInt64Key := model.PrimaryInt64Key()
-		deleteInt64Key := model.DeleteInt64Key()
-		autoUpdateDBTags := make(map[string]bool)
-		for _, dbTag := range model.AutoUpdateKeys() {
-			autoUpdateDBTags[dbTag] = true
+		fields, err := scan_table_fields(t)
+		if err != nil {
+			return err
 		}
 
+		tableName := to_snake_case(t.Name())
+		primaryKeys := []string{}
+		deleteInt64Key := ""
+		autoUpdateDBTags := make(map[string]bool)
+		searchColumns := []string{}
+		shardKey := ""
+
 		dbTags := []string{}
 		fileNameDBTags := make(map[string]string)
 		dbTagFieldNames := make(map[string]string)
-		dbTagFieldIndexes := make(map[string]int)
-		for i := 0; i < t.NumField(); i++ {
-			field := t.Field(i)
-			dbTag := field.Tag.Get("db")
-			if dbTag == "" {
-				continue
+		dbTagFieldIndexes := make(map[string][]int)
+		dbTagConverters := make(map[string][]string)
+		for _, field := range fields {
+			if len(field.converters) > 0 {
+				dbTagConverters[field.dbTag] = field.converters
+			}
+			dbTags = append(dbTags, field.dbTag)
+			fileNameDBTags[field.name] = field.dbTag
+			dbTagFieldNames[field.dbTag] = field.name
+			dbTagFieldIndexes[field.dbTag] = field.index
+			if field.options["pk"] {
+				primaryKeys = append(primaryKeys, field.dbTag)
+			}
+			if field.options["softdelete"] {
+				deleteInt64Key = field.dbTag
+			}
+			if field.options["autoupdate"] {
+				autoUpdateDBTags[field.dbTag] = true
+			}
+			if field.options["search"] {
+				searchColumns = append(searchColumns, field.dbTag)
+			}
+			if field.options["shard"] {
+				shardKey = field.dbTag
 			}
-			dbTags = append(dbTags, dbTag)
-			fileNameDBTags[field.Name] = dbTag
-			dbTagFieldNames[dbTag] = field.Name
-			dbTagFieldIndexes[dbTag] = i
+		}
+
+		// 接口方法返回非空值时, 以方法的返回值为准
+		if name := model.TableName(); name != "" {
+			tableName = name
+		}
+		if m, ok := model.(interface{ PrimaryKeys() []string }); ok && len(m.PrimaryKeys()) > 0 {
+			primaryKeys = m.PrimaryKeys()
+		} else if key := model.PrimaryInt64Key(); key != "" {
+			primaryKeys = []string{key}
+		}
+		if key := model.DeleteInt64Key(); key != "" {
+			deleteInt64Key = key
+		}
+		for _, dbTag := range model.AutoUpdateKeys() {
+			autoUpdateDBTags[dbTag] = true
+		}
+		if _, ok := dbTagFieldIndexes["id"]; ok && len(primaryKeys) == 0 {
+			primaryKeys = []string{"id"}
+		}
+
+		// 单个整数主键视为自增主键
+		autoIncrement := false
+		if len(primaryKeys) == 1 {
+			if index, ok := dbTagFieldIndexes[primaryKeys[0]]; ok {
+				switch t.FieldByIndex(index).Type.Kind() {
+				case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+					autoIncrement = true
+				}
+			}
+		}
+
+		relations, err := scan_table_relations(t)
+		if err != nil {
+			return fmt.Errorf("scan table[%s] relations failed: %w", tableName, err)
 		}
 
 		ts := &TableSpec{
 			tableName:         tableName,
-			primaryInt64Key:   primaryInt64Key,
+			modelTable:        tableName,
+			modelType:         t,
+			primaryKeys:       primaryKeys,
+			autoIncrement:     autoIncrement,
 			deleteInt64Key:    deleteInt64Key,
 			dbTags:            dbTags,
 			autoUpdateDBTags:  autoUpdateDBTags,
 			fieldNameDBTags:   fileNameDBTags,
 			dbTagFieldNames:   dbTagFieldNames,
 			dbTagFieldIndexes: dbTagFieldIndexes,
+			dbTagConverters:   dbTagConverters,
+			relations:         relations,
+			searchColumns:     searchColumns,
+			shardKey:          shardKey,
+		}
+		if m, ok := model.(interface{ SearchTokenizer() string }); ok {
+			ts.searchTokenizer = m.SearchTokenizer()
 		}
+		ts.prepareSql()
 		tableSpecs.Store(tableName, ts)
+		tableSpecs.Store(t, ts)
 	}
+	return nil
+}
+
+type table_field struct {
+	dbTag      string
+	name       string
+	index      []int
+	options    map[string]bool
+	converters []string
+}
+
+// scan_table_fields 扫描结构体中带 db tag 的字段, 递归展开匿名嵌入的结构体;
+// 同名字段按 Go 的规则取层级最浅的, 最浅的层级有多个同名字段时返回错误
+func scan_table_fields(t reflect.Type) ([]table_field, error) {
+	fields := []table_field{}
+	positions := make(map[string]int)
+	conflicts := make(map[string]int) // 出现同名字段的最浅层级
+	var err error
+	var walk func(t reflect.Type, prefix []int)
+	walk = func(t reflect.Type, prefix []int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			index := append(append([]int{}, prefix...), i)
+			tag := field.Tag.Get("db")
+			if tag == "-" {
+				continue
+			}
+			if field.Anonymous && tag == "" {
+				ft := field.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct && !is_scalar_struct(ft) {
+					walk(ft, index)
+				}
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			dbTag, options := parse_db_tag(tag)
+			if dbTag == "" {
+				continue
+			}
+			if e := check_tag_options(t, dbTag, options); e != nil && err == nil {
+				err = e
+			}
+			tf := table_field{dbTag: dbTag, name: field.Name, index: index, options: options, converters: field_converters(field.Type, tag)}
+			if pos, ok := positions[dbTag]; ok {
+				if len(fields[pos].index) > len(index) {
+					fields[pos] = tf
+				} else if len(fields[pos].index) == len(index) {
+					if depth, ok := conflicts[dbTag]; !ok || depth > len(index) {
+						conflicts[dbTag] = len(index)
+					}
+				}
+				continue
+			}
+			positions[dbTag] = len(fields)
+			fields = append(fields, tf)
+		}
+	}
+	walk(t, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		if depth, ok := conflicts[field.dbTag]; ok && depth == len(field.index) {
+			return nil, fmt.Errorf("db tag %s is declared more than once at the same embedding depth of %s", field.dbTag, t.Name())
+		}
+	}
+	return fields, nil
+}
+
+// is_scalar_struct 判断结构体是否作为单个字段值使用, 例如 time.Time、sql.NullString
+func is_scalar_struct(t reflect.Type) bool {
+	if t == reflect.TypeOf(time.Time{}) {
+		return true
+	}
+	ptr := reflect.PointerTo(t)
+	return ptr.Implements(reflect.TypeOf((*sql.Scanner)(nil)).Elem()) ||
+		t.Implements(reflect.TypeOf((*driver.Valuer)(nil)).Elem())
+}
+
+// parse_db_tag 解析 db tag, 例如 `db:"id,pk"` 返回 id 和 {pk: true}
+func parse_db_tag(tag string) (string, map[string]bool) {
+	parts := strings.Split(tag, ",")
+	options := make(map[string]bool)
+	for _, option := range parts[1:] {
+		option = strings.TrimSpace(option)
+		if option != "" {
+			options[option] = true
+		}
+	}
+	return strings.TrimSpace(parts[0]), options
+}
+
+// model_type 返回模型的结构体类型, 会解开指针和切片
+func model_type(model interface{}) reflect.Type {
+	t := reflect.TypeOf(model)
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	return t
+}
+
+// to_snake_case 将类型名转为蛇形命名, 例如 OrderItem => order_item, HTTPLog => http_log
+func to_snake_case(name string) string {
+	runes := []rune(name)
+	var builder strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				builder.WriteByte('_')
+			}
+			builder.WriteRune(unicode.ToLower(r))
+		} else {
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
 }
 
 func SqlToParams(inputs ...interface{}) []interface{} {
@@ -82,11 +273,25 @@ func SqlToParams(inputs ...interface{}) []interface{} {
 	return result
 }
 
+// ModelToTables 将模型或模型切片转为 []ITable, 切片中的结构体会取地址
 func ModelToTables(models ...interface{}) []ITable {
 	var iTables []ITable
 	for _, model := range models {
-		if iTable, ok := model.(ITable); ok {
-			iTables = append(iTables, iTable)
+		v := reflect.ValueOf(model)
+		if v.Kind() != reflect.Slice {
+			if iTable, ok := model.(ITable); ok {
+				iTables = append(iTables, iTable)
+			}
+			continue
+		}
+		for i := 0; i < v.Len(); i++ {
+			elem := v.Index(i)
+			if elem.Kind() == reflect.Struct {
+				elem = elem.Addr()
+			}
+			if iTable, ok := elem.Interface().(ITable); ok {
+				iTables = append(iTables, iTable)
+			}
 		}
 	}
 	return iTables
@@ -115,9 +320,22 @@ func parse_db_url(db_url string) (*DBUrl, error) {
 
 	// Special handling for SQLite (file-based URL)
 	if driver == "sqlite" {
+		// SQLite uses the connection string as the file path, query parameters are sqlite settings
+		path, query, _ := strings.Cut(connectionString, "?")
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sqlite URL params: %v", err)
+		}
+		params := make(map[string]string)
+		for key, value := range values {
+			if len(value) > 0 {
+				params[key] = value[len(value)-1]
+			}
+		}
 		return &DBUrl{
 			Driver: driver,
-			Host:   connectionString, // SQLite uses the connection string as the file path
+			Host:   path,
+			Params: params,
 		}, nil
 	}
 
@@ -152,3 +370,9 @@ func parse_db_url(db_url string) (*DBUrl, error) {
 		Params:   params,
 	}, nil
 }
+
+// remove_sqlite_sidecars 删除数据库文件的 WAL 和共享内存文件
+func remove_sqlite_sidecars(path string) {
+	os.Remove(path + "-wal")
+	os.Remove(path + "-shm")
+}
@@ -0,0 +1,138 @@
+package lts_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+type ArchivedOrder struct {
+	rdbms.Table
+	ID     int64  `db:"id,pk"`
+	Status string `db:"status"`
+}
+
+func (ArchivedOrder) TableName() string { return "archive.archived_order" }
+
+type ReplicaItem struct {
+	rdbms.Table
+	ID   int64  `db:"id,pk"`
+	Name string `db:"name"`
+}
+
+func TestAttachedTableModel(t *testing.T) {
+	archivePath := testDatabasePath(t, "archive")
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS archive.archived_order (id INTEGER PRIMARY KEY AUTOINCREMENT, status TEXT NOT NULL)`,
+	}
+	url := "sqlite:" + testDatabasePath(t, "main") + "?attach.archive=" + archivePath
+	ds := openTestDataSource(t, "attach", url, statements, &ArchivedOrder{})
+	dao := ds.NewDao()
+
+	order := &ArchivedOrder{Status: "new"}
+	if _, err := dao.TableInsert(order); err != nil || order.ID == 0 {
+		t.Fatalf("insert failed: %+v err=%v", order, err)
+	}
+	order.Status = "done"
+	if count, err := dao.TableUpdate(order); err != nil || count != 1 {
+		t.Fatalf("update: count=%d err=%v", count, err)
+	}
+	loaded := &ArchivedOrder{}
+	if err := dao.TableGet(loaded, order.ID); err != nil || loaded.Status != "done" {
+		t.Fatalf("get: %+v err=%v", loaded, err)
+	}
+	var orders []ArchivedOrder
+	if err := dao.TableFind(&orders, rdbms.NewCriteria().Where("status = ?", "done")); err != nil || len(orders) != 1 {
+		t.Fatalf("find: %+v err=%v", orders, err)
+	}
+
+	// 行写入附加库的文件
+	archive, err := sqlx.Open("sqlite", archivePath)
+	if err != nil {
+		t.Fatalf("open archive failed: %v", err)
+	}
+	defer archive.Close()
+	var status string
+	if err := archive.Get(&status, "SELECT status FROM archived_order WHERE id = ?", order.ID); err != nil || status != "done" {
+		t.Fatalf("row not stored in attached file: %q err=%v", status, err)
+	}
+
+	// 依赖触发器的功能不支持附加库的表
+	if err := ds.EnableChangeCapture(&ArchivedOrder{}); err == nil {
+		t.Fatal("expected change capture on an attached table to fail")
+	}
+	if count, err := dao.TableDelete(loaded.TableName(), order.ID); err != nil || count != 1 {
+		t.Fatalf("delete: count=%d err=%v", count, err)
+	}
+}
+
+func TestReplicaServesReads(t *testing.T) {
+	statements := []string{`CREATE TABLE replica_item (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL)`}
+	// 副本由外部同步, 这里写入一行只存在于副本的数据
+	replicaPath := testDatabasePath(t, "replica")
+	seed := rdbms.NewRegistry()
+	seedDs, err := seed.NewDataSource("seed", "sqlite:"+replicaPath, statements, nil)
+	if err != nil {
+		t.Fatalf("create replica failed: %v", err)
+	}
+	if _, err := seedDs.NewDao().Exec("INSERT INTO replica_item(name) VALUES ('replica')"); err != nil {
+		t.Fatalf("seed replica failed: %v", err)
+	}
+	seed.Close()
+
+	url := "sqlite:" + testDatabasePath(t, "primary") + "?replica=" + replicaPath
+	ds := openTestDataSource(t, "replica", url, statements, &ReplicaItem{})
+	dao := ds.NewDao()
+	if _, err := dao.TableInsert(&ReplicaItem{Name: "primary"}); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+
+	// 读语句走副本
+	names, err := rdbms.QueryAll[string](dao, "SELECT name FROM replica_item")
+	if err != nil || len(names) != 1 || names[0] != "replica" {
+		t.Fatalf("read not served by replica: %v err=%v", names, err)
+	}
+	var items []ReplicaItem
+	if err := dao.TableFind(&items, rdbms.NewCriteria()); err != nil || len(items) != 1 || items[0].Name != "replica" {
+		t.Fatalf("find not served by replica: %+v err=%v", items, err)
+	}
+	var conn string
+	if err := dao.Conn().Get(&conn, "SELECT name FROM replica_item"); err != nil || conn != "replica" {
+		t.Fatalf("Conn not on replica: %q err=%v", conn, err)
+	}
+
+	// 写语句和写协程读到主库
+	var primary []string
+	err = dao.Write(func(conn sqlx.Ext) error {
+		return sqlx.Select(conn, &primary, "SELECT name FROM replica_item")
+	})
+	if err != nil || len(primary) != 1 || primary[0] != "primary" {
+		t.Fatalf("writer not on primary: %v err=%v", primary, err)
+	}
+	// 副本是只读的
+	if _, err := dao.Conn().Exec("INSERT INTO replica_item(name) VALUES ('x')"); err == nil {
+		t.Fatal("expected replica connection to be read only")
+	}
+}
+
+func TestAttachParamsRejected(t *testing.T) {
+	cases := []struct {
+		params string
+		want   string
+	}{
+		{"attach.main=./other.db", "attach schema main is reserved"},
+		{"attach.bad-name=./other.db", "invalid attach schema"},
+		{"attach.archive=", "attach path is empty"},
+	}
+	for _, c := range cases {
+		t.Run(c.params, func(t *testing.T) {
+			url := "sqlite:" + testDatabasePath(t, "attach") + "?" + c.params
+			_, err := rdbms.NewDataSource("attach", url, nil, nil)
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("expected error containing %q, got %v", c.want, err)
+			}
+		})
+	}
+}
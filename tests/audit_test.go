@@ -0,0 +1,128 @@
+package lts_test
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+type AuditedFile struct {
+	rdbms.Table
+	ID   int64  `db:"id,pk"`
+	Name string `db:"name"`
+	Data []byte `db:"data"`
+}
+
+func openAuditDataSource(t *testing.T, dbPath string) rdbms.IDataSource {
+	t.Helper()
+	statements := []string{
+		`CREATE TABLE audited_file (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, data BLOB)`,
+	}
+	return openTestDataSource(t, "audit", "sqlite:"+dbPath, statements, &AuditedFile{})
+}
+
+func TestAuditRestoresBlobColumns(t *testing.T) {
+	ds := openAuditDataSource(t, filepath.ToSlash(filepath.Join(t.TempDir(), "audit.db")))
+	if err := ds.EnableAudit(&AuditedFile{}); err != nil {
+		t.Fatalf("enable audit failed: %v", err)
+	}
+	dao := ds.NewDao()
+	// 内容恰好是合法的十六进制文本, 不能与 BLOB 混淆
+	file := &AuditedFile{Name: "CAFE", Data: []byte{0x00, 0xff, 0x10}}
+	if _, err := dao.TableInsert(file); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	inserted := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	file.Data = []byte("second")
+	if _, err := dao.TableUpdate(file); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+
+	restored := &AuditedFile{}
+	if err := dao.AuditAsOf(restored, file.ID, inserted); err != nil {
+		t.Fatalf("as of failed: %v", err)
+	}
+	if restored.Name != "CAFE" || !bytes.Equal(restored.Data, []byte{0x00, 0xff, 0x10}) {
+		t.Fatalf("blob column not restored: %+v", restored)
+	}
+
+	history, err := dao.AuditHistory(&AuditedFile{}, file.ID)
+	if err != nil || len(history) != 2 {
+		t.Fatalf("history: %+v err=%v", history, err)
+	}
+	if data, ok := history[1].After["data"].([]byte); !ok || string(data) != "second" {
+		t.Fatalf("blob image not decoded: %#v", history[1].After["data"])
+	}
+	if history[1].After["name"] != "CAFE" {
+		t.Fatalf("text image changed: %#v", history[1].After["name"])
+	}
+}
+
+func TestAuditActorRecordedAfterReopen(t *testing.T) {
+	dbPath := filepath.ToSlash(filepath.Join(t.TempDir(), "audit.db"))
+	ds := openAuditDataSource(t, dbPath)
+	if err := ds.EnableAudit(&AuditedFile{}); err != nil {
+		t.Fatalf("enable audit failed: %v", err)
+	}
+
+	// 重新打开后没有调用 EnableAudit, 触发器仍在数据库中
+	ds = openAuditDataSource(t, dbPath)
+	dao := ds.NewDao(rdbms.WithContext(rdbms.ContextWithActor(context.Background(), "alice")))
+	file := &AuditedFile{Name: "report"}
+	if _, err := dao.TableInsert(file); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	history, err := dao.AuditHistory(&AuditedFile{}, file.ID)
+	if err != nil || len(history) != 1 || history[0].Actor != "alice" {
+		t.Fatalf("actor not recorded after reopen: %+v err=%v", history, err)
+	}
+}
+
+func TestAuditContextWithoutActorKeepsWithActor(t *testing.T) {
+	ds := openAuditDataSource(t, filepath.ToSlash(filepath.Join(t.TempDir(), "audit.db")))
+	if err := ds.EnableAudit(&AuditedFile{}); err != nil {
+		t.Fatalf("enable audit failed: %v", err)
+	}
+	dao := ds.NewDao(rdbms.WithActor("bob"), rdbms.WithContext(context.Background()))
+	file := &AuditedFile{Name: "notes"}
+	if _, err := dao.TableInsert(file); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	history, err := dao.AuditHistory(&AuditedFile{}, file.ID)
+	if err != nil || len(history) != 1 || history[0].Actor != "bob" {
+		t.Fatalf("actor cleared by context without actor: %+v err=%v", history, err)
+	}
+}
+
+func TestAuditActorRecordedAfterRestore(t *testing.T) {
+	source := openAuditDataSource(t, filepath.ToSlash(filepath.Join(t.TempDir(), "audit.db")))
+	if err := source.EnableAudit(&AuditedFile{}); err != nil {
+		t.Fatalf("enable audit failed: %v", err)
+	}
+	backupPath := filepath.Join(t.TempDir(), "audit.db")
+	if err := source.Backup(context.Background(), backupPath); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	// 恢复开启了审计的备份后, 写入同样记录操作人
+	target := newTestDataSource(t, "restored", []string{
+		`CREATE TABLE audited_file (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, data BLOB)`,
+	}, &AuditedFile{})
+	if err := target.Restore(backupPath); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	dao := target.NewDao(rdbms.WithActor("carol"))
+	file := &AuditedFile{Name: "restored"}
+	if _, err := dao.TableInsert(file); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	history, err := dao.AuditHistory(&AuditedFile{}, file.ID)
+	if err != nil || len(history) != 1 || history[0].Actor != "carol" {
+		t.Fatalf("actor not recorded after restore: %+v err=%v", history, err)
+	}
+}
@@ -0,0 +1,63 @@
+package lts_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+var backupStatements = []string{`CREATE TABLE backup_item (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL)`}
+
+func TestBackupAndRestore(t *testing.T) {
+	ds := newTestDataSource(t, "backup", backupStatements)
+	dao := ds.NewDao()
+	if _, err := dao.Exec("INSERT INTO backup_item(name) VALUES ('first')"); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	backupPath := filepath.Join(t.TempDir(), "backup", "snapshot.db")
+	if err := ds.Backup(context.Background(), backupPath); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	if _, err := dao.Exec("INSERT INTO backup_item(name) VALUES ('second')"); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+
+	// 恢复前取得的连接池在恢复后仍然可用, 并读到恢复后的数据
+	conn := dao.Conn()
+	if err := ds.Restore(backupPath); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	var names []string
+	if err := conn.Select(&names, "SELECT name FROM backup_item ORDER BY id"); err != nil {
+		t.Fatalf("query after restore failed: %v", err)
+	}
+	if len(names) != 1 || names[0] != "first" {
+		t.Fatalf("unexpected rows after restore: %v", names)
+	}
+
+	// 恢复后可以继续写入
+	if _, err := dao.Exec("INSERT INTO backup_item(name) VALUES ('third')"); err != nil {
+		t.Fatalf("insert after restore failed: %v", err)
+	}
+	count, err := rdbms.QueryScalar[int64](dao, "SELECT COUNT(*) FROM backup_item")
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 rows, got %d err=%v", count, err)
+	}
+}
+
+func TestRestoreMissingBackupKeepsDatabase(t *testing.T) {
+	ds := newTestDataSource(t, "backup", backupStatements)
+	dao := ds.NewDao()
+	if _, err := dao.Exec("INSERT INTO backup_item(name) VALUES ('kept')"); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	if err := ds.Restore(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Fatal("expected restore of a missing file to fail")
+	}
+	count, err := rdbms.QueryScalar[int64](dao, "SELECT COUNT(*) FROM backup_item")
+	if err != nil || count != 1 {
+		t.Fatalf("database changed by failed restore: count=%d err=%v", count, err)
+	}
+}
@@ -0,0 +1,160 @@
+package lts_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+type CapturedOrder struct {
+	rdbms.Table
+	ID     int64  `db:"id,pk"`
+	Status string `db:"status"`
+}
+
+func newCdcDataSource(t *testing.T) rdbms.IDataSource {
+	t.Helper()
+	statements := []string{
+		`CREATE TABLE captured_order (id INTEGER PRIMARY KEY AUTOINCREMENT, status TEXT NOT NULL)`,
+	}
+	return newTestDataSource(t, "cdc", statements, &CapturedOrder{})
+}
+
+func TestChangesBeforeCaptureIsEmpty(t *testing.T) {
+	ds := newCdcDataSource(t)
+	changes, err := ds.Changes(0, 10)
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("changes: %v err=%v", changes, err)
+	}
+
+	if err := ds.EnableChangeCapture(&CapturedOrder{}); err != nil {
+		t.Fatalf("enable failed: %v", err)
+	}
+	order := &CapturedOrder{Status: "new"}
+	if _, err := ds.NewDao().TableInsert(order); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	changes, err = ds.Changes(0, 10)
+	if err != nil || len(changes) != 1 || changes[0].Op != rdbms.ChangeInsert || changes[0].Key[0] != order.ID {
+		t.Fatalf("changes: %+v err=%v", changes, err)
+	}
+}
+
+func TestSubscribeReportsReadErrors(t *testing.T) {
+	ds := newCdcDataSource(t)
+	if err := ds.EnableChangeCapture(&CapturedOrder{}); err != nil {
+		t.Fatalf("enable failed: %v", err)
+	}
+	// 主键无法解码的变更记录
+	if _, err := ds.NewDao().Exec("INSERT INTO _lts_change(table_name, op, pk, columns, created_at) VALUES ('captured_order', 'insert', 'not json', '[]', 0)"); err != nil {
+		t.Fatalf("insert change failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 10)
+	ds.Subscribe(ctx, 0, rdbms.WithSubscribeError(func(err error) {
+		errs <- err
+	}))
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expected read error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read error was not reported")
+	}
+}
+
+func TestChangesReadFromPrimaryWithReplica(t *testing.T) {
+	dir := t.TempDir()
+	statements := []string{
+		`CREATE TABLE captured_order (id INTEGER PRIMARY KEY AUTOINCREMENT, status TEXT NOT NULL)`,
+	}
+	// 副本由外部同步, 这里只创建一个不会更新的副本
+	replicaPath := filepath.ToSlash(filepath.Join(dir, "replica.db"))
+	seed := rdbms.NewRegistry()
+	if _, err := seed.NewDataSource("seed", "sqlite:"+replicaPath, statements, nil); err != nil {
+		t.Fatalf("create replica failed: %v", err)
+	}
+	seed.Close()
+
+	primaryPath := filepath.ToSlash(filepath.Join(dir, "primary.db"))
+	ds := openTestDataSource(t, "cdc", "sqlite:"+primaryPath+"?replica="+replicaPath, statements, &CapturedOrder{})
+	if err := ds.EnableChangeCapture(&CapturedOrder{}); err != nil {
+		t.Fatalf("enable failed: %v", err)
+	}
+	if _, err := ds.NewDao().TableInsert(&CapturedOrder{Status: "new"}); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	changes, err := ds.Changes(0, 10)
+	if err != nil || len(changes) != 1 {
+		t.Fatalf("changes of primary not visible: %+v err=%v", changes, err)
+	}
+}
+
+// expectChangePushed 订阅后写入一行, 写入提交后应立即推送而不是等待兜底轮询
+func expectChangePushed(t *testing.T, ds rdbms.IDataSource) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cursor := int64(0)
+	if changes, err := ds.Changes(0, 1000); err == nil && len(changes) > 0 {
+		cursor = changes[len(changes)-1].ID
+	}
+	events := ds.Subscribe(ctx, cursor)
+	dao := ds.NewDao()
+	if _, err := dao.TableInsert(&CapturedOrder{Status: "first"}); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("change was not delivered")
+	}
+	if _, err := dao.TableInsert(&CapturedOrder{Status: "second"}); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	select {
+	case <-events:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("change was not pushed after commit")
+	}
+}
+
+func TestSubscribePushesAfterReopen(t *testing.T) {
+	statements := []string{
+		`CREATE TABLE captured_order (id INTEGER PRIMARY KEY AUTOINCREMENT, status TEXT NOT NULL)`,
+	}
+	url := "sqlite:" + testDatabasePath(t, "cdc")
+	ds := openTestDataSource(t, "cdc", url, statements, &CapturedOrder{})
+	if err := ds.EnableChangeCapture(&CapturedOrder{}); err != nil {
+		t.Fatalf("enable failed: %v", err)
+	}
+
+	// 重新打开后没有调用 EnableChangeCapture, 触发器仍在数据库中
+	ds = openTestDataSource(t, "cdc", url, statements, &CapturedOrder{})
+	expectChangePushed(t, ds)
+}
+
+func TestSubscribePushesAfterRestore(t *testing.T) {
+	source := newCdcDataSource(t)
+	if err := source.EnableChangeCapture(&CapturedOrder{}); err != nil {
+		t.Fatalf("enable failed: %v", err)
+	}
+	backupPath := filepath.Join(t.TempDir(), "cdc.db")
+	if err := source.Backup(context.Background(), backupPath); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	target := newTestDataSource(t, "restored", []string{
+		`CREATE TABLE captured_order (id INTEGER PRIMARY KEY AUTOINCREMENT, status TEXT NOT NULL)`,
+	}, &CapturedOrder{})
+	if err := target.Restore(backupPath); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	expectChangePushed(t, target)
+}
@@ -0,0 +1,97 @@
+package lts_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+func TestSqliteParamsRejected(t *testing.T) {
+	cases := []struct {
+		params string
+		want   string
+	}{
+		{"journal_mode=FAST", "journal_mode must be one of"},
+		{"synchronous=sometimes", "synchronous must be one of"},
+		{"busy_timeout=-1", "busy_timeout must be >= 0"},
+		{"busy_timeout=soon", "invalid sqlite param busy_timeout"},
+		{"foreign_keys=maybe", "foreign_keys must be a boolean"},
+		{"mmap_size=-2", "mmap_size must be >= -1"},
+		{"reader_pool_size=0", "reader_pool_size must be >= 1"},
+		{"conn_max_lifetime=10", "invalid sqlite param conn_max_lifetime"},
+		{"journal=WAL", "unknown sqlite param: journal"},
+	}
+	for _, c := range cases {
+		t.Run(c.params, func(t *testing.T) {
+			url := "sqlite:" + testDatabasePath(t, "config") + "?" + c.params
+			_, err := rdbms.NewDataSource("config", url, nil, nil)
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("expected error containing %q, got %v", c.want, err)
+			}
+		})
+	}
+}
+
+func TestSqliteParamsApplied(t *testing.T) {
+	params := "journal_mode=delete&synchronous=FULL&busy_timeout=2000&foreign_keys=on" +
+		"&reader_pool_size=3&reader_idle_size=5&conn_max_lifetime=1h&mmap_size=0&cache_size=-512"
+	ds := openTestDataSource(t, "config", "sqlite:"+testDatabasePath(t, "config")+"?"+params, nil)
+	settings, err := ds.Settings()
+	if err != nil {
+		t.Fatalf("settings failed: %v", err)
+	}
+	expected := map[string]string{
+		"writer.journal_mode":      "delete",
+		"writer.synchronous":       "2",
+		"writer.busy_timeout":      "2000",
+		"writer.foreign_keys":      "1",
+		"reader.busy_timeout":      "2000",
+		"reader.query_only":        "1",
+		"reader.mmap_size":         "0",
+		"reader.cache_size":        "-512",
+		"reader.pool_size":         "3",
+		"reader.idle_size":         "3", // 空闲连接数不超过连接池大小
+		"reader.conn_max_lifetime": "1h0m0s",
+	}
+	for key, value := range expected {
+		if settings[key] != value {
+			t.Errorf("%s: expected %s, got %q", key, value, settings[key])
+		}
+	}
+}
+
+func TestReaderPragmasRefreshAsDatabaseGrows(t *testing.T) {
+	params := "journal_mode=DELETE&reader_refresh_interval=20ms"
+	ds := openTestDataSource(t, "refresh", "sqlite:"+testDatabasePath(t, "refresh")+"?"+params,
+		[]string{`CREATE TABLE refresh_blob (id INTEGER PRIMARY KEY, data BLOB NOT NULL)`})
+	settings, err := ds.Settings()
+	if err != nil {
+		t.Fatalf("settings failed: %v", err)
+	}
+	initial := settings["reader.mmap_size"]
+
+	// 文件增长超过 10% 后, 之后建立的读连接使用新的 mmap_size
+	dao := ds.NewDao()
+	for i := 0; i < 32; i++ {
+		if _, err := dao.Exec("INSERT INTO refresh_blob(data) VALUES (zeroblob(65536))"); err != nil {
+			t.Fatalf("insert failed: %v", err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		settings, err = ds.Settings()
+		if err != nil {
+			t.Fatalf("settings failed: %v", err)
+		}
+		if mmap, _ := strconv.ParseInt(settings["reader.mmap_size"], 10, 64); mmap >= 1024*1024 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("reader mmap_size not refreshed: initial=%s current=%s", initial, settings["reader.mmap_size"])
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
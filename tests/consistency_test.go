@@ -0,0 +1,121 @@
+package lts_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+type ConsistencyEvent struct {
+	rdbms.Table
+	ID      int64  `db:"id,pk"`
+	Payload string `db:"payload"`
+}
+
+func newConsistencyDataSource(t *testing.T) rdbms.IDataSource {
+	t.Helper()
+	statements := []string{
+		`CREATE TABLE consistency_event (id INTEGER PRIMARY KEY AUTOINCREMENT, payload TEXT NOT NULL DEFAULT '')`,
+	}
+	return newTestDataSource(t, "consistency", statements, &ConsistencyEvent{})
+}
+
+func TestSessionConsistencyReadsOwnWrites(t *testing.T) {
+	ds := newConsistencyDataSource(t)
+
+	const workers = 16
+	const rounds = 50
+	var wg sync.WaitGroup
+	errs := make(chan string, workers*rounds)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				dao := ds.NewDao(rdbms.WithConsistency(rdbms.ConsistencySession))
+				event := &ConsistencyEvent{Payload: "session"}
+				if _, err := dao.TableInsert(event); err != nil {
+					errs <- "insert: " + err.Error()
+					return
+				}
+				loaded := &ConsistencyEvent{}
+				if err := dao.TableGet(loaded, event.ID); err != nil {
+					errs <- "get: " + err.Error()
+					continue
+				}
+				count, err := rdbms.QueryScalar[int64](dao, "SELECT COUNT(*) FROM consistency_event WHERE id = ?", event.ID)
+				if err != nil || count != 1 {
+					errs <- "scalar: missing row"
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for msg := range errs {
+		t.Error(msg)
+	}
+}
+
+func TestStrongConsistencyObservesCompletedWrites(t *testing.T) {
+	ds := newConsistencyDataSource(t)
+
+	const writers = 8
+	const rounds = 50
+	ids := make(chan int64, writers*rounds)
+	var writeWg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		writeWg.Add(1)
+		go func() {
+			defer writeWg.Done()
+			dao := ds.NewDao()
+			for i := 0; i < rounds; i++ {
+				event := &ConsistencyEvent{Payload: "strong"}
+				if _, err := dao.TableInsert(event); err != nil {
+					t.Errorf("insert failed: %v", err)
+					return
+				}
+				// 写入完成后才交给其他 DAO 读取
+				ids <- event.ID
+			}
+		}()
+	}
+	go func() {
+		writeWg.Wait()
+		close(ids)
+	}()
+
+	var readWg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readWg.Add(1)
+		go func() {
+			defer readWg.Done()
+			dao := ds.NewDao(rdbms.WithConsistency(rdbms.ConsistencyStrong))
+			for id := range ids {
+				var events []ConsistencyEvent
+				if err := dao.TableSelect(&events, id); err != nil || len(events) != 1 {
+					t.Errorf("event %d not visible after write completed: %v", id, err)
+				}
+			}
+		}()
+	}
+	readWg.Wait()
+}
+
+func TestConsistentPerCall(t *testing.T) {
+	ds := newConsistencyDataSource(t)
+
+	writer := ds.NewDao()
+	reader := ds.NewDao()
+	for i := 0; i < 100; i++ {
+		event := &ConsistencyEvent{Payload: "per-call"}
+		if _, err := writer.TableInsert(event); err != nil {
+			t.Fatalf("insert failed: %v", err)
+		}
+		loaded := &ConsistencyEvent{}
+		if err := reader.Consistent(rdbms.ConsistencyStrong).TableGet(loaded, event.ID); err != nil {
+			t.Fatalf("event %d not visible: %v", event.ID, err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package lts_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sssxyd/go-lts-core/basic"
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+type SecretProfile struct {
+	Phone string `json:"phone"`
+}
+
+type SecretAccount struct {
+	rdbms.Table
+	ID      int64         `db:"id,pk"`
+	Name    string        `db:"name"`
+	Token   string        `db:"token,encrypted"`
+	Note    string        `db:"note,encrypted"`
+	Profile SecretProfile `db:"profile,json,encrypted"`
+}
+
+// 加密密钥是全局注册的, 使用加密字段的测试各自设置密钥
+func setEncryptionKey(t *testing.T, current string, previous ...string) {
+	t.Helper()
+	providers := make([]basic.IKeyProvider, 0, len(previous))
+	for _, key := range previous {
+		providers = append(providers, basic.StaticKey([]byte(key)))
+	}
+	if err := rdbms.SetEncryptionKey(basic.StaticKey([]byte(current)), providers...); err != nil {
+		t.Fatalf("set encryption key failed: %v", err)
+	}
+}
+
+func newEncryptionDataSource(t *testing.T) rdbms.IDataSource {
+	t.Helper()
+	statements := []string{
+		`CREATE TABLE secret_account (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL UNIQUE, token TEXT, note TEXT, profile TEXT)`,
+	}
+	return newTestDataSource(t, "encryption", statements, &SecretAccount{})
+}
+
+func TestEncryptedColumnsWithAssignedKeys(t *testing.T) {
+	setEncryptionKey(t, "first key")
+	ds := newEncryptionDataSource(t)
+	dao := ds.NewDao()
+	a := &SecretAccount{Name: "a", Token: "token-a", Profile: SecretProfile{Phone: "123"}}
+	b := &SecretAccount{Name: "b", Token: "token-b"}
+	ids, err := dao.TableInsert(a, b)
+	if err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	if a.ID == 0 || b.ID != a.ID+1 || ids[0] != a.ID || ids[1] != b.ID {
+		t.Fatalf("keys not assigned: a=%d b=%d ids=%v", a.ID, b.ID, ids)
+	}
+	raw, err := rdbms.QueryAll[string](dao, "SELECT token FROM secret_account ORDER BY id")
+	if err != nil || len(raw) != 2 || !basic.IsEncrypted(raw[0]) {
+		t.Fatalf("token stored in plaintext: %v err=%v", raw, err)
+	}
+
+	loaded := &SecretAccount{}
+	if err := dao.TableGet(loaded, a.ID); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if loaded.Token != "token-a" || loaded.Profile.Phone != "123" {
+		t.Fatalf("unexpected decrypted value: %+v", loaded)
+	}
+
+	// 插入失败时清除分配的主键
+	duplicate := &SecretAccount{Name: "a", Token: "token-c"}
+	if _, err := dao.TableInsert(duplicate); err == nil {
+		t.Fatal("expected unique constraint error")
+	}
+	if duplicate.ID != 0 {
+		t.Fatalf("assigned key kept after failed insert: %d", duplicate.ID)
+	}
+}
+
+func TestEncryptedColumnsDetectMovedCiphertext(t *testing.T) {
+	setEncryptionKey(t, "first key")
+	ds := newEncryptionDataSource(t)
+	dao := ds.NewDao()
+	a := &SecretAccount{Name: "a", Token: "token-a", Note: "note-a"}
+	b := &SecretAccount{Name: "b", Token: "token-b", Note: "note-b"}
+	if _, err := dao.TableInsert(a, b); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+
+	// 密文挪到其他行
+	if _, err := dao.Exec("UPDATE secret_account SET token = (SELECT token FROM secret_account WHERE id = ?) WHERE id = ?", a.ID, b.ID); err != nil {
+		t.Fatalf("copy ciphertext failed: %v", err)
+	}
+	if err := dao.TableGet(&SecretAccount{}, b.ID); !errors.Is(err, basic.ErrCiphertextTampered) {
+		t.Fatalf("expected tampered error for ciphertext of another row, got %v", err)
+	}
+
+	// 密文挪到其他字段
+	if _, err := dao.Exec("UPDATE secret_account SET note = token WHERE id = ?", a.ID); err != nil {
+		t.Fatalf("copy ciphertext failed: %v", err)
+	}
+	if err := dao.TableGet(&SecretAccount{}, a.ID); !errors.Is(err, basic.ErrCiphertextTampered) {
+		t.Fatalf("expected tampered error for ciphertext of another column, got %v", err)
+	}
+}
+
+func TestReEncryptColumnsAfterKeyRotation(t *testing.T) {
+	setEncryptionKey(t, "first key")
+	ds := newEncryptionDataSource(t)
+	dao := ds.NewDao()
+	a := &SecretAccount{Name: "a", Token: "token-a", Note: "note-a"}
+	if _, err := dao.TableInsert(a); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	// 开启加密前写入的明文
+	if _, err := dao.Exec("INSERT INTO secret_account (id, name, token, note, profile) VALUES (100, 'legacy', 'plain-token', NULL, NULL)"); err != nil {
+		t.Fatalf("insert plaintext failed: %v", err)
+	}
+
+	setEncryptionKey(t, "second key", "first key")
+	loaded := &SecretAccount{}
+	if err := dao.TableGet(loaded, a.ID); err != nil || loaded.Token != "token-a" {
+		t.Fatalf("previous key should still decrypt: %+v err=%v", loaded, err)
+	}
+	count, err := ds.ReEncryptColumns(&SecretAccount{})
+	if err != nil || count != 2 {
+		t.Fatalf("re-encrypt: count=%d err=%v", count, err)
+	}
+	if count, err := ds.ReEncryptColumns(&SecretAccount{}); err != nil || count != 0 {
+		t.Fatalf("second re-encrypt should be a no-op: count=%d err=%v", count, err)
+	}
+
+	// 只保留新密钥后仍可读取
+	setEncryptionKey(t, "second key")
+	var accounts []SecretAccount
+	if err := dao.TableFind(&accounts, rdbms.NewCriteria().OrderBy("id")); err != nil {
+		t.Fatalf("find failed: %v", err)
+	}
+	if len(accounts) != 2 || accounts[0].Note != "note-a" || accounts[1].Token != "plain-token" {
+		t.Fatalf("unexpected accounts after rotation: %+v", accounts)
+	}
+	raw, err := rdbms.QueryAll[string](dao, "SELECT token FROM secret_account WHERE id = 100")
+	if err != nil || len(raw) != 1 || !strings.HasPrefix(raw[0], "enc1:") {
+		t.Fatalf("plaintext not encrypted: %v err=%v", raw, err)
+	}
+}
+
+func TestChangeTrackingSkipsUnchangedEncryptedColumns(t *testing.T) {
+	setEncryptionKey(t, "first key")
+	ds := newEncryptionDataSource(t)
+	account := &SecretAccount{Name: "a", Token: "token-a", Profile: SecretProfile{Phone: "123"}}
+	if _, err := ds.NewDao().TableInsert(account); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	stored, err := rdbms.QueryOne[string](ds.NewDao(), "SELECT token FROM secret_account WHERE id = ?", account.ID)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+
+	dao := ds.NewDao(rdbms.WithChangeTracking())
+	loaded := &SecretAccount{}
+	if err := dao.TableGet(loaded, account.ID); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	// 加密的随机数每次不同, 未修改的加密字段不能视为变化
+	if count, err := dao.TableUpdate(loaded); err != nil || count != 0 {
+		t.Fatalf("unchanged update: count=%d err=%v", count, err)
+	}
+	loaded.Name = "b"
+	if count, err := dao.TableUpdate(loaded); err != nil || count != 1 {
+		t.Fatalf("update name: count=%d err=%v", count, err)
+	}
+	after, err := rdbms.QueryOne[string](ds.NewDao(), "SELECT token FROM secret_account WHERE id = ?", account.ID)
+	if err != nil || after != stored {
+		t.Fatalf("unchanged encrypted column was rewritten: err=%v", err)
+	}
+
+	loaded.Profile.Phone = "456"
+	if count, err := dao.TableUpdate(loaded); err != nil || count != 1 {
+		t.Fatalf("update profile: count=%d err=%v", count, err)
+	}
+	reloaded := &SecretAccount{}
+	if err := ds.NewDao().TableGet(reloaded, account.ID); err != nil || reloaded.Profile.Phone != "456" || reloaded.Token != "token-a" {
+		t.Fatalf("unexpected row: %+v err=%v", reloaded, err)
+	}
+}
@@ -0,0 +1,110 @@
+package lts_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+type FixturePerson struct {
+	rdbms.Table
+	ID   int64   `db:"id,pk"`
+	Name string  `db:"name"`
+	Nick *string `db:"nick"`
+}
+
+func (FixturePerson) TableName() string { return "person" }
+
+type FixturePet struct {
+	rdbms.Table
+	ID       int64   `db:"id,pk"`
+	PersonID int64   `db:"person_id"`
+	Name     string  `db:"name"`
+	Nick     *string `db:"nick"`
+}
+
+func (FixturePet) TableName() string { return "pet" }
+
+func newFixtureDataSource(t *testing.T) rdbms.IDataSource {
+	t.Helper()
+	return newTestDataSource(t, "fixture", []string{
+		`CREATE TABLE person (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, nick TEXT)`,
+		`CREATE TABLE pet (id INTEGER PRIMARY KEY AUTOINCREMENT, person_id INTEGER NOT NULL, name TEXT NOT NULL, nick TEXT)`,
+	}, &FixturePerson{}, &FixturePet{})
+}
+
+const fixturePeople = `
+pet:
+  - {person_id: $person.bob, name: Rex, nick: $person.bob.nick}
+  - {person_id: $person.carol, name: Tom, nick: $person.carol.nick}
+person:
+  bob: {name: Bob}
+  carol: {id: 42, name: Carol, nick: cc}
+`
+
+func TestFixtureBackFillsKeysAndResolvesNull(t *testing.T) {
+	ds := newFixtureDataSource(t)
+	fixtures, err := rdbms.ParseFixtures("people.yaml", []byte(fixturePeople))
+	if err != nil {
+		t.Fatalf("parse fixtures failed: %v", err)
+	}
+	rows, err := fixtures.Load(ds, rdbms.WithFixtureOnce())
+	if err != nil {
+		t.Fatalf("load fixtures failed: %v", err)
+	}
+
+	// 显式主键原样写入, 自增主键回填
+	dao := ds.NewDao()
+	defer dao.Close()
+	bob := rows["person.bob"].(*FixturePerson)
+	carol := rows["person.carol"].(*FixturePerson)
+	if carol.ID != 42 || bob.ID == 0 {
+		t.Fatalf("unexpected keys: bob=%d carol=%d", bob.ID, carol.ID)
+	}
+	for _, p := range []*FixturePerson{bob, carol} {
+		stored := &FixturePerson{}
+		if err := dao.TableGet(stored, p.ID); err != nil || stored.Name != p.Name {
+			t.Fatalf("person %d not stored: %+v err=%v", p.ID, stored, err)
+		}
+	}
+
+	// NULL 字段的引用写入 NULL
+	rex := rows["pet.0"].(*FixturePet)
+	tom := rows["pet.1"].(*FixturePet)
+	if rex.PersonID != bob.ID || rex.Nick != nil {
+		t.Fatalf("unexpected pet.0: %+v", rex)
+	}
+	if tom.PersonID != 42 || tom.Nick == nil || *tom.Nick != "cc" {
+		t.Fatalf("unexpected pet.1: %+v", tom)
+	}
+
+	// 再次加载时跳过已写入的行, 从数据库读取
+	again, err := fixtures.Load(ds, rdbms.WithFixtureOnce())
+	if err != nil {
+		t.Fatalf("reload fixtures failed: %v", err)
+	}
+	if again["person.carol"].(*FixturePerson).ID != 42 || again["person.bob"].(*FixturePerson).ID != bob.ID {
+		t.Fatalf("reload returned different rows: %+v", again)
+	}
+	count, err := rdbms.QueryScalar[int64](dao, "SELECT COUNT(*) FROM pet")
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 pets, got %d err=%v", count, err)
+	}
+}
+
+func TestFixtureCircularReference(t *testing.T) {
+	ds := newFixtureDataSource(t)
+	fixtures, err := rdbms.ParseFixtures("cycle.yaml", []byte(`
+person:
+  a: {name: $person.b.name}
+  b: {name: $person.a.name}
+`))
+	if err != nil {
+		t.Fatalf("parse fixtures failed: %v", err)
+	}
+	_, err = fixtures.Load(ds)
+	if err == nil || !strings.Contains(err.Error(), "circular") {
+		t.Fatalf("expected circular reference error, got %v", err)
+	}
+}
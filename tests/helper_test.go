@@ -0,0 +1,30 @@
+package lts_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+// newTestDataSource 在临时目录中创建数据源, 测试结束时关闭所有数据源
+func newTestDataSource(t *testing.T, id string, statements []string, tables ...rdbms.ITable) rdbms.IDataSource {
+	t.Helper()
+	return openTestDataSource(t, id, "sqlite:"+testDatabasePath(t, id), statements, tables...)
+}
+
+// openTestDataSource 按指定地址创建数据源, 用于带参数的地址
+func openTestDataSource(t *testing.T, id string, url string, statements []string, tables ...rdbms.ITable) rdbms.IDataSource {
+	t.Helper()
+	ds, err := rdbms.NewDataSource(id, url, statements, tables)
+	if err != nil {
+		t.Fatalf("create data source[%s] failed: %v", id, err)
+	}
+	t.Cleanup(rdbms.Close)
+	return ds
+}
+
+// testDatabasePath 临时目录中的数据库文件路径
+func testDatabasePath(t *testing.T, name string) string {
+	return filepath.ToSlash(filepath.Join(t.TempDir(), name+".db"))
+}
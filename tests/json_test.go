@@ -0,0 +1,117 @@
+package lts_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+type JsonProfile struct {
+	City string `json:"city"`
+	Age  int    `json:"age"`
+}
+
+type JsonUser struct {
+	rdbms.Table
+	ID      int64                   `db:"id,pk"`
+	Profile rdbms.Json[JsonProfile] `db:"profile"`
+	Tags    rdbms.Json[[]string]    `db:"tags"`
+}
+
+func newJsonDataSource(t *testing.T) rdbms.IDao {
+	t.Helper()
+	statements := []string{
+		`CREATE TABLE json_user (id INTEGER PRIMARY KEY AUTOINCREMENT, profile TEXT, tags TEXT)`,
+	}
+	ds := newTestDataSource(t, "json", statements, &JsonUser{})
+	dao := ds.NewDao()
+	if _, err := dao.TableInsert(
+		&JsonUser{Profile: rdbms.NewJson(JsonProfile{City: "Shanghai", Age: 30}), Tags: rdbms.NewJson([]string{"vip", "new"})},
+		&JsonUser{Profile: rdbms.NewJson(JsonProfile{City: "Beijing", Age: 20})},
+	); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	return dao
+}
+
+func TestJsonPathHelpers(t *testing.T) {
+	cases := map[string]string{
+		rdbms.JsonExtract("profile", "address.city"): "json_extract(profile, '$.address.city')",
+		rdbms.JsonExtract("tags", "[0]"):             "json_extract(tags, '$[0]')",
+		rdbms.JsonExtract("tags", ""):                "json_extract(tags, '$')",
+		rdbms.JsonText("profile", "$.name"):          "profile ->> '$.name'",
+		rdbms.JsonValue("profile", "it's"):           "profile -> '$.it''s'",
+	}
+	for actual, expected := range cases {
+		if actual != expected {
+			t.Errorf("expected %s, got %s", expected, actual)
+		}
+	}
+}
+
+func TestJsonScanAndValue(t *testing.T) {
+	var profile rdbms.Json[JsonProfile]
+	if err := profile.Scan(`{"city":"Shanghai","age":30}`); err != nil || !profile.Valid || profile.Val.Age != 30 {
+		t.Fatalf("scan object: %+v err=%v", profile, err)
+	}
+	if err := profile.Scan(nil); err != nil || profile.Valid || profile.Val.City != "" {
+		t.Fatalf("scan NULL: %+v err=%v", profile, err)
+	}
+	var number rdbms.Json[float64]
+	if err := number.Scan(int64(18)); err != nil || number.Val != 18 {
+		t.Fatalf("scan number: %+v err=%v", number, err)
+	}
+	var text rdbms.Json[string]
+	if err := text.Scan("Shanghai"); err != nil || text.Val != "Shanghai" {
+		t.Fatalf("scan unquoted text: %+v err=%v", text, err)
+	}
+	if err := profile.Scan("not json"); err == nil {
+		t.Fatal("expected decode error")
+	}
+
+	value, err := rdbms.NewJson([]string{"a"}).Value()
+	if err != nil || value != `["a"]` {
+		t.Fatalf("value: %v err=%v", value, err)
+	}
+	if value, err := (rdbms.Json[[]string]{}).Value(); err != nil || value != nil {
+		t.Fatalf("invalid value should be NULL: %v err=%v", value, err)
+	}
+}
+
+func TestJsonCriteria(t *testing.T) {
+	dao := newJsonDataSource(t)
+	var users []JsonUser
+	if err := dao.TableFind(&users, rdbms.NewCriteria().JsonWhere("profile", "city", "=", "Shanghai")); err != nil {
+		t.Fatalf("find failed: %v", err)
+	}
+	if len(users) != 1 || users[0].Tags.Val[0] != "vip" {
+		t.Fatalf("unexpected users: %+v", users)
+	}
+	var tagged []JsonUser
+	if err := dao.TableFind(&tagged, rdbms.NewCriteria().JsonContains("tags", "", "new").JsonHas("profile", "age")); err != nil || len(tagged) != 1 {
+		t.Fatalf("contains: %+v err=%v", tagged, err)
+	}
+	var ordered []JsonUser
+	if err := dao.TableFind(&ordered, rdbms.NewCriteria().JsonOrderBy("profile", "age", false)); err != nil || len(ordered) != 2 || ordered[0].Profile.Val.City != "Beijing" {
+		t.Fatalf("order by: %+v err=%v", ordered, err)
+	}
+	if ordered[0].Tags.Valid || !ordered[0].Profile.Valid {
+		t.Fatalf("NULL json column should be invalid: %+v", ordered)
+	}
+}
+
+func TestJsonCriteriaReturnsInvalidColumnError(t *testing.T) {
+	dao := newJsonDataSource(t)
+	var users []JsonUser
+	criteria := rdbms.NewCriteria().JsonWhere("profile; DROP TABLE json_user", "city", "=", "x")
+	if err := dao.TableFind(&users, criteria); err == nil || !strings.Contains(err.Error(), "invalid json column") {
+		t.Fatalf("expected invalid column error, got %v", err)
+	}
+	if err := dao.TableFind(&users, rdbms.NewCriteria().JsonWhere("profile", "city", "MATCH", "x")); err == nil || !strings.Contains(err.Error(), "invalid json operator") {
+		t.Fatalf("expected invalid operator error, got %v", err)
+	}
+	if err := rdbms.CreateJsonIndex(dao, "json_user", "profile)", "city"); err == nil {
+		t.Fatal("expected invalid column error")
+	}
+}
@@ -0,0 +1,56 @@
+package lts_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+func newMaintenanceDataSource(t *testing.T) rdbms.IDataSource {
+	t.Helper()
+	return newTestDataSource(t, "maintenance", []string{`CREATE TABLE maintenance_log (id INTEGER PRIMARY KEY)`})
+}
+
+func TestIntegrityCheckDoesNotWaitForWriter(t *testing.T) {
+	ds := newMaintenanceDataSource(t)
+	for _, status := range ds.MaintenanceStatus() {
+		if status.Task == rdbms.MaintenanceIntegrityCheck && status.Interval != 0 {
+			t.Fatalf("integrity check should be opt-in, interval=%v", status.Interval)
+		}
+	}
+
+	blocked, release := make(chan struct{}), make(chan struct{})
+	go ds.NewDao().Write(func(conn sqlx.Ext) error {
+		close(blocked)
+		<-release
+		return nil
+	})
+	<-blocked
+	defer close(release)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ds.RunMaintenance(rdbms.MaintenanceIntegrityCheck)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("integrity check failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("integrity check waited for the writer")
+	}
+}
+
+func TestRunMaintenanceAfterClose(t *testing.T) {
+	ds := newMaintenanceDataSource(t)
+	if err := ds.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if _, err := ds.RunMaintenance(rdbms.MaintenanceCheckpoint); err == nil {
+		t.Fatal("expected error after close")
+	}
+}
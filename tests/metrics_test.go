@@ -0,0 +1,89 @@
+package lts_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+type MeteredItem struct {
+	rdbms.Table
+	ID   int64  `db:"id,pk"`
+	Name string `db:"name"`
+}
+
+func newMetricsDataSource(t *testing.T) rdbms.IDataSource {
+	t.Helper()
+	statements := []string{
+		`CREATE TABLE metered_item (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL UNIQUE)`,
+	}
+	return newTestDataSource(t, "metrics", statements, &MeteredItem{})
+}
+
+func TestWriteMetricsOfLiveDataSource(t *testing.T) {
+	ds := newMetricsDataSource(t)
+	dao := ds.NewDao()
+	if _, err := dao.TableInsert(&MeteredItem{Name: "a"}, &MeteredItem{Name: "b"}); err != nil {
+		t.Fatalf("batch insert failed: %v", err)
+	}
+	if _, err := dao.TableInsert(&MeteredItem{Name: "a"}); err == nil {
+		t.Fatal("expected unique constraint error")
+	}
+	if err := dao.Write(func(conn sqlx.Ext) error {
+		_, err := conn.Exec("UPDATE metered_item SET name = name")
+		return err
+	}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := rdbms.WriteMetrics(&buf, ds); err != nil {
+		t.Fatalf("write metrics failed: %v", err)
+	}
+	text := buf.String()
+	for _, line := range []string{
+		"# TYPE lts_rdbms_writer_tasks_total counter",
+		`lts_rdbms_writer_tasks_total{datasource="metrics",kind="batch"} 1`,
+		`lts_rdbms_writer_task_errors_total{datasource="metrics",kind="exec"} 1`,
+		"# TYPE lts_rdbms_batch_size histogram",
+		`lts_rdbms_batch_size_bucket{datasource="metrics",le="2"} 1`,
+		`lts_rdbms_batch_size_sum{datasource="metrics"} 2`,
+		`lts_rdbms_writer_queue_depth{datasource="metrics"} 0`,
+		`lts_rdbms_reader_max_open_connections{datasource="metrics"}`,
+		`lts_rdbms_db_file_bytes{datasource="metrics"}`,
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("metrics missing %q", line)
+		}
+	}
+	if strings.Count(text, "# TYPE lts_rdbms_exec_duration_seconds ") != 1 {
+		t.Errorf("exec latency family should be written once:\n%s", text)
+	}
+	if t.Failed() {
+		t.Logf("metrics:\n%s", text)
+	}
+}
+
+func TestMetricsHandlerMergesDataSources(t *testing.T) {
+	first := newMetricsDataSource(t)
+	second := newTestDataSource(t, "metrics2", nil)
+
+	recorder := httptest.NewRecorder()
+	rdbms.MetricsHandler(first, second).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %q", recorder.Header().Get("Content-Type"))
+	}
+	text := recorder.Body.String()
+	if strings.Count(text, "# HELP lts_rdbms_writer_queue_depth ") != 1 {
+		t.Fatalf("help line should be written once per family:\n%s", text)
+	}
+	for _, id := range []string{"metrics", "metrics2"} {
+		if !strings.Contains(text, `lts_rdbms_writer_queue_depth{datasource="`+id+`"}`) {
+			t.Fatalf("missing queue depth of %s:\n%s", id, text)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package lts_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+type ObservedItem struct {
+	rdbms.Table
+	ID   int64  `db:"id,pk"`
+	Name string `db:"name"`
+}
+
+type recordingObserver struct {
+	lock   sync.Mutex
+	events []rdbms.QueryEvent
+}
+
+func (o *recordingObserver) OnQuery(event *rdbms.QueryEvent) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.events = append(o.events, *event)
+}
+
+// find 返回语句包含 fragment 的事件
+func (o *recordingObserver) find(fragment string) []rdbms.QueryEvent {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	var found []rdbms.QueryEvent
+	for _, event := range o.events {
+		if strings.Contains(event.SQL, fragment) {
+			found = append(found, event)
+		}
+	}
+	return found
+}
+
+func newObservedDataSource(t *testing.T) (rdbms.IDataSource, *recordingObserver) {
+	t.Helper()
+	statements := []string{
+		`CREATE TABLE observed_item (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL)`,
+	}
+	ds := newTestDataSource(t, "observer", statements, &ObservedItem{})
+	observer := &recordingObserver{}
+	ds.AddQueryObserver(observer)
+	return ds, observer
+}
+
+func TestObserverSeesConnAndWriteStatements(t *testing.T) {
+	ds, observer := newObservedDataSource(t)
+	dao := ds.NewDao()
+
+	if err := dao.Write(func(conn sqlx.Ext) error {
+		_, err := conn.Exec("INSERT INTO observed_item (name) VALUES (?)", "written")
+		return err
+	}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	var count int
+	if err := dao.Conn().Get(&count, "SELECT count(*) FROM observed_item WHERE name = ?", "written"); err != nil || count != 1 {
+		t.Fatalf("conn query: count=%d err=%v", count, err)
+	}
+
+	writes := observer.find("INSERT INTO observed_item")
+	if len(writes) != 1 || writes[0].Reader || writes[0].RowsAffected != 1 {
+		t.Fatalf("write statement not observed on writer: %+v", writes)
+	}
+	reads := observer.find("SELECT count(*) FROM observed_item")
+	if len(reads) != 1 || !reads[0].Reader || len(reads[0].Args) != 1 {
+		t.Fatalf("conn query not observed on reader: %+v", reads)
+	}
+}
+
+func TestObserverReportsBatchOnce(t *testing.T) {
+	ds, observer := newObservedDataSource(t)
+	items := []rdbms.ITable{&ObservedItem{Name: "a"}, &ObservedItem{Name: "b"}, &ObservedItem{Name: "c"}}
+	if _, err := ds.NewDao().TableInsert(items...); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+
+	events := observer.find("INSERT")
+	if len(events) != 1 || events[0].Batch != 3 {
+		t.Fatalf("expected one batch event, got %+v", events)
+	}
+}
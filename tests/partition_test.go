@@ -0,0 +1,213 @@
+package lts_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+type PartitionEvent struct {
+	rdbms.Table
+	ID        int64     `db:"id,pk"`
+	Kind      string    `db:"kind"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+var partitionStatements = []string{
+	`CREATE TABLE partition_event (id INTEGER PRIMARY KEY AUTOINCREMENT, kind TEXT NOT NULL, created_at DATETIME NOT NULL)`,
+}
+
+func expectPartitions(t *testing.T, ds rdbms.IDataSource, expected ...string) {
+	t.Helper()
+	tables, err := ds.Partitions(&PartitionEvent{})
+	if err != nil {
+		t.Fatalf("partitions failed: %v", err)
+	}
+	if strings.Join(tables, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected partitions %v, got %v", expected, tables)
+	}
+}
+
+func TestPartitionRoutesByTimeColumn(t *testing.T) {
+	ds := newTestDataSource(t, "partition", partitionStatements, &PartitionEvent{})
+	if err := ds.EnablePartition(&PartitionEvent{}, rdbms.PartitionConfig{Column: "created_at", Location: time.UTC, Indexes: []string{"kind"}}); err != nil {
+		t.Fatalf("enable partition failed: %v", err)
+	}
+	// 首次写入前不创建分区
+	expectPartitions(t, ds)
+
+	dao := ds.NewDao()
+	jan := &PartitionEvent{Kind: "login", CreatedAt: time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)}
+	feb1 := &PartitionEvent{Kind: "login", CreatedAt: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)}
+	feb2 := &PartitionEvent{Kind: "logout", CreatedAt: time.Date(2026, 2, 28, 23, 0, 0, 0, time.UTC)}
+	for _, event := range []*PartitionEvent{jan, feb1, feb2} {
+		if _, err := dao.TableInsert(event); err != nil {
+			t.Fatalf("insert failed: %v", err)
+		}
+	}
+	expectPartitions(t, ds, "partition_event_202601", "partition_event_202602")
+	for table, expected := range map[string]int64{"partition_event": 0, "partition_event_202601": 1, "partition_event_202602": 2} {
+		count, err := rdbms.QueryScalar[int64](dao, "SELECT COUNT(*) FROM "+table)
+		if err != nil || count != expected {
+			t.Fatalf("%s: expected %d rows, got %d err=%v", table, expected, count, err)
+		}
+	}
+
+	// 各分区的自增主键不重复, 按主键读写直接定位分区
+	if jan.ID == feb1.ID || feb1.ID == feb2.ID || jan.ID>>32 == feb1.ID>>32 {
+		t.Fatalf("unexpected partition keys: %d %d %d", jan.ID, feb1.ID, feb2.ID)
+	}
+	for _, event := range []*PartitionEvent{jan, feb1, feb2} {
+		loaded := &PartitionEvent{}
+		if err := dao.TableGet(loaded, event.ID); err != nil || loaded.Kind != event.Kind || !loaded.CreatedAt.Equal(event.CreatedAt) {
+			t.Fatalf("get %d: %+v err=%v", event.ID, loaded, err)
+		}
+	}
+	jan.Kind = "logout"
+	if count, err := dao.TableUpdate(jan); err != nil || count != 1 {
+		t.Fatalf("update: count=%d err=%v", count, err)
+	}
+	var all []PartitionEvent
+	if err := dao.TableFind(&all, rdbms.NewCriteria().Where("kind = ?", "logout")); err != nil || len(all) != 2 {
+		t.Fatalf("find over all partitions: %+v err=%v", all, err)
+	}
+	if count, err := dao.TableDelete("partition_event", feb1.ID); err != nil || count != 1 {
+		t.Fatalf("delete: count=%d err=%v", count, err)
+	}
+	if err := dao.TableGet(&PartitionEvent{}, feb1.ID); err == nil {
+		t.Fatal("expected deleted event to be gone")
+	}
+}
+
+func TestPartitionBetweenQueriesOnlyRangePartitions(t *testing.T) {
+	ds := newTestDataSource(t, "partition", partitionStatements, &PartitionEvent{})
+	if err := ds.EnablePartition(&PartitionEvent{}, rdbms.PartitionConfig{Column: "created_at", Location: time.UTC}); err != nil {
+		t.Fatalf("enable partition failed: %v", err)
+	}
+	dao := ds.NewDao()
+	for _, month := range []time.Month{1, 2, 3} {
+		for day := 1; day <= 2; day++ {
+			event := &PartitionEvent{Kind: "login", CreatedAt: time.Date(2026, month, day*10, 0, 0, 0, 0, time.UTC)}
+			if _, err := dao.TableInsert(event); err != nil {
+				t.Fatalf("insert failed: %v", err)
+			}
+		}
+	}
+
+	// 删除范围外的分区表, 范围查询仍然成功说明只查询了范围内的分区
+	if _, err := dao.Exec("DROP TABLE partition_event_202601"); err != nil {
+		t.Fatalf("drop failed: %v", err)
+	}
+	var events []PartitionEvent
+	from := time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)
+	if err := dao.TableFind(&events, rdbms.NewCriteria().Between(from, to)); err != nil {
+		t.Fatalf("ranged find failed: %v", err)
+	}
+	// 范围跨越两个分区, 边界按时间字段过滤
+	if len(events) != 2 || !events[0].CreatedAt.Equal(time.Date(2026, 2, 20, 0, 0, 0, 0, time.UTC)) ||
+		!events[1].CreatedAt.Equal(time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected ranged events: %+v", events)
+	}
+	var all []PartitionEvent
+	if err := dao.TableFind(&all, rdbms.NewCriteria()); err == nil {
+		t.Fatal("expected find over all partitions to reach the dropped table")
+	}
+}
+
+func TestPartitionRetentionDropsExpired(t *testing.T) {
+	ds := newTestDataSource(t, "partition", partitionStatements, &PartitionEvent{})
+	config := rdbms.PartitionConfig{Column: "created_at", Period: rdbms.PartitionDaily, Retention: 48 * time.Hour, Location: time.UTC}
+	if err := ds.EnablePartition(&PartitionEvent{}, config); err != nil {
+		t.Fatalf("enable partition failed: %v", err)
+	}
+	dao := ds.NewDao()
+	now := time.Now().UTC()
+	old := &PartitionEvent{Kind: "old", CreatedAt: now.AddDate(0, 0, -5)}
+	current := &PartitionEvent{Kind: "current", CreatedAt: now}
+	for _, event := range []*PartitionEvent{old, current} {
+		if _, err := dao.TableInsert(event); err != nil {
+			t.Fatalf("insert failed: %v", err)
+		}
+	}
+	oldTable := "partition_event_" + old.CreatedAt.Format("20060102")
+	currentTable := "partition_event_" + now.Format("20060102")
+	expectPartitions(t, ds, oldTable, currentTable)
+
+	status, err := ds.RunMaintenance(rdbms.MaintenanceRetention)
+	if err != nil || !strings.Contains(status.Result, oldTable) {
+		t.Fatalf("retention: %+v err=%v", status, err)
+	}
+	expectPartitions(t, ds, currentTable)
+	if err := dao.TableGet(&PartitionEvent{}, old.ID); err == nil {
+		t.Fatal("expected expired event to be dropped")
+	}
+	if err := dao.TableGet(&PartitionEvent{}, current.ID); err != nil {
+		t.Fatalf("current event lost: %v", err)
+	}
+
+	// 删除后的分区在再次写入时重新创建
+	if _, err := dao.TableInsert(&PartitionEvent{Kind: "late", CreatedAt: old.CreatedAt}); err != nil {
+		t.Fatalf("insert into dropped partition failed: %v", err)
+	}
+	expectPartitions(t, ds, oldTable, currentTable)
+}
+
+func TestPartitionFilesLimitedByAttachments(t *testing.T) {
+	ds := newTestDataSource(t, "partition", partitionStatements, &PartitionEvent{})
+	cases := []struct {
+		config rdbms.PartitionConfig
+		want   string
+	}{
+		{rdbms.PartitionConfig{Column: "created_at", Files: true}, "require Retention"},
+		{rdbms.PartitionConfig{Column: "created_at", Files: true, Retention: 365 * 24 * time.Hour}, "attaches at most"},
+		{rdbms.PartitionConfig{Column: "created_at", Files: true, Period: rdbms.PartitionDaily, Retention: 10 * 24 * time.Hour}, "attaches at most"},
+	}
+	for _, c := range cases {
+		if err := ds.EnablePartition(&PartitionEvent{}, c.config); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("%+v: expected error containing %q, got %v", c.config, c.want, err)
+		}
+	}
+
+	// 在上限内时每个分区一个文件, 过期后分离并删除文件
+	dir := t.TempDir()
+	config := rdbms.PartitionConfig{Column: "created_at", Files: true, Dir: dir, Period: rdbms.PartitionDaily, Retention: 48 * time.Hour, Location: time.UTC}
+	if err := ds.EnablePartition(&PartitionEvent{}, config); err != nil {
+		t.Fatalf("enable partition files failed: %v", err)
+	}
+	dao := ds.NewDao()
+	now := time.Now().UTC()
+	old := &PartitionEvent{Kind: "old", CreatedAt: now.AddDate(0, 0, -5)}
+	current := &PartitionEvent{Kind: "current", CreatedAt: now}
+	for _, event := range []*PartitionEvent{old, current} {
+		if _, err := dao.TableInsert(event); err != nil {
+			t.Fatalf("insert failed: %v", err)
+		}
+	}
+	oldName := "partition_event_" + old.CreatedAt.Format("20060102")
+	currentName := "partition_event_" + now.Format("20060102")
+	expectPartitions(t, ds, oldName+"."+oldName, currentName+"."+currentName)
+	var events []PartitionEvent
+	if err := dao.TableFind(&events, rdbms.NewCriteria()); err != nil || len(events) != 2 {
+		t.Fatalf("find over partition files: %+v err=%v", events, err)
+	}
+
+	if _, err := ds.RunMaintenance(rdbms.MaintenanceRetention); err != nil {
+		t.Fatalf("retention failed: %v", err)
+	}
+	expectPartitions(t, ds, currentName+"."+currentName)
+	if _, err := os.Stat(filepath.Join(dir, oldName+".db")); !os.IsNotExist(err) {
+		t.Fatalf("expected expired partition file removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, currentName+".db")); err != nil {
+		t.Fatalf("current partition file missing: %v", err)
+	}
+	var remaining []PartitionEvent
+	if err := dao.TableFind(&remaining, rdbms.NewCriteria()); err != nil || len(remaining) != 1 || remaining[0].ID != current.ID {
+		t.Fatalf("find after retention: %+v err=%v", remaining, err)
+	}
+}
@@ -0,0 +1,58 @@
+package lts_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+func newQueryDataSource(t *testing.T) rdbms.IDataSource {
+	t.Helper()
+	statements := []string{
+		`CREATE TABLE query_log (id INTEGER PRIMARY KEY AUTOINCREMENT, message TEXT NOT NULL)`,
+	}
+	return newTestDataSource(t, "query", statements)
+}
+
+func TestCteWithWriteKeywordInLiteralReadsFromReader(t *testing.T) {
+	ds := newQueryDataSource(t)
+	dao := ds.NewDao()
+
+	// 写协程被占用时, 只读语句仍然可以在读连接池执行
+	blocked, release := make(chan struct{}), make(chan struct{})
+	go dao.Write(func(conn sqlx.Ext) error {
+		close(blocked)
+		<-release
+		return nil
+	})
+	<-blocked
+	defer close(release)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := rdbms.QueryAll[string](dao, "WITH words(w) AS (SELECT 'DELETE' UNION ALL SELECT 'update') SELECT w FROM words")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read-only CTE was routed to the writer")
+	}
+}
+
+func TestCteWithWriteStatementRunsOnWriter(t *testing.T) {
+	ds := newQueryDataSource(t)
+	dao := ds.NewDao()
+	ids, err := rdbms.QueryAll[int64](dao, "WITH m(message) AS (SELECT 'hello') INSERT INTO query_log (message) SELECT message FROM m RETURNING id")
+	if err != nil {
+		t.Fatalf("write CTE failed: %v", err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("expected one inserted id, got %v", ids)
+	}
+}
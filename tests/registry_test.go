@@ -0,0 +1,36 @@
+package lts_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+func TestRegistryRemoveMainReassignsMain(t *testing.T) {
+	registry := rdbms.NewRegistry()
+	t.Cleanup(registry.Close)
+	dir := t.TempDir()
+	for _, id := range []string{"first", "_internal", "second"} {
+		dbPath := filepath.ToSlash(filepath.Join(dir, id+".db"))
+		if _, err := registry.NewDataSource(id, "sqlite:"+dbPath, nil, nil); err != nil {
+			t.Fatalf("create data source %s failed: %v", id, err)
+		}
+	}
+	if ds := registry.Get(""); ds == nil || ds.Id() != "first" {
+		t.Fatalf("unexpected main data source: %v", ds)
+	}
+
+	registry.Remove("first")
+	if ds := registry.Get(""); ds == nil || ds.Id() != "second" {
+		t.Fatalf("main data source not reassigned: %v", ds)
+	}
+	if dao := registry.NewDao(""); dao == nil || dao.DataSourceId() != "second" {
+		t.Fatalf("dao of main data source: %v", dao)
+	}
+
+	registry.Remove("second")
+	if ds := registry.Get(""); ds != nil {
+		t.Fatalf("internal data source must not become main: %v", ds.Id())
+	}
+}
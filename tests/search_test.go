@@ -0,0 +1,88 @@
+package lts_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+type SearchArticle struct {
+	rdbms.Table
+	ID       int64   `db:"id,pk"`
+	Category string  `db:"category"`
+	Title    string  `db:"title,search"`
+	Summary  *string `db:"summary,search"`
+}
+
+func newSearchDataSource(t *testing.T) (rdbms.IDataSource, rdbms.IDao) {
+	t.Helper()
+	statements := []string{
+		`CREATE TABLE search_article (id INTEGER PRIMARY KEY AUTOINCREMENT, category TEXT NOT NULL, title TEXT NOT NULL, summary TEXT)`,
+	}
+	ds := newTestDataSource(t, "search", statements, &SearchArticle{})
+	summary := "a sturdy phone case"
+	dao := ds.NewDao()
+	if _, err := dao.TableInsert(
+		&SearchArticle{Category: "phone", Title: "phone case", Summary: &summary},
+		&SearchArticle{Category: "phone", Title: "phone charger"},
+		&SearchArticle{Category: "laptop", Title: "laptop sleeve"},
+	); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	return ds, dao
+}
+
+func TestSearchWithNullSearchColumn(t *testing.T) {
+	_, dao := newSearchDataSource(t)
+	var articles []SearchArticle
+	hits, err := dao.Search(&articles, "charger", nil)
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if len(articles) != 1 || len(hits) != 1 || articles[0].Title != "phone charger" {
+		t.Fatalf("unexpected result: %+v %+v", articles, hits)
+	}
+	if hits[0].Highlights["title"] != "phone <b>charger</b>" || hits[0].Snippets["summary"] != "" {
+		t.Fatalf("unexpected hit: %+v", hits[0])
+	}
+}
+
+func TestSearchCriteriaWithUnqualifiedColumns(t *testing.T) {
+	_, dao := newSearchDataSource(t)
+	var articles []*SearchArticle
+	criteria := rdbms.NewCriteria().Where("category = ? AND rowid > ?", "phone", 0).OrderBy("id DESC")
+	hits, err := dao.Search(&articles, "phone", &rdbms.SearchOptions{Criteria: criteria})
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if len(articles) != 2 || len(hits) != 2 || articles[0].Title != "phone charger" {
+		t.Fatalf("unexpected result: %+v", articles)
+	}
+}
+
+func TestSearchLimitedColumnsAndUpdates(t *testing.T) {
+	_, dao := newSearchDataSource(t)
+	var articles []SearchArticle
+	if _, err := dao.Search(&articles, "sturdy", &rdbms.SearchOptions{Columns: []string{"title"}}); err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if len(articles) != 0 {
+		t.Fatalf("summary matched although only title is searched: %+v", articles)
+	}
+	if _, err := dao.Search(&articles, "x", &rdbms.SearchOptions{Columns: []string{"category"}}); err == nil || !strings.Contains(err.Error(), "not searchable") {
+		t.Fatalf("expected not searchable error, got %v", err)
+	}
+
+	// 主表更新后索引同步
+	if _, err := dao.Search(&articles, "sleeve", nil); err != nil || len(articles) != 1 {
+		t.Fatalf("search failed: %v %+v", err, articles)
+	}
+	articles[0].Title = "laptop stand"
+	if _, err := dao.TableUpdate(&articles[0]); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if _, err := dao.Search(&articles, "sleeve", nil); err != nil || len(articles) != 0 {
+		t.Fatalf("stale index entry: %v %+v", err, articles)
+	}
+}
@@ -0,0 +1,123 @@
+package lts_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+type ShardOrder struct {
+	rdbms.Table
+	ID       int64  `db:"id,pk"`
+	Customer string `db:"customer,shard"`
+	Amount   int64  `db:"amount"`
+}
+
+func newShardedOrders(t *testing.T, shards int) *rdbms.ShardedDataSource {
+	t.Helper()
+	sharded, err := rdbms.NewShardedDataSource("orders", rdbms.ShardConfig{
+		URLTemplate: "sqlite:" + filepath.ToSlash(filepath.Join(t.TempDir(), "orders_{shard}.db")),
+		Shards:      shards,
+		Statements:  []string{`CREATE TABLE shard_order (id INTEGER PRIMARY KEY AUTOINCREMENT, customer TEXT NOT NULL, amount INTEGER NOT NULL)`},
+		Tables:      []rdbms.ITable{&ShardOrder{}},
+	})
+	if err != nil {
+		t.Fatalf("create sharded data source failed: %v", err)
+	}
+	t.Cleanup(func() { sharded.Close() })
+	return sharded
+}
+
+func TestShardedQueryScalarRefusesToSpanShards(t *testing.T) {
+	sharded := newShardedOrders(t, 4)
+	dao := sharded.NewDao()
+	defer dao.Close()
+	for i := 0; i < 8; i++ {
+		order := &ShardOrder{Customer: fmt.Sprintf("c%d", i), Amount: 1}
+		if _, err := dao.TableInsert(order); err != nil {
+			t.Fatalf("insert failed: %v", err)
+		}
+	}
+
+	// 聚合结果不能只取最后一个分片
+	if count, err := rdbms.QueryScalar[int64](dao, "SELECT COUNT(*) FROM shard_order"); err == nil || !strings.Contains(err.Error(), "shards") {
+		t.Fatalf("expected cross shard error, got count=%d err=%v", count, err)
+	}
+	if _, err := rdbms.QueryOne[ShardOrder](dao, "SELECT * FROM shard_order LIMIT 1"); err == nil {
+		t.Fatal("expected QueryOne to refuse spanning shards")
+	}
+
+	// 逐个分片聚合
+	total := int64(0)
+	for _, ds := range sharded.Shards() {
+		shardDao := ds.NewDao()
+		count, err := rdbms.QueryScalar[int64](shardDao, "SELECT COUNT(*) FROM shard_order")
+		shardDao.Close()
+		if err != nil {
+			t.Fatalf("count shard failed: %v", err)
+		}
+		total += count
+	}
+	if total != 8 {
+		t.Fatalf("expected 8 rows over all shards, got %d", total)
+	}
+
+	// 逐行追加的查询合并所有分片
+	orders, err := rdbms.QueryAll[ShardOrder](dao, "SELECT * FROM shard_order")
+	if err != nil || len(orders) != 8 {
+		t.Fatalf("expected 8 orders, got %d err=%v", len(orders), err)
+	}
+}
+
+func TestShardedQueryScalarWithSingleShard(t *testing.T) {
+	sharded := newShardedOrders(t, 1)
+	dao := sharded.NewDao()
+	defer dao.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := dao.TableInsert(&ShardOrder{Customer: fmt.Sprintf("c%d", i), Amount: 2}); err != nil {
+			t.Fatalf("insert failed: %v", err)
+		}
+	}
+	sum, err := rdbms.QueryScalar[int64](dao, "SELECT SUM(amount) FROM shard_order")
+	if err != nil || sum != 6 {
+		t.Fatalf("expected sum 6, got %d err=%v", sum, err)
+	}
+}
+
+func TestShardedDaoAfterClose(t *testing.T) {
+	sharded := newShardedOrders(t, 2)
+	dao := sharded.NewDao()
+	order := &ShardOrder{Customer: "c1", Amount: 1}
+	if _, err := dao.TableInsert(order); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	if err := sharded.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	// 关闭后返回错误而不是越界
+	if _, err := dao.TableDelete("shard_order", order.ID); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Fatalf("expected closed error from TableDelete, got %v", err)
+	}
+	if err := dao.TableGet(&ShardOrder{}, order.ID); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Fatalf("expected closed error from TableGet, got %v", err)
+	}
+	if _, err := dao.AuditHistory(&ShardOrder{}, order.ID); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Fatalf("expected closed error from AuditHistory, got %v", err)
+	}
+	if err := sharded.NewDao().Read(func(conn sqlx.Queryer) error { return nil }); err == nil {
+		t.Fatal("expected closed error from Read")
+	}
+}
+
+func TestShardedAutoIncrementTableCannotBePartitioned(t *testing.T) {
+	sharded := newShardedOrders(t, 2)
+	err := sharded.Shards()[1].EnablePartition(&ShardOrder{}, rdbms.PartitionConfig{Column: "amount"})
+	if err == nil || !strings.Contains(err.Error(), "cannot be partitioned") {
+		t.Fatalf("expected sharded partition error, got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package lts_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+type TaggedNote struct {
+	rdbms.Table
+	ID   int64  `db:"id,pk"`
+	Body string `db:"body"`
+}
+
+type NoteVersion struct {
+	rdbms.Table
+	NoteID  int64  `db:"note_id,pk"`
+	Version int64  `db:"version,pk"`
+	Body    string `db:"body"`
+}
+
+func newTableDataSource(t *testing.T) rdbms.IDataSource {
+	t.Helper()
+	statements := []string{
+		`CREATE TABLE tagged_note (id INTEGER PRIMARY KEY AUTOINCREMENT, body TEXT NOT NULL DEFAULT '')`,
+		`CREATE TABLE note_version (note_id INTEGER NOT NULL, version INTEGER NOT NULL, body TEXT NOT NULL DEFAULT '', PRIMARY KEY (note_id, version))`,
+	}
+	return newTestDataSource(t, "table", statements, &TaggedNote{}, &NoteVersion{})
+}
+
+func TestInsertKeepsExplicitAutoIncrementKey(t *testing.T) {
+	ds := newTableDataSource(t)
+	dao := ds.NewDao()
+	explicit := &TaggedNote{ID: 100, Body: "explicit"}
+	generated := &TaggedNote{Body: "generated"}
+	ids, err := dao.TableInsert(explicit, generated)
+	if err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	if explicit.ID != 100 || ids[0] != 100 {
+		t.Fatalf("explicit key was replaced: model=%d ids=%v", explicit.ID, ids)
+	}
+	if generated.ID != 101 || ids[1] != 101 {
+		t.Fatalf("generated key not back-filled: model=%d ids=%v", generated.ID, ids)
+	}
+
+	loaded := &TaggedNote{}
+	if err := dao.TableGet(loaded, int64(100)); err != nil || loaded.Body != "explicit" {
+		t.Fatalf("get explicit key: %+v err=%v", loaded, err)
+	}
+}
+
+func TestCompositeKeyAcceptsZeroParts(t *testing.T) {
+	ds := newTableDataSource(t)
+	dao := ds.NewDao()
+	if _, err := dao.TableInsert(&NoteVersion{NoteID: 1, Version: 0, Body: "draft"}); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+
+	loaded := &NoteVersion{}
+	if err := dao.TableGet(loaded, rdbms.Key{int64(1), int64(0)}); err != nil || loaded.Body != "draft" {
+		t.Fatalf("get by key with zero part: %+v err=%v", loaded, err)
+	}
+	loaded.Body = "edited"
+	if count, err := dao.TableUpdate(loaded); err != nil || count != 1 {
+		t.Fatalf("update: count=%d err=%v", count, err)
+	}
+	if count, err := dao.TableDelete("note_version", rdbms.Key{int64(1), int64(0)}); err != nil || count != 1 {
+		t.Fatalf("delete: count=%d err=%v", count, err)
+	}
+	if _, err := dao.TableDelete("note_version", rdbms.Key{int64(1), nil}); err == nil {
+		t.Fatal("expected error for nil key part")
+	}
+}
+
+type Audited struct {
+	CreatedBy string `db:"created_by"`
+}
+
+type Stamped struct {
+	CreatedBy string `db:"created_by"`
+}
+
+type AmbiguousNote struct {
+	rdbms.Table
+	Audited
+	Stamped
+	ID int64 `db:"id,pk"`
+}
+
+func TestScanTableRejectsAmbiguousTags(t *testing.T) {
+	dbPath := filepath.ToSlash(filepath.Join(t.TempDir(), "ambiguous.db"))
+	t.Cleanup(rdbms.Close)
+	_, err := rdbms.NewDataSource("ambiguous", "sqlite:"+dbPath, nil, []rdbms.ITable{&AmbiguousNote{}})
+	if err == nil {
+		t.Fatal("expected error for db tag declared twice at the same depth")
+	}
+}
+
+type Versioned struct {
+	Versions []NoteVersion `rel:"has_many,fk=note_id"`
+}
+
+type VersionedNote struct {
+	rdbms.Table
+	*Versioned
+	ID   int64  `db:"id,pk"`
+	Body string `db:"body"`
+}
+
+func (VersionedNote) TableName() string { return "tagged_note" }
+
+func TestPreloadRelationOfEmbeddedStruct(t *testing.T) {
+	ds := newTableDataSource(t)
+	if err := ds.ScanTable(&VersionedNote{}); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	dao := ds.NewDao()
+	note := &VersionedNote{Body: "note"}
+	if _, err := dao.TableInsert(note); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	versions := []rdbms.ITable{&NoteVersion{NoteID: note.ID, Version: 0}, &NoteVersion{NoteID: note.ID, Version: 1}}
+	if _, err := dao.TableInsert(versions...); err != nil {
+		t.Fatalf("insert versions failed: %v", err)
+	}
+
+	loaded := &VersionedNote{}
+	if err := dao.TableGet(loaded, note.ID); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if err := dao.TablePreload(loaded, "Versions"); err != nil {
+		t.Fatalf("preload failed: %v", err)
+	}
+	if loaded.Versioned == nil || len(loaded.Versions) != 2 {
+		t.Fatalf("embedded relation not loaded: %+v", loaded.Versioned)
+	}
+}
+
+type MistypedNote struct {
+	rdbms.Table
+	ID   int64  `db:"id,pk"`
+	Body string `db:"body,serach"`
+}
+
+func TestScanTableRejectsUnknownOptions(t *testing.T) {
+	dbPath := filepath.ToSlash(filepath.Join(t.TempDir(), "mistyped.db"))
+	t.Cleanup(rdbms.Close)
+	_, err := rdbms.NewDataSource("mistyped", "sqlite:"+dbPath, nil, []rdbms.ITable{&MistypedNote{}})
+	if err == nil || !strings.Contains(err.Error(), "serach") {
+		t.Fatalf("expected unknown option error, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package lts_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+type TenantNote struct {
+	rdbms.Table
+	ID   int64  `db:"id,pk"`
+	Body string `db:"body"`
+}
+
+func newTenantRouter(t *testing.T) *rdbms.TenantRouter {
+	t.Helper()
+	router, err := rdbms.NewTenantRouter(rdbms.TenantConfig{
+		URLTemplate: "sqlite:" + filepath.ToSlash(filepath.Join(t.TempDir(), "{tenant}.db")),
+		Statements:  []string{`CREATE TABLE tenant_note (id INTEGER PRIMARY KEY AUTOINCREMENT, body TEXT NOT NULL)`},
+		Tables:      []rdbms.ITable{&TenantNote{}},
+	})
+	if err != nil {
+		t.Fatalf("create router failed: %v", err)
+	}
+	t.Cleanup(rdbms.Close)
+	return router
+}
+
+func TestTenantIdRejectsUpperCase(t *testing.T) {
+	router := newTenantRouter(t)
+	if _, err := router.NewTenantDao("Acme"); err == nil || !strings.Contains(err.Error(), "upper case") {
+		t.Fatalf("expected upper case error, got %v", err)
+	}
+
+	dao, err := router.NewDao(rdbms.ContextWithTenant(context.Background(), "acme"))
+	if err != nil {
+		t.Fatalf("create dao failed: %v", err)
+	}
+	defer dao.Close()
+	note := &TenantNote{Body: "hello"}
+	if _, err := dao.TableInsert(note); err != nil || note.ID == 0 {
+		t.Fatalf("insert failed: %+v err=%v", note, err)
+	}
+}
+
+func TestTenantRouterCloseKeepsDaosInUse(t *testing.T) {
+	router := newTenantRouter(t)
+	dao, err := router.NewTenantDao("acme")
+	if err != nil {
+		t.Fatalf("create dao failed: %v", err)
+	}
+	idle, err := router.NewTenantDao("idle")
+	if err != nil {
+		t.Fatalf("create dao failed: %v", err)
+	}
+	idle.Close()
+
+	// 路由关闭后, 使用中的租户库在 DAO Close 之前保持打开
+	rdbms.Close()
+	note := &TenantNote{Body: "in flight"}
+	if _, err := dao.TableInsert(note); err != nil || note.ID == 0 {
+		t.Fatalf("insert after router close failed: %+v err=%v", note, err)
+	}
+	if err := dao.Close(); err != nil {
+		t.Fatalf("close dao failed: %v", err)
+	}
+	if _, err := router.NewTenantDao("acme"); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Fatalf("expected closed router error, got %v", err)
+	}
+}
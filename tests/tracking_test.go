@@ -0,0 +1,124 @@
+package lts_test
+
+import (
+	"testing"
+
+	"github.com/sssxyd/go-lts-core/rdbms"
+)
+
+type TrackedAccount struct {
+	rdbms.Table
+	ID      int64  `db:"id,pk"`
+	Name    string `db:"name"`
+	Balance int64  `db:"balance"`
+}
+
+func newTrackingDataSource(t *testing.T) rdbms.IDataSource {
+	t.Helper()
+	statements := []string{
+		`CREATE TABLE tracked_account (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, balance INTEGER NOT NULL CHECK (balance >= 0))`,
+	}
+	return newTestDataSource(t, "tracking", statements, &TrackedAccount{})
+}
+
+func TestChangeTrackingWritesOnlyChangedColumns(t *testing.T) {
+	ds := newTrackingDataSource(t)
+	account := &TrackedAccount{Name: "alice", Balance: 10}
+	if _, err := ds.NewDao().TableInsert(account); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+
+	first := ds.NewDao(rdbms.WithChangeTracking())
+	second := ds.NewDao(rdbms.WithChangeTracking())
+	a, b := &TrackedAccount{}, &TrackedAccount{}
+	if err := first.TableGet(a, account.ID); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if err := second.TableGet(b, account.ID); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	a.Name = "alice2"
+	b.Balance = 20
+	if _, err := first.TableUpdate(a); err != nil {
+		t.Fatalf("update name failed: %v", err)
+	}
+	if _, err := second.TableUpdate(b); err != nil {
+		t.Fatalf("update balance failed: %v", err)
+	}
+
+	loaded := &TrackedAccount{}
+	if err := ds.NewDao().TableGet(loaded, account.ID); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if loaded.Name != "alice2" || loaded.Balance != 20 {
+		t.Fatalf("concurrent column updates clobbered each other: %+v", loaded)
+	}
+
+	// 没有变化时不写入
+	if count, err := first.TableUpdate(a); err != nil || count != 0 {
+		t.Fatalf("unchanged update: count=%d err=%v", count, err)
+	}
+}
+
+func TestChangeTrackingUpdatesModelsInOneTransaction(t *testing.T) {
+	ds := newTrackingDataSource(t)
+	accounts := []rdbms.ITable{&TrackedAccount{Name: "a", Balance: 10}, &TrackedAccount{Name: "b", Balance: 10}}
+	if _, err := ds.NewDao().TableInsert(accounts...); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+
+	dao := ds.NewDao(rdbms.WithChangeTracking())
+	var loaded []TrackedAccount
+	if err := dao.TableFind(&loaded, rdbms.NewCriteria().OrderBy("id")); err != nil {
+		t.Fatalf("find failed: %v", err)
+	}
+	loaded[0].Balance = 0
+	loaded[1].Balance = -1 // 违反 CHECK 约束
+	if _, err := dao.TableUpdate(&loaded[0], &loaded[1]); err == nil {
+		t.Fatal("expected check constraint error")
+	}
+
+	balances, err := rdbms.QueryAll[int64](ds.NewDao(), "SELECT balance FROM tracked_account ORDER BY id")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(balances) != 2 || balances[0] != 10 || balances[1] != 10 {
+		t.Fatalf("failed update was partially applied: %v", balances)
+	}
+
+	loaded[1].Balance = 5
+	count, err := dao.TableUpdate(&loaded[0], &loaded[1])
+	if err != nil || count != 2 {
+		t.Fatalf("update: count=%d err=%v", count, err)
+	}
+}
+
+func TestChangeTrackingKeepsUnwrittenColumnsAfterUpdateColumns(t *testing.T) {
+	ds := newTrackingDataSource(t)
+	account := &TrackedAccount{Name: "alice", Balance: 1}
+	if _, err := ds.NewDao().TableInsert(account); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+
+	dao := ds.NewDao(rdbms.WithChangeTracking())
+	loaded := &TrackedAccount{}
+	if err := dao.TableGet(loaded, account.ID); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	loaded.Name = "alice2"
+	loaded.Balance = 5
+	if _, err := dao.TableUpdateColumns(loaded, "name"); err != nil {
+		t.Fatalf("update columns failed: %v", err)
+	}
+	// 只写入了 name, balance 的修改仍然需要写入
+	if count, err := dao.TableUpdate(loaded); err != nil || count != 1 {
+		t.Fatalf("update: count=%d err=%v", count, err)
+	}
+	stored := &TrackedAccount{}
+	if err := ds.NewDao().TableGet(stored, account.ID); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if stored.Name != "alice2" || stored.Balance != 5 {
+		t.Fatalf("unwritten column was dropped: %+v", stored)
+	}
+}